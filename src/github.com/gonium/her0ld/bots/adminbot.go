@@ -0,0 +1,188 @@
+package her0ldbot
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	ADMINBOT_ACCOUNT_PREFIX    = "account:"
+	ADMINBOT_GRANTED           = "Granted role %s to %s."
+	ADMINBOT_REVOKED           = "Revoked %s."
+	ADMINBOT_NOTHING_REVOKED   = "No stored role found for %s."
+	ADMINBOT_NO_GRANTS         = "No roles granted."
+	ADMINBOT_ROLE_TOO_HIGH     = "You cannot grant or revoke role %s."
+	ADMINBOT_INVALID_HOSTMASK  = "Use a hostmask like nick!user@host or account:<name>."
+	ADMINBOT_WHOAMI            = "You are %s with role %s."
+	ADMINBOT_WHOAMI_ACCOUNT    = "You are %s (account %s) with role %s."
+	ADMINBOT_DATABASE_FAILURE  = "Failed to store the role, check log."
+	ADMINBOT_ROLE_LIST_HEADING = "Roles (default: %s):"
+)
+
+/* The AdminBot manages the roles of users at runtime. Roles granted
+ * here are stored in the database, roles from the configuration file
+ * are read-only. */
+type AdminBot struct {
+	BotName            string
+	NumMessagesHandled int
+	Commands           *CommandRouter
+	permissions        *Permissions
+}
+
+func NewAdminBot(name string, permissions *Permissions) *AdminBot {
+	b := &AdminBot{
+		BotName:            name,
+		NumMessagesHandled: 0,
+		Commands:           NewCommandRouter(),
+		permissions:        permissions,
+	}
+	b.Commands.Permissions = permissions
+	b.Commands.Register(&Command{
+		Name:    "whoami",
+		Help:    "show your hostmask and role",
+		Handler: b.whoami,
+	}, &Command{
+		Name: "role",
+		Role: RoleAdmin,
+		Subcommands: []*Command{
+			{
+				Name:    "grant",
+				Args:    []Arg{{Name: "role"}, {Name: "hostmask|account:name"}},
+				Help:    "grant a role (user, event-editor, admin, owner)",
+				Role:    RoleAdmin,
+				Handler: b.grant,
+			},
+			{
+				Name:    "revoke",
+				Args:    []Arg{{Name: "hostmask|account:name"}},
+				Help:    "revoke the roles granted to a hostmask or account",
+				Role:    RoleAdmin,
+				Handler: b.revoke,
+			},
+			{
+				Name:    "list",
+				Help:    "list all role bindings",
+				Role:    RoleAdmin,
+				Handler: b.list,
+			},
+		},
+	})
+	return b
+}
+
+// parseSubject splits the subject of a grant into hostmask and account.
+func parseSubject(subject string) (string, string, error) {
+	if strings.HasPrefix(strings.ToLower(subject), ADMINBOT_ACCOUNT_PREFIX) {
+		account := subject[len(ADMINBOT_ACCOUNT_PREFIX):]
+		if account != "" {
+			return "", account, nil
+		}
+	} else if strings.Contains(subject, "!") && strings.Contains(subject, "@") {
+		return subject, "", nil
+	}
+	return "", "", fmt.Errorf(ADMINBOT_INVALID_HOSTMASK)
+}
+
+// mayManage reports whether the sender of msg may grant or revoke the
+// given role: owners may manage all roles, everybody else only the
+// roles below their own.
+func (b *AdminBot) mayManage(msg InboundMessage, role Role) bool {
+	own := b.permissions.RoleOf(msg)
+	return own == RoleOwner || role < own
+}
+
+func (b *AdminBot) whoami(msg InboundMessage, args []string) ([]string, error) {
+	b.NumMessagesHandled += 1
+	role := b.permissions.RoleOf(msg)
+	if msg.Account != "" {
+		return []string{fmt.Sprintf(ADMINBOT_WHOAMI_ACCOUNT, msg.Source,
+			msg.Account, role)}, nil
+	}
+	return []string{fmt.Sprintf(ADMINBOT_WHOAMI, msg.Source, role)}, nil
+}
+
+func (b *AdminBot) grant(msg InboundMessage, args []string) ([]string, error) {
+	b.NumMessagesHandled += 1
+	role, err := ParseRole(args[0])
+	if err != nil {
+		return []string{err.Error()}, nil
+	}
+	hostmask, account, err := parseSubject(args[1])
+	if err != nil {
+		return []string{err.Error()}, nil
+	}
+	if !b.mayManage(msg, role) {
+		return []string{fmt.Sprintf(ADMINBOT_ROLE_TOO_HIGH, role)}, nil
+	}
+	if err := b.permissions.Grant(role, hostmask, account, msg.Source); err != nil {
+		return []string{ADMINBOT_DATABASE_FAILURE}, err
+	}
+	return []string{fmt.Sprintf(ADMINBOT_GRANTED, role, args[1])}, nil
+}
+
+func (b *AdminBot) revoke(msg InboundMessage, args []string) ([]string, error) {
+	b.NumMessagesHandled += 1
+	hostmask, account, err := parseSubject(args[0])
+	if err != nil {
+		return []string{err.Error()}, nil
+	}
+	// check all stored grants of the subject before revoking them
+	for _, grant := range b.permissions.Grants() {
+		if grant.Id == 0 || grant.Hostmask != hostmask ||
+			!strings.EqualFold(grant.Account, account) {
+			continue
+		}
+		role, _ := ParseRole(grant.Role)
+		if !b.mayManage(msg, role) {
+			return []string{fmt.Sprintf(ADMINBOT_ROLE_TOO_HIGH, role)}, nil
+		}
+	}
+	revoked, err := b.permissions.Revoke(hostmask, account)
+	if err != nil {
+		return []string{ADMINBOT_DATABASE_FAILURE}, err
+	}
+	if len(revoked) == 0 {
+		return []string{fmt.Sprintf(ADMINBOT_NOTHING_REVOKED, args[0])}, nil
+	}
+	var answer []string
+	for _, grant := range revoked {
+		answer = append(answer, fmt.Sprintf(ADMINBOT_REVOKED, grant))
+	}
+	return answer, nil
+}
+
+func (b *AdminBot) list(msg InboundMessage, args []string) ([]string, error) {
+	b.NumMessagesHandled += 1
+	grants := b.permissions.Grants()
+	if len(grants) == 0 {
+		return []string{ADMINBOT_NO_GRANTS}, nil
+	}
+	answer := []string{fmt.Sprintf(ADMINBOT_ROLE_LIST_HEADING,
+		b.permissions.DefaultRole)}
+	for _, grant := range grants {
+		line := "* " + grant.String()
+		if grant.Id == 0 {
+			line += " (configuration)"
+		} else {
+			line += fmt.Sprintf(" (granted by %s)", grant.GrantedBy)
+		}
+		answer = append(answer, line)
+	}
+	return answer, nil
+}
+
+func (b *AdminBot) ProcessChannelEvent(msg InboundMessage) ([]OutboundMessage, error) {
+	return b.Commands.Dispatch(msg)
+}
+
+func (b *AdminBot) ProcessQueryEvent(msg InboundMessage) ([]OutboundMessage, error) {
+	return b.ProcessChannelEvent(msg)
+}
+
+func (b *AdminBot) GetName() string {
+	return b.BotName
+}
+
+func (b *AdminBot) GetCommands() *CommandRouter {
+	return b.Commands
+}
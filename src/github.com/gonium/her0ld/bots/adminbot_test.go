@@ -0,0 +1,102 @@
+package her0ldbot
+
+import (
+	"fmt"
+	"github.com/gonium/her0ld"
+	"strings"
+	"testing"
+)
+
+const testAdminSource = "admin!user@admin.example.com"
+
+func MkAdminBot() *AdminBot {
+	return NewAdminBot("Adminbot", mkTestPermissions(her0ld.GeneralConfig{
+		Roles: []her0ld.RoleBinding{
+			{Role: "owner", Account: "boss"},
+			{Role: "admin", Hostmask: "admin!*@admin.example.com"},
+		},
+	}))
+}
+
+func adminCommand(t *testing.T, bot *AdminBot, msg InboundMessage) string {
+	answer, err := bot.ProcessChannelEvent(msg)
+	if err != nil {
+		t.Fatalf("Command %q failed: %s", msg.Message, err.Error())
+	}
+	var lines []string
+	for _, line := range answer {
+		lines = append(lines, line.Message)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestWhoami(t *testing.T) {
+	bot := MkAdminBot()
+	msg := InboundMessage{Target: "#test", Nick: "admin",
+		Source: testAdminSource, Message: "!whoami"}
+	expected := fmt.Sprintf(ADMINBOT_WHOAMI, testAdminSource, RoleAdmin)
+	if answer := adminCommand(t, bot, msg); answer != expected {
+		t.Fatalf("Expected %q, got %q", expected, answer)
+	}
+}
+
+func TestRoleGrantRevoke(t *testing.T) {
+	bot := MkAdminBot()
+	admin := InboundMessage{Target: "#test", Nick: "admin",
+		Source: testAdminSource}
+	editor := InboundMessage{Target: "#test", Nick: "editor",
+		Source: "editor!user@example.com"}
+
+	admin.Message = "!role grant event-editor editor!*@example.com"
+	answer := adminCommand(t, bot, admin)
+	if answer != fmt.Sprintf(ADMINBOT_GRANTED, RoleEventEditor, "editor!*@example.com") {
+		t.Fatalf("Unexpected answer to grant: %q", answer)
+	}
+	if role := bot.permissions.RoleOf(editor); role != RoleEventEditor {
+		t.Fatalf("Expected role event-editor, got %s", role)
+	}
+
+	// admins cannot create other admins
+	admin.Message = "!role grant admin account:friend"
+	answer = adminCommand(t, bot, admin)
+	if answer != fmt.Sprintf(ADMINBOT_ROLE_TOO_HIGH, RoleAdmin) {
+		t.Fatalf("Unexpected answer to grant: %q", answer)
+	}
+
+	// event editors cannot manage roles at all
+	editor.Message = "!role revoke editor!*@example.com"
+	answer = adminCommand(t, bot, editor)
+	if answer != fmt.Sprintf(COMMAND_NOT_AUTHORIZED, RoleAdmin) {
+		t.Fatalf("Unexpected answer to revoke: %q", answer)
+	}
+
+	admin.Message = "!role revoke editor!*@example.com"
+	answer = adminCommand(t, bot, admin)
+	if !strings.HasPrefix(answer, "Revoked") {
+		t.Fatalf("Unexpected answer to revoke: %q", answer)
+	}
+	if role := bot.permissions.RoleOf(editor); role != RoleUser {
+		t.Fatalf("Expected role user after revoke, got %s", role)
+	}
+}
+
+func TestRoleGrantByOwner(t *testing.T) {
+	bot := MkAdminBot()
+	owner := InboundMessage{Target: "#test", Nick: "boss",
+		Source: "boss!user@somewhere", Account: "boss"}
+	owner.Message = "!role grant admin account:friend"
+	answer := adminCommand(t, bot, owner)
+	if answer != fmt.Sprintf(ADMINBOT_GRANTED, RoleAdmin, "account:friend") {
+		t.Fatalf("Unexpected answer to grant: %q", answer)
+	}
+	owner.Message = "!role grant admin friend"
+	answer = adminCommand(t, bot, owner)
+	if answer != ADMINBOT_INVALID_HOSTMASK {
+		t.Fatalf("Unexpected answer to invalid grant: %q", answer)
+	}
+	owner.Message = "!role list"
+	answer = adminCommand(t, bot, owner)
+	if !strings.Contains(answer, "admin: account friend (granted by boss!user@somewhere)") {
+		t.Fatalf("Role list misses the new grant: %q", answer)
+	}
+}
@@ -0,0 +1,396 @@
+package her0ldbot
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	API_PREFIX       = "/api/events"
+	API_CONTENT_TYPE = "application/json; charset=utf-8"
+	// Request bodies larger than this are rejected.
+	API_MAX_BODY_SIZE = 64 * 1024
+	// Tokens shorter than this are too easy to guess.
+	API_MIN_TOKEN_LENGTH = 16
+)
+
+/* An apiError is answered as {"error": "..."} with its status code. */
+type apiError struct {
+	Status  int
+	Message string
+}
+
+func (e *apiError) Error() string {
+	return e.Message
+}
+
+func apiErrorf(status int, format string, a ...interface{}) *apiError {
+	return &apiError{Status: status, Message: fmt.Sprintf(format, a...)}
+}
+
+/* The JSON representation of an event or one of its occurrences. */
+type apiEvent struct {
+	Id          int        `json:"id"`
+	Uid         string     `json:"uid"`
+	Description string     `json:"description"`
+	Start       time.Time  `json:"start"`
+	End         *time.Time `json:"end,omitempty"`
+	Location    string     `json:"location,omitempty"`
+	Url         string     `json:"url,omitempty"`
+	Category    string     `json:"category,omitempty"`
+	Tags        []string   `json:"tags,omitempty"`
+	// The recurrence rule in RFC 5545 syntax and in words.
+	Rrule      string   `json:"rrule,omitempty"`
+	Recurrence string   `json:"recurrence,omitempty"`
+	Attendees  []string `json:"attendees,omitempty"`
+}
+
+/* The fields of a POST or PUT request, fields missing in a PUT request
+ * are not changed. Times are RFC 3339 or anything !event add accepts,
+ * empty values remove optional fields. */
+type apiEventInput struct {
+	Description *string   `json:"description"`
+	Start       *string   `json:"start"`
+	End         *string   `json:"end"`
+	Location    *string   `json:"location"`
+	Url         *string   `json:"url"`
+	Category    *string   `json:"category"`
+	Tags        *[]string `json:"tags"`
+	// An RFC 5545 rule or e.g. "weekly on tue", see ParseRecurrence.
+	Rrule *string `json:"rrule"`
+}
+
+// toAPI converts an event for the API.
+func (b *EventBot) toAPI(event Event, attendees []Attendee) apiEvent {
+	retval := apiEvent{
+		Id:          event.Id,
+		Uid:         event.UID(),
+		Description: event.Description,
+		Start:       event.Starttime.In(b.TimeLocation),
+		Location:    event.Location,
+		Url:         event.Url,
+		Category:    event.Category,
+		Tags:        event.TagList(),
+		Rrule:       event.Rrule,
+		Attendees:   attendeeNames(attendees),
+	}
+	if !event.Endtime.IsZero() {
+		end := event.Endtime.In(b.TimeLocation)
+		retval.End = &end
+	}
+	if rec, err := event.Recurrence(); err == nil && rec != nil {
+		retval.Recurrence = rec.Describe(b.TimeLocation)
+	}
+	return retval
+}
+
+// writeJSON answers with the given value as JSON.
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", API_CONTENT_TYPE)
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(value); err != nil {
+		log.Printf("Eventbot: API: Failed to write response - %s", err.Error())
+	}
+}
+
+func writeAPIError(w http.ResponseWriter, err *apiError) {
+	writeJSON(w, err.Status, map[string]string{"error": err.Message})
+}
+
+// authorize returns the name of the API token of the request.
+func (b *EventBot) authorize(r *http.Request) (string, *apiError) {
+	if len(b.APITokens) == 0 {
+		return "", apiErrorf(http.StatusForbidden, "changes via the API are disabled")
+	}
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return "", apiErrorf(http.StatusUnauthorized, "missing bearer token")
+	}
+	token := []byte(strings.TrimSpace(strings.TrimPrefix(auth, "Bearer ")))
+	for _, t := range b.APITokens {
+		if subtle.ConstantTimeCompare(token, []byte(t.Token)) == 1 {
+			return t.Name, nil
+		}
+	}
+	return "", apiErrorf(http.StatusUnauthorized, "invalid token")
+}
+
+// serveDebugVars serves the runtime statistics of expvar to the holders
+// of an API token.
+func (b *EventBot) serveDebugVars(w http.ResponseWriter, r *http.Request) {
+	if _, err := b.authorize(r); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	expvar.Handler().ServeHTTP(w, r)
+}
+
+// serveAPI dispatches the requests to /api/events and /api/events/<id>.
+func (b *EventBot) serveAPI(w http.ResponseWriter, r *http.Request) {
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, API_PREFIX), "/")
+	var err *apiError
+	switch {
+	case id == "" && r.Method == "GET":
+		err = b.apiList(w, r)
+	case id == "" && r.Method == "POST":
+		err = b.apiWrite(w, r, b.apiCreate)
+	case id != "" && r.Method == "GET":
+		err = b.apiGet(w, id)
+	case id != "" && r.Method == "PUT":
+		err = b.apiWrite(w, r, func(w http.ResponseWriter, r *http.Request,
+			changedBy string) *apiError {
+			return b.apiUpdate(w, r, id, changedBy)
+		})
+	case id != "" && r.Method == "DELETE":
+		err = b.apiWrite(w, r, func(w http.ResponseWriter, r *http.Request,
+			changedBy string) *apiError {
+			return b.apiDelete(w, id, changedBy)
+		})
+	default:
+		if id == "" {
+			w.Header().Set("Allow", "GET, POST")
+		} else {
+			w.Header().Set("Allow", "GET, PUT, DELETE")
+		}
+		err = apiErrorf(http.StatusMethodNotAllowed, "method %s not allowed",
+			r.Method)
+	}
+	if err != nil {
+		writeAPIError(w, err)
+	}
+}
+
+// apiWrite authorizes a change and serializes it with the commands.
+func (b *EventBot) apiWrite(w http.ResponseWriter, r *http.Request,
+	handler func(http.ResponseWriter, *http.Request, string) *apiError) *apiError {
+	name, err := b.authorize(r)
+	if err != nil {
+		if err.Status == http.StatusUnauthorized {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="her0ld"`)
+		}
+		return err
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return handler(w, r, "api:"+name)
+}
+
+// parseAPITime parses an RFC 3339 time or a date as accepted by !event
+// add.
+func (b *EventBot) parseAPITime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.In(b.TimeLocation), nil
+	}
+	words := strings.Fields(value)
+	t, used, err := ParseTime(words, time.Now().In(b.TimeLocation))
+	if err == nil && used < len(words) {
+		err = fmt.Errorf("unexpected %q", strings.Join(words[used:], " "))
+	}
+	return t, err
+}
+
+func (b *EventBot) apiList(w http.ResponseWriter, r *http.Request) *apiError {
+	query := r.URL.Query()
+	from := time.Now()
+	if value := query.Get("from"); value != "" {
+		t, err := b.parseAPITime(value)
+		if err != nil {
+			return apiErrorf(http.StatusBadRequest, "invalid from: %s", err.Error())
+		}
+		from = t
+	}
+	var events EventList
+	if value := query.Get("to"); value != "" {
+		to, err := b.parseAPITime(value)
+		if err != nil {
+			return apiErrorf(http.StatusBadRequest, "invalid to: %s", err.Error())
+		}
+		if !to.After(from) {
+			return apiErrorf(http.StatusBadRequest, "to must be after from")
+		}
+		events = b.eventsBetween(from, to)
+	} else {
+		events = b.upcomingEvents(from)
+	}
+	category := query.Get("category")
+	attendees := b.attendees(events)
+	retval := []apiEvent{}
+	for _, event := range events {
+		if category == "" || event.InCategory(category) {
+			retval = append(retval, b.toAPI(event, attendees[event.Id]))
+		}
+	}
+	writeJSON(w, http.StatusOK, retval)
+	return nil
+}
+
+// apiFind returns the event with the given id.
+func (b *EventBot) apiFind(id string) (Event, *apiError) {
+	event, ok := b.findEvent(id)
+	if !ok {
+		return event, apiErrorf(http.StatusNotFound, "unknown event %s", id)
+	}
+	return event, nil
+}
+
+func (b *EventBot) apiGet(w http.ResponseWriter, id string) *apiError {
+	event, err := b.apiFind(id)
+	if err != nil {
+		return err
+	}
+	attendees := b.attendees([]Event{event})
+	writeJSON(w, http.StatusOK, b.toAPI(event, attendees[event.Id]))
+	return nil
+}
+
+// readInput decodes the body of a POST or PUT request.
+func readInput(r *http.Request) (apiEventInput, *apiError) {
+	var input apiEventInput
+	dec := json.NewDecoder(io.LimitReader(r.Body, API_MAX_BODY_SIZE))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&input); err != nil {
+		return input, apiErrorf(http.StatusBadRequest, "invalid JSON: %s",
+			err.Error())
+	}
+	return input, nil
+}
+
+// applyInput changes the fields of the event given in the input, like
+// !event edit does. It returns the changes.
+func (b *EventBot) applyInput(event *Event, input apiEventInput) ([]EventAudit, *apiError) {
+	var changes []EventAudit
+	now := time.Now().In(b.TimeLocation)
+	set := func(key string, value *string) *apiError {
+		if value == nil {
+			return nil
+		}
+		words := strings.Fields(*value)
+		if len(words) == 0 && key != "desc" {
+			words = []string{EVENTBOT_EMPTY_VALUE}
+		}
+		if key == "date" || key == "end" {
+			// RFC 3339 times are converted to a format of the commands
+			if t, err := time.Parse(time.RFC3339, *value); err == nil {
+				words = []string{t.In(b.TimeLocation).Format(EVENTBOT_TIME_FORMAT)}
+			}
+		}
+		field, oldValue, newValue, err := b.setField(event, key, words, now)
+		if err != nil {
+			return apiErrorf(http.StatusBadRequest, "%s", err.Error())
+		}
+		if oldValue != newValue {
+			changes = append(changes, EventAudit{Field: field,
+				OldValue: oldValue, NewValue: newValue})
+		}
+		return nil
+	}
+	if input.Tags != nil {
+		tags := strings.Join(*input.Tags, ",")
+		if err := set("tags", &tags); err != nil {
+			return nil, err
+		}
+	}
+	for _, field := range []struct {
+		key   string
+		value *string
+	}{
+		// the start comes first, the end may depend on it
+		{"date", input.Start},
+		{"desc", input.Description},
+		{"end", input.End},
+		{"location", input.Location},
+		{"url", input.Url},
+		{"category", input.Category},
+	} {
+		if err := set(field.key, field.value); err != nil {
+			return nil, err
+		}
+	}
+	if input.Rrule != nil {
+		rrule := ""
+		if value := strings.TrimSpace(*input.Rrule); value != "" {
+			rec, err := ParseRRule(value)
+			if err != nil {
+				rec, err = ParseRecurrence(strings.Fields(value), b.TimeLocation)
+			}
+			if err != nil {
+				return nil, apiErrorf(http.StatusBadRequest, EVENTBOT_INVALID_RECURRENCE,
+					err.Error())
+			}
+			rrule = rec.String()
+		}
+		if rrule != event.Rrule {
+			changes = append(changes, EventAudit{Field: "rrule",
+				OldValue: event.Rrule, NewValue: rrule})
+			event.Rrule = rrule
+		}
+	}
+	return changes, nil
+}
+
+func (b *EventBot) apiCreate(w http.ResponseWriter, r *http.Request,
+	changedBy string) *apiError {
+	input, err := readInput(r)
+	if err != nil {
+		return err
+	}
+	if input.Start == nil || input.Description == nil ||
+		strings.TrimSpace(*input.Description) == "" {
+		return apiErrorf(http.StatusBadRequest, "start and description are required")
+	}
+	var event Event
+	if _, err := b.applyInput(&event, input); err != nil {
+		return err
+	}
+	b.Db.Create(&event)
+	log.Printf("Eventbot: %s added event %d on %s", changedBy, event.Id,
+		event.Starttime.Format(EVENTBOT_TIME_FORMAT))
+	w.Header().Set("Location", API_PREFIX+"/"+strconv.Itoa(event.Id))
+	writeJSON(w, http.StatusCreated, b.toAPI(event, nil))
+	return nil
+}
+
+func (b *EventBot) apiUpdate(w http.ResponseWriter, r *http.Request, id string,
+	changedBy string) *apiError {
+	event, err := b.apiFind(id)
+	if err != nil {
+		return err
+	}
+	input, err := readInput(r)
+	if err != nil {
+		return err
+	}
+	start := event.Starttime
+	changes, err := b.applyInput(&event, input)
+	if err != nil {
+		return err
+	}
+	if len(changes) > 0 {
+		b.updateEvent(event, start, changes, changedBy)
+	}
+	attendees := b.attendees([]Event{event})
+	writeJSON(w, http.StatusOK, b.toAPI(event, attendees[event.Id]))
+	return nil
+}
+
+func (b *EventBot) apiDelete(w http.ResponseWriter, id string,
+	changedBy string) *apiError {
+	event, err := b.apiFind(id)
+	if err != nil {
+		return err
+	}
+	b.deleteEvent(event)
+	log.Printf("Eventbot: %s deleted event %d", changedBy, event.Id)
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
@@ -0,0 +1,171 @@
+package her0ldbot
+
+import (
+	"encoding/json"
+	"github.com/gonium/her0ld"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testAPIToken = "0123456789abcdef"
+
+func mkAPIBot() *EventBot {
+	bot := MkEventBot()
+	bot.APITokens = []her0ld.APIToken{{Name: "website", Token: testAPIToken}}
+	return bot
+}
+
+// apiRequest sends a request to the API and decodes the JSON answer
+// into result.
+func apiRequest(t *testing.T, bot *EventBot, method, path, token, body string,
+	result interface{}) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	recorder := httptest.NewRecorder()
+	bot.Handler().ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusNoContent {
+		if ct := recorder.Header().Get("Content-Type"); ct != API_CONTENT_TYPE {
+			t.Fatalf("%s %s: unexpected content type %s", method, path, ct)
+		}
+		if result != nil {
+			if err := json.Unmarshal(recorder.Body.Bytes(), result); err != nil {
+				t.Fatalf("%s %s: invalid JSON %s: %s", method, path,
+					recorder.Body.String(), err.Error())
+			}
+		}
+	}
+	return recorder
+}
+
+func TestAPIAuthentication(t *testing.T) {
+	bot := mkAPIBot()
+	body := `{"start": "2016-05-05T19:00:00+02:00", "description": "Meetup"}`
+	for _, test := range []struct {
+		token  string
+		status int
+	}{
+		{"", http.StatusUnauthorized},
+		{"wrong-token-0123456", http.StatusUnauthorized},
+		{testAPIToken, http.StatusCreated},
+	} {
+		var result map[string]interface{}
+		recorder := apiRequest(t, bot, "POST", "/api/events", test.token, body,
+			&result)
+		if recorder.Code != test.status {
+			t.Fatalf("Token %q: expected status %d, got %d", test.token,
+				test.status, recorder.Code)
+		}
+		if test.status != http.StatusCreated && result["error"] == "" {
+			t.Fatalf("Token %q: missing error message", test.token)
+		}
+	}
+
+	bot = MkEventBot()
+	var result map[string]string
+	recorder := apiRequest(t, bot, "DELETE", "/api/events/1", testAPIToken, "",
+		&result)
+	if recorder.Code != http.StatusForbidden || result["error"] == "" {
+		t.Fatalf("Writes without tokens must be forbidden: %d %#v",
+			recorder.Code, result)
+	}
+}
+
+// The runtime statistics are not public.
+func TestDebugVars(t *testing.T) {
+	bot := mkAPIBot()
+	var result map[string]interface{}
+	if recorder := apiRequest(t, bot, "GET", "/debug/vars", "", "", &result); recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected status %d without token, got %d", http.StatusUnauthorized,
+			recorder.Code)
+	}
+	recorder := apiRequest(t, bot, "GET", "/debug/vars", testAPIToken, "", &result)
+	if recorder.Code != http.StatusOK || result["memstats"] == nil {
+		t.Fatalf("Unexpected answer %d %s", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestAPIEvents(t *testing.T) {
+	bot := mkAPIBot()
+	var created apiEvent
+	recorder := apiRequest(t, bot, "POST", "/api/events", testAPIToken,
+		`{"start": "05.05.2016-19:00", "end": "22:00", "description": "Meetup",
+		"location": "Lab", "url": "https://example.com", "category": "meetup",
+		"tags": ["Python", "go"], "rrule": "weekly"}`, &created)
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("Unexpected status %d: %s", recorder.Code, recorder.Body.String())
+	}
+	if recorder.Header().Get("Location") != "/api/events/1" {
+		t.Fatalf("Unexpected location %s", recorder.Header().Get("Location"))
+	}
+	start := time.Date(2016, 5, 5, 19, 0, 0, 0, bot.TimeLocation)
+	if created.Id != 1 || !created.Start.Equal(start) || created.End == nil ||
+		!created.End.Equal(start.Add(3*time.Hour)) || created.Rrule != "FREQ=WEEKLY" ||
+		strings.Join(created.Tags, ",") != "python,go" || created.Location != "Lab" {
+		t.Fatalf("Unexpected event %#v", created)
+	}
+
+	var updated apiEvent
+	recorder = apiRequest(t, bot, "PUT", "/api/events/1", testAPIToken,
+		`{"start": "2016-05-06T19:00:00+02:00", "location": ""}`, &updated)
+	if recorder.Code != http.StatusOK || !updated.Start.Equal(start.AddDate(0, 0, 1)) ||
+		!updated.End.Equal(start.AddDate(0, 0, 1).Add(3*time.Hour)) ||
+		updated.Location != "" || updated.Description != "Meetup" {
+		t.Fatalf("Unexpected update %d %#v", recorder.Code, updated)
+	}
+	var audits []EventAudit
+	bot.Db.Where("event_id = ?", 1).Find(&audits)
+	if len(audits) != 2 || audits[0].ChangedBy != "api:website" {
+		t.Fatalf("Unexpected audit records %#v", audits)
+	}
+
+	var events []apiEvent
+	recorder = apiRequest(t, bot, "GET",
+		"/api/events?from=2016-05-01T00:00:00Z&to=2016-05-31T00:00:00Z", "", "",
+		&events)
+	if recorder.Code != http.StatusOK || len(events) != 4 {
+		t.Fatalf("Expected 4 occurrences in May, got %d %#v", recorder.Code, events)
+	}
+	recorder = apiRequest(t, bot, "GET",
+		"/api/events?from=2016-05-01T00:00:00Z&to=2016-05-31T00:00:00Z&category=party",
+		"", "", &events)
+	if len(events) != 0 {
+		t.Fatalf("Expected no events of category party, got %#v", events)
+	}
+
+	for _, test := range []struct {
+		method, path, body string
+		status             int
+	}{
+		{"GET", "/api/events/2", "", http.StatusNotFound},
+		{"GET", "/api/events?from=someday", "", http.StatusBadRequest},
+		{"PUT", "/api/events/1", `{"url": "ftp://example.com"}`, http.StatusBadRequest},
+		{"PUT", "/api/events/1", `{"colour": "red"}`, http.StatusBadRequest},
+		{"PUT", "/api/events/1", `not json`, http.StatusBadRequest},
+		{"POST", "/api/events", `{"description": "Meetup"}`, http.StatusBadRequest},
+		{"PATCH", "/api/events/1", `{}`, http.StatusMethodNotAllowed},
+	} {
+		var result map[string]string
+		recorder := apiRequest(t, bot, test.method, test.path, testAPIToken,
+			test.body, &result)
+		if recorder.Code != test.status || result["error"] == "" {
+			t.Fatalf("%s %s %s: expected status %d with error, got %d %s",
+				test.method, test.path, test.body, test.status, recorder.Code,
+				recorder.Body.String())
+		}
+	}
+
+	recorder = apiRequest(t, bot, "DELETE", "/api/events/1", testAPIToken, "", nil)
+	if recorder.Code != http.StatusNoContent {
+		t.Fatalf("Unexpected status %d", recorder.Code)
+	}
+	var remaining []Event
+	bot.Db.Find(&remaining)
+	if len(remaining) != 0 {
+		t.Fatalf("Event not deleted: %#v", remaining)
+	}
+}
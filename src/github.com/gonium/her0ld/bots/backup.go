@@ -0,0 +1,170 @@
+package her0ldbot
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/gonium/her0ld"
+	"github.com/mattn/go-sqlite3"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	// Backups are named her0ld-20160502-030000.db, .json etc.
+	BACKUP_PREFIX      = "her0ld-"
+	BACKUP_TIME_FORMAT = "20060102-150405"
+	// Copies of SQLite databases, see BackupSettings.Format.
+	BACKUP_FORMAT_SQLITE = "sqlite"
+	EVENTBOT_BACKUP_KEEP = 7
+)
+
+// backupFormat returns the format of the backups of the database.
+func backupFormat(settings her0ld.BackupSettings, dialect string) string {
+	if settings.Format != "" {
+		return settings.Format
+	}
+	if dialect == her0ld.DB_SQLITE {
+		return BACKUP_FORMAT_SQLITE
+	}
+	return EXPORT_FORMAT_JSON
+}
+
+// checkBackupSettings validates the backup settings of the bot.
+func checkBackupSettings(settings her0ld.BackupSettings, dialect string) error {
+	if settings.Directory == "" {
+		return fmt.Errorf("no backup directory given")
+	}
+	if settings.Keep < 0 {
+		return fmt.Errorf("negative number of backups to keep")
+	}
+	switch backupFormat(settings, dialect) {
+	case EXPORT_FORMAT_JSON, EXPORT_FORMAT_CSV, EXPORT_FORMAT_ICS:
+	case BACKUP_FORMAT_SQLITE:
+		if dialect != her0ld.DB_SQLITE {
+			return fmt.Errorf("cannot copy a %s database, use %s, %s or %s",
+				dialect, EXPORT_FORMAT_JSON, EXPORT_FORMAT_CSV, EXPORT_FORMAT_ICS)
+		}
+	default:
+		return fmt.Errorf("unknown backup format %q, use %s, %s or %s",
+			settings.Format, EXPORT_FORMAT_JSON, EXPORT_FORMAT_CSV, EXPORT_FORMAT_ICS)
+	}
+	return nil
+}
+
+// BackupDatabase writes a backup of the database into the backup
+// directory and removes the oldest backups of the same format. SQLite
+// databases are copied consistently, see copySQLite.
+func (b *EventBot) BackupDatabase() (string, error) {
+	settings := b.BackupSettings
+	format := backupFormat(settings, b.Db.NewScope(nil).Dialect().GetName())
+	extension := "." + format
+	if format == BACKUP_FORMAT_SQLITE {
+		extension = ".db"
+	}
+	if err := os.MkdirAll(settings.Directory, 0700); err != nil {
+		return "", err
+	}
+	name := filepath.Join(settings.Directory,
+		BACKUP_PREFIX+time.Now().Format(BACKUP_TIME_FORMAT)+extension)
+	// incomplete backups are not mistaken for complete ones
+	tmp := name + ".tmp"
+	os.Remove(tmp)
+	if format == BACKUP_FORMAT_SQLITE {
+		if err := copySQLite(b.Db.DB(), tmp); err != nil {
+			os.Remove(tmp)
+			return "", err
+		}
+	} else {
+		out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return "", err
+		}
+		err = b.ExportEvents(out, format)
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(tmp)
+			return "", err
+		}
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	keep := settings.Keep
+	if keep == 0 {
+		keep = EVENTBOT_BACKUP_KEEP
+	}
+	return name, rotateBackups(settings.Directory, extension, keep)
+}
+
+// copySQLite copies an SQLite database into a new file with the online
+// backup API. Unlike VACUUM INTO, it is supported by the SQLite version
+// bundled with go-sqlite3.
+func copySQLite(db *sql.DB, name string) error {
+	dest, err := sql.Open("sqlite3", name)
+	if err != nil {
+		return err
+	}
+	defer dest.Close()
+	ctx := context.Background()
+	destConn, err := dest.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer destConn.Close()
+	srcConn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+	return destConn.Raw(func(destDriver interface{}) error {
+		return srcConn.Raw(func(srcDriver interface{}) error {
+			destSQLite, ok := destDriver.(*sqlite3.SQLiteConn)
+			srcSQLite, srcOk := srcDriver.(*sqlite3.SQLiteConn)
+			if !ok || !srcOk {
+				return fmt.Errorf("not an SQLite database")
+			}
+			backup, err := destSQLite.Backup("main", srcSQLite, "main")
+			if err != nil {
+				return err
+			}
+			for {
+				// copy all pages at once, unless the database is locked
+				done, err := backup.Step(-1)
+				if err != nil {
+					backup.Finish()
+					return err
+				}
+				if done {
+					return backup.Finish()
+				}
+				time.Sleep(100 * time.Millisecond)
+			}
+		})
+	})
+}
+
+// rotateBackups removes all but the newest keep backups with the given
+// extension.
+func rotateBackups(directory, extension string, keep int) error {
+	backups, err := filepath.Glob(filepath.Join(directory, BACKUP_PREFIX+"*"+extension))
+	if err != nil {
+		return err
+	}
+	// the names sort by their time
+	sort.Strings(backups)
+	for len(backups) > keep {
+		if err := os.Remove(backups[0]); err != nil {
+			return err
+		}
+		log.Printf("Eventbot: Removed old backup %s", backups[0])
+		backups = backups[1:]
+	}
+	return nil
+}
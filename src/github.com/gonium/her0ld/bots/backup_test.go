@@ -0,0 +1,92 @@
+package her0ldbot
+
+import (
+	"github.com/gonium/her0ld"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckBackupSettings(t *testing.T) {
+	valid := []her0ld.BackupSettings{
+		{Directory: "/backups"},
+		{Directory: "/backups", Format: EXPORT_FORMAT_CSV, Keep: 3},
+	}
+	for _, settings := range valid {
+		if err := checkBackupSettings(settings, her0ld.DB_SQLITE); err != nil {
+			t.Fatalf("%v: unexpected error %s", settings, err.Error())
+		}
+	}
+	if err := checkBackupSettings(her0ld.BackupSettings{Directory: "/backups"},
+		her0ld.DB_POSTGRES); err != nil {
+		t.Fatalf("PostgreSQL databases should be exported: %s", err.Error())
+	}
+	invalid := []her0ld.BackupSettings{
+		{},
+		{Directory: "/backups", Keep: -1},
+		{Directory: "/backups", Format: "xml"},
+	}
+	for _, settings := range invalid {
+		if err := checkBackupSettings(settings, her0ld.DB_SQLITE); err == nil {
+			t.Fatalf("%v: expected an error", settings)
+		}
+	}
+	if err := checkBackupSettings(her0ld.BackupSettings{Directory: "/backups",
+		Format: BACKUP_FORMAT_SQLITE}, her0ld.DB_MYSQL); err == nil {
+		t.Fatalf("MySQL databases cannot be copied")
+	}
+}
+
+// The copy must also work with the SQLite bundled with go-sqlite3
+// (3.12), which lacks e.g. VACUUM INTO, not only with a recent system
+// library (-tags libsqlite3).
+func TestBackupDatabase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "her0ld-backup-test")
+	if err != nil {
+		t.Fatalf("Failed to create directory: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	bot := mkExportBot(t)
+	defer bot.Db.Close()
+	bot.BackupSettings = her0ld.BackupSettings{Directory: dir, Keep: 2}
+
+	// older backups are removed, backups of other formats are kept
+	for _, old := range []string{"her0ld-20160501-030000.db", "her0ld-20160502-030000.db",
+		"her0ld-20160502-030000.json"} {
+		ioutil.WriteFile(filepath.Join(dir, old), []byte("old"), 0600)
+	}
+	name, err := bot.BackupDatabase()
+	if err != nil {
+		t.Fatalf("Backup failed: %s", err.Error())
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	if len(files) != 3 || !strings.HasSuffix(name, ".db") {
+		t.Fatalf("Unexpected backups %v, new backup %s", files, name)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "her0ld-20160501-030000.db")); !os.IsNotExist(err) {
+		t.Fatalf("Oldest backup not removed")
+	}
+
+	// the copy is a complete database
+	db, err := ConnectDatabase(her0ld.EventbotConfig{DBFile: name})
+	if err != nil {
+		t.Fatalf("Failed to open backup: %s", err.Error())
+	}
+	defer db.Close()
+	var count int
+	db.Model(&Event{}).Count(&count)
+	if count != 2 {
+		t.Fatalf("Expected 2 events in the backup, got %d", count)
+	}
+
+	bot.BackupSettings.Format = EXPORT_FORMAT_JSON
+	if name, err = bot.BackupDatabase(); err != nil || !strings.HasSuffix(name, ".json") {
+		t.Fatalf("JSON backup failed: %s (%v)", name, err)
+	}
+	data, _ := ioutil.ReadFile(name)
+	if !strings.Contains(string(data), `"description": "Grillen"`) {
+		t.Fatalf("Unexpected JSON backup %s", data)
+	}
+}
@@ -2,22 +2,58 @@ package her0ldbot
 
 import (
 	"fmt"
+	"log"
 	"strings"
 )
 
 /* a bot receives this type of message. */
 type InboundMessage struct {
-	Channel string
-	Nick    string
+	// The channel the message was sent to. For query messages, this is
+	// the nick of the bot itself.
+	Target string
+	// The sender: its nick and full hostmask (nick!user@host).
+	Nick   string
+	Source string
+	// The services account of the sender, empty if unknown.
+	Account string
 	Message string
+	// The current nick of the bot, used for nick-addressed commands.
+	BotNick string
+	// Sends answers after the message has been processed, e.g. the
+	// results of slow commands. Set by the connection.
+	Followup func(lines []OutboundMessage)
 }
 
 func (b InboundMessage) String() string {
-	return fmt.Sprintf("C: %s: N: %s - M: %s", b.Channel, b.Nick, b.Message)
+	return fmt.Sprintf("T: %s: N: %s - M: %s", b.Target, b.Nick, b.Message)
 }
 
 func (b InboundMessage) IsChannelEvent() bool {
-	return strings.HasPrefix(b.Channel, "#")
+	return strings.HasPrefix(b.Target, "#")
+}
+
+// ReplyTarget returns the destination for answers to this message:
+// the channel for channel messages, the sender for query messages.
+func (b InboundMessage) ReplyTarget() string {
+	if b.IsChannelEvent() {
+		return b.Target
+	}
+	return b.Nick
+}
+
+// Reply addresses the given lines to the reply target of the message.
+func (b InboundMessage) Reply(lines ...string) []OutboundMessage {
+	return strings2reply(b.ReplyTarget(), lines)
+}
+
+// ReplyLater sends the given lines to the reply target of the message
+// via Followup. Without Followup, the lines are logged.
+func (b InboundMessage) ReplyLater(lines ...string) {
+	if b.Followup == nil {
+		log.Printf("No follow-up for %s, dropping: %s", b, strings.Join(lines, " / "))
+		return
+	}
+	b.Followup(b.Reply(lines...))
 }
 
 /* a bot responds with messages of this type. */
@@ -31,7 +67,7 @@ type Bot interface {
 	ProcessChannelEvent(incoming InboundMessage) ([]OutboundMessage, error)
 	ProcessQueryEvent(incoming InboundMessage) ([]OutboundMessage, error)
 	GetName() string
-	GetHelpLines() []string
+	GetCommands() *CommandRouter
 }
 
 func strings2reply(dest string, lines []string) []OutboundMessage {
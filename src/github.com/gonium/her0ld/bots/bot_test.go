@@ -0,0 +1,40 @@
+package her0ldbot
+
+import (
+	"testing"
+)
+
+func TestReplyTarget(t *testing.T) {
+	channelmsg := InboundMessage{
+		Target:  "#channel",
+		Nick:    "testnick",
+		Source:  "testnick!user@example.com",
+		Message: "foo",
+	}
+	if !channelmsg.IsChannelEvent() {
+		t.Fatalf("Message to %s should be a channel event", channelmsg.Target)
+	}
+	if channelmsg.ReplyTarget() != "#channel" {
+		t.Fatalf("Channel message should be answered in the channel, got %s",
+			channelmsg.ReplyTarget())
+	}
+	querymsg := InboundMessage{
+		Target:  "mybotnick",
+		Nick:    "testnick",
+		Source:  "testnick!user@example.com",
+		Message: "foo",
+	}
+	if querymsg.IsChannelEvent() {
+		t.Fatalf("Message to %s should be a query event", querymsg.Target)
+	}
+	reply := querymsg.Reply("bar", "baz")
+	if len(reply) != 2 {
+		t.Fatalf("Expected two reply lines, got %d", len(reply))
+	}
+	for _, line := range reply {
+		if line.Destination != "testnick" {
+			t.Fatalf("Query message should be answered to the sender, got %s",
+				line.Destination)
+		}
+	}
+}
@@ -0,0 +1,287 @@
+package her0ldbot
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+const (
+	DEFAULT_COMMAND_PREFIX = "!"
+	// Placeholder for the current nick of the bot in a command prefix,
+	// e.g. "{nick}:" accepts "her0ld: ping".
+	COMMAND_PREFIX_NICK       = "{nick}"
+	COMMAND_USAGE             = "usage: %s"
+	COMMAND_INVALID_ARGUMENTS = "invalid arguments: %s"
+	COMMAND_NOT_AUTHORIZED    = "Only users with role %s can do this."
+)
+
+/* An argument of a command, used for validation and the help text. */
+type Arg struct {
+	Name     string
+	Optional bool
+	// A rest argument takes all remaining words, e.g. a description. It
+	// must be the last argument.
+	Rest bool
+}
+
+func (a Arg) String() string {
+	name := a.Name
+	if a.Rest {
+		name += "..."
+	}
+	if a.Optional {
+		return "[" + name + "]"
+	}
+	return "<" + name + ">"
+}
+
+/* A command handler receives the parsed arguments and returns the lines
+ * of the answer. */
+type CommandHandler func(msg InboundMessage, args []string) ([]string, error)
+
+/* A command is invoked as <prefix><name> [args], e.g. "!ping". Commands
+ * can be grouped as subcommands of another command, e.g. "!event add".
+ * The handler of a command with subcommands is called if no subcommand
+ * matches. */
+type Command struct {
+	Name    string
+	Aliases []string
+	Args    []Arg
+	Help    string
+	// The minimum role needed to run the command.
+	Role        Role
+	Handler     CommandHandler
+	Subcommands []*Command
+}
+
+func (c *Command) matches(name string) bool {
+	if strings.EqualFold(c.Name, name) {
+		return true
+	}
+	for _, alias := range c.Aliases {
+		if strings.EqualFold(alias, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func findCommand(commands []*Command, name string) *Command {
+	for _, cmd := range commands {
+		if cmd.matches(name) {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// checkArgs validates the number of arguments against the argument
+// specification of the command.
+func (c *Command) checkArgs(args []string) bool {
+	min, max := 0, len(c.Args)
+	for _, arg := range c.Args {
+		if !arg.Optional {
+			min++
+		}
+		if arg.Rest {
+			max = -1
+		}
+	}
+	return len(args) >= min && (max < 0 || len(args) <= max)
+}
+
+/* The CommandRouter dispatches messages to the registered commands of a
+ * bot. Every bot has its own router, but all routers of a connection
+ * share the same prefix and permissions. Without permissions, everybody
+ * has the user role. */
+type CommandRouter struct {
+	Prefix      string
+	Permissions *Permissions
+	commands    []*Command
+}
+
+func NewCommandRouter() *CommandRouter {
+	return &CommandRouter{Prefix: DEFAULT_COMMAND_PREFIX}
+}
+
+func (r *CommandRouter) Register(cmds ...*Command) {
+	r.commands = append(r.commands, cmds...)
+}
+
+func (r *CommandRouter) Commands() []*Command {
+	return r.commands
+}
+
+// prefix returns the command prefix with the placeholder replaced by
+// the given nick.
+func (r *CommandRouter) prefix(botnick string) string {
+	return strings.Replace(r.Prefix, COMMAND_PREFIX_NICK, botnick, -1)
+}
+
+// stripPrefix returns the message without the command prefix and
+// whether the prefix was found.
+func (r *CommandRouter) stripPrefix(msg InboundMessage) (string, bool) {
+	if strings.Contains(r.Prefix, COMMAND_PREFIX_NICK) && msg.BotNick == "" {
+		return "", false
+	}
+	prefix := r.prefix(msg.BotNick)
+	if len(msg.Message) <= len(prefix) ||
+		!strings.EqualFold(msg.Message[:len(prefix)], prefix) {
+		return "", false
+	}
+	return strings.TrimSpace(msg.Message[len(prefix):]), true
+}
+
+// Dispatch calls the handler of the command contained in the message.
+// It returns no answer if the message is not a command of this router.
+func (r *CommandRouter) Dispatch(msg InboundMessage) ([]OutboundMessage, error) {
+	line, ok := r.stripPrefix(msg)
+	if !ok {
+		return nil, nil
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	cmd := findCommand(r.commands, fields[0])
+	if cmd == nil {
+		return nil, nil
+	}
+	args, err := SplitArgs(line)
+	if err != nil {
+		return msg.Reply(fmt.Sprintf(COMMAND_INVALID_ARGUMENTS, err.Error())), nil
+	}
+	path := []*Command{cmd}
+	role := cmd.Role
+	args = args[1:]
+	for len(cmd.Subcommands) > 0 {
+		var sub *Command
+		if len(args) > 0 {
+			sub = findCommand(cmd.Subcommands, args[0])
+		}
+		if sub == nil {
+			break
+		}
+		cmd = sub
+		path = append(path, sub)
+		if sub.Role > role {
+			role = sub.Role
+		}
+		args = args[1:]
+	}
+	if r.Permissions.RoleOf(msg) < role {
+		return msg.Reply(fmt.Sprintf(COMMAND_NOT_AUTHORIZED, role)), nil
+	}
+	if cmd.Handler == nil {
+		// command group without a default handler
+		return msg.Reply(r.helpLines(path, msg.BotNick)...), nil
+	}
+	if len(cmd.Subcommands) == 0 && !cmd.checkArgs(args) {
+		return msg.Reply(fmt.Sprintf(COMMAND_USAGE,
+			r.usage(path, msg.BotNick))), nil
+	}
+	lines, err := cmd.Handler(msg, args)
+	if err != nil {
+		return nil, err
+	}
+	return msg.Reply(lines...), nil
+}
+
+// usage returns the invocation of the last command of the path, e.g.
+// "!event add <date> <description...>".
+func (r *CommandRouter) usage(path []*Command, botnick string) string {
+	var words []string
+	for _, cmd := range path {
+		words = append(words, cmd.Name)
+	}
+	for _, arg := range path[len(path)-1].Args {
+		words = append(words, arg.String())
+	}
+	prefix := r.prefix(botnick)
+	if strings.Contains(r.Prefix, COMMAND_PREFIX_NICK) {
+		// "her0ld: ping" reads better than "her0ld:ping"
+		prefix += " "
+	}
+	return prefix + strings.Join(words, " ")
+}
+
+func (r *CommandRouter) helpLines(path []*Command, botnick string) []string {
+	cmd := path[len(path)-1]
+	var lines []string
+	if cmd.Help != "" {
+		line := fmt.Sprintf("* %s - %s", r.usage(path, botnick), cmd.Help)
+		if len(cmd.Aliases) > 0 {
+			line += fmt.Sprintf(" (alias: %s)", strings.Join(cmd.Aliases, ", "))
+		}
+		if cmd.Role > RoleUser {
+			line += fmt.Sprintf(" [%s]", cmd.Role)
+		}
+		lines = append(lines, line)
+	}
+	for _, sub := range cmd.Subcommands {
+		subpath := append(append([]*Command{}, path...), sub)
+		lines = append(lines, r.helpLines(subpath, botnick)...)
+	}
+	return lines
+}
+
+// HelpLines returns the help text of the given command and all of its
+// subcommands. If cmd is nil, the help of all commands is returned.
+func (r *CommandRouter) HelpLines(cmd *Command, botnick string) []string {
+	if cmd != nil {
+		return r.helpLines([]*Command{cmd}, botnick)
+	}
+	var lines []string
+	for _, c := range r.commands {
+		lines = append(lines, r.helpLines([]*Command{c}, botnick)...)
+	}
+	return lines
+}
+
+// SplitArgs splits a command line into words. Double quotes group
+// several words into one argument, a backslash escapes the following
+// character. Single quotes are kept as they are, e.g. in "Tom's party".
+func SplitArgs(line string) ([]string, error) {
+	var args []string
+	var current []rune
+	var quote rune
+	inWord, escaped := false, false
+	for _, c := range line {
+		switch {
+		case escaped:
+			current = append(current, c)
+			escaped = false
+		case c == '\\':
+			inWord, escaped = true, true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				current = append(current, c)
+			}
+		case c == '"':
+			inWord = true
+			quote = c
+		case unicode.IsSpace(c):
+			if inWord {
+				args = append(args, string(current))
+				current = current[:0]
+				inWord = false
+			}
+		default:
+			inWord = true
+			current = append(current, c)
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("missing closing quote %c", quote)
+	}
+	if escaped {
+		return nil, fmt.Errorf("trailing backslash")
+	}
+	if inWord {
+		args = append(args, string(current))
+	}
+	return args, nil
+}
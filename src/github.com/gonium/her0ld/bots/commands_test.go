@@ -0,0 +1,140 @@
+package her0ldbot
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func mkTestRouter() (*CommandRouter, *[]string) {
+	var received []string
+	record := func(msg InboundMessage, args []string) ([]string, error) {
+		received = args
+		return []string{"ok"}, nil
+	}
+	router := NewCommandRouter()
+	router.Register(&Command{
+		Name:    "greet",
+		Aliases: []string{"hello"},
+		Args:    []Arg{{Name: "nick"}, {Name: "greeting", Optional: true}},
+		Help:    "greets somebody",
+		Handler: record,
+	}, &Command{
+		Name: "note",
+		Subcommands: []*Command{
+			{
+				Name:    "add",
+				Args:    []Arg{{Name: "text", Rest: true}},
+				Help:    "adds a note",
+				Handler: record,
+			},
+		},
+	})
+	return router, &received
+}
+
+func TestSplitArgs(t *testing.T) {
+	cases := map[string][]string{
+		`add foo bar`:                  {"add", "foo", "bar"},
+		`  add   foo  `:                {"add", "foo"},
+		`add "foo bar" baz`:            {"add", "foo bar", "baz"},
+		`add location="Main Hall"`:     {"add", "location=Main Hall"},
+		`add Tom's party`:              {"add", "Tom's", "party"},
+		`add \"quoted\"`:               {"add", `"quoted"`},
+		`add ""`:                       {"add", ""},
+		`add "mixed 'quotes'" and\ so`: {"add", "mixed 'quotes'", "and so"},
+	}
+	for line, expected := range cases {
+		args, err := SplitArgs(line)
+		if err != nil {
+			t.Fatalf("Failed to split %s: %s", line, err.Error())
+		}
+		if !reflect.DeepEqual(args, expected) {
+			t.Fatalf("Splitting %s: expected %#v, got %#v", line, expected, args)
+		}
+	}
+	for _, line := range []string{`add "foo`, `add foo\`} {
+		if _, err := SplitArgs(line); err == nil {
+			t.Fatalf("Splitting %s should fail", line)
+		}
+	}
+}
+
+func TestDispatch(t *testing.T) {
+	router, received := mkTestRouter()
+	cases := map[string][]string{
+		"!greet alice":                  {"alice"},
+		"!GREET alice":                  {"alice"},
+		"!hello alice \"good morning\"": {"alice", "good morning"},
+		"!note add buy milk":            {"buy", "milk"},
+	}
+	for line, expected := range cases {
+		msg := InboundMessage{Target: "#test", Nick: "testnick", Message: line}
+		answer, err := router.Dispatch(msg)
+		if err != nil {
+			t.Fatalf("Dispatching %s failed: %s", line, err.Error())
+		}
+		if len(answer) != 1 || answer[0].Message != "ok" {
+			t.Fatalf("Unexpected answer to %s: %#v", line, answer)
+		}
+		if !reflect.DeepEqual(*received, expected) {
+			t.Fatalf("Dispatching %s: expected args %#v, got %#v", line,
+				expected, *received)
+		}
+	}
+	for _, line := range []string{"greet alice", "!greetings alice", "!", "foo"} {
+		msg := InboundMessage{Target: "#test", Nick: "testnick", Message: line}
+		answer, err := router.Dispatch(msg)
+		if err != nil || answer != nil {
+			t.Fatalf("%s should be ignored, got %#v, %v", line, answer, err)
+		}
+	}
+}
+
+func TestDispatchUsage(t *testing.T) {
+	router, _ := mkTestRouter()
+	cases := map[string]string{
+		"!greet":              fmt.Sprintf(COMMAND_USAGE, "!greet <nick> [greeting]"),
+		"!greet a b c":        fmt.Sprintf(COMMAND_USAGE, "!greet <nick> [greeting]"),
+		"!note add":           fmt.Sprintf(COMMAND_USAGE, "!note add <text...>"),
+		"!note foo":           "* !note add <text...> - adds a note",
+		"!greet \"unfinished": fmt.Sprintf(COMMAND_INVALID_ARGUMENTS, "missing closing quote \""),
+	}
+	for line, expected := range cases {
+		msg := InboundMessage{Target: "#test", Nick: "testnick", Message: line}
+		answer, err := router.Dispatch(msg)
+		if err != nil {
+			t.Fatalf("Dispatching %s failed: %s", line, err.Error())
+		}
+		if len(answer) != 1 || answer[0].Message != expected {
+			t.Fatalf("Answer to %s: expected %s, got %#v", line, expected, answer)
+		}
+	}
+}
+
+func TestNickPrefix(t *testing.T) {
+	router, received := mkTestRouter()
+	router.Prefix = COMMAND_PREFIX_NICK + ":"
+	msg := InboundMessage{
+		Target:  "#test",
+		Nick:    "testnick",
+		Message: "Her0ld: greet alice",
+		BotNick: "her0ld",
+	}
+	answer, err := router.Dispatch(msg)
+	if err != nil || len(answer) != 1 {
+		t.Fatalf("Nick-addressed command failed: %#v, %v", answer, err)
+	}
+	if !reflect.DeepEqual(*received, []string{"alice"}) {
+		t.Fatalf("Unexpected arguments %#v", *received)
+	}
+	msg.Message = "!greet alice"
+	if answer, _ := router.Dispatch(msg); answer != nil {
+		t.Fatalf("Command without nick prefix should be ignored")
+	}
+	lines := router.HelpLines(nil, "her0ld")
+	expected := "* her0ld: greet <nick> [greeting] - greets somebody (alias: hello)"
+	if len(lines) != 2 || lines[0] != expected {
+		t.Fatalf("Unexpected help lines %#v", lines)
+	}
+}
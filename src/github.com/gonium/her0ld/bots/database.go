@@ -0,0 +1,63 @@
+package her0ldbot
+
+import (
+	"fmt"
+	"github.com/gonium/her0ld"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/mysql"
+	_ "github.com/jinzhu/gorm/dialects/postgres"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"strings"
+)
+
+// mysqlDSN enables the conversion of DATETIME columns to time.Time,
+// which gorm relies on.
+func mysqlDSN(dsn string) string {
+	if strings.Contains(dsn, "parseTime=") {
+		return dsn
+	}
+	if strings.Contains(dsn, "?") {
+		return dsn + "&parseTime=true"
+	}
+	return dsn + "?parseTime=true"
+}
+
+// ConnectDatabase opens the database configured in
+// EventbotConfig.Database without changing its tables.
+func ConnectDatabase(cfg her0ld.EventbotConfig) (*gorm.DB, error) {
+	dialect, dsn, err := cfg.DatabaseSource()
+	if err != nil {
+		return nil, err
+	}
+	if dialect == her0ld.DB_MYSQL {
+		dsn = mysqlDSN(dsn)
+	}
+	return gorm.Open(dialect, dsn)
+}
+
+// OpenDatabase opens the database shared by all bots and applies the
+// pending migrations, see Migrator. With ManualMigrations, it fails
+// instead if migrations are pending.
+func OpenDatabase(cfg her0ld.EventbotConfig) (*gorm.DB, error) {
+	db, err := ConnectDatabase(cfg)
+	if err != nil {
+		return nil, err
+	}
+	migrator := NewMigrator(db, cfg)
+	if cfg.Database.ManualMigrations {
+		version, err := migrator.Version()
+		if err == nil && version < migrator.Latest() {
+			err = fmt.Errorf("database schema version %d is outdated, "+
+				"run her0ld migrate up to update to version %d", version,
+				migrator.Latest())
+		}
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+	} else if _, err := migrator.Up(0); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
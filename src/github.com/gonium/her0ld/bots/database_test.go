@@ -0,0 +1,142 @@
+package her0ldbot
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gonium/her0ld"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testDatabases returns the databases to run the backend tests against:
+// SQLite and, if their data source names are set in the environment,
+// PostgreSQL and MySQL. The tables of these databases are dropped. See
+// the section Tests of the README.
+func testDatabases() map[string]her0ld.DatabaseSettings {
+	databases := map[string]her0ld.DatabaseSettings{
+		her0ld.DB_SQLITE: {
+			Dialect: her0ld.DB_SQLITE,
+			DSN:     filepath.Join(os.TempDir(), "her0ld-backend-test.db"),
+		},
+	}
+	if dsn := os.Getenv("HER0LD_TEST_POSTGRES_DSN"); dsn != "" {
+		databases[her0ld.DB_POSTGRES] = her0ld.DatabaseSettings{
+			Dialect: her0ld.DB_POSTGRES, DSN: dsn}
+	}
+	if dsn := os.Getenv("HER0LD_TEST_MYSQL_DSN"); dsn != "" {
+		databases[her0ld.DB_MYSQL] = her0ld.DatabaseSettings{
+			Dialect: her0ld.DB_MYSQL, DSN: dsn}
+	}
+	return databases
+}
+
+// mkBackendBot creates an eventbot with an empty database.
+func mkBackendBot(t *testing.T, settings her0ld.DatabaseSettings) *EventBot {
+	cfg := her0ld.EventbotConfig{Timezone: "Europe/Berlin", Database: settings}
+	db, err := ConnectDatabase(cfg)
+	if err != nil {
+		t.Fatalf("Failed to open database: %s", err.Error())
+	}
+	db.DropTableIfExists(append([]interface{}{&SchemaVersion{}}, tablesV1...)...)
+	if _, err := (&Migrator{Db: db, Migrations: migrations}).Up(0); err != nil {
+		t.Fatalf("Failed to create tables: %s", err.Error())
+	}
+	bot := NewEventBot("EventBot", db, cfg, genConfig)
+	if bot.Commands.Permissions, err = NewPermissions(genConfig, db); err != nil {
+		t.Fatalf("Failed to set up permissions: %s", err.Error())
+	}
+	return bot
+}
+
+func TestDatabaseBackends(t *testing.T) {
+	for name, settings := range testDatabases() {
+		t.Run(name, func(t *testing.T) {
+			bot := mkBackendBot(t, settings)
+			defer bot.Db.Close()
+			testBackend(t, bot)
+		})
+	}
+}
+
+func testBackend(t *testing.T, bot *EventBot) {
+	expect := func(command, expected string) {
+		answer := strings.Join(eventCommand(t, bot, command), "\n")
+		if !strings.HasPrefix(answer, expected) {
+			t.Fatalf("%s: expected %q, got %q", command, expected, answer)
+		}
+	}
+	start := time.Date(2030, 5, 5, 19, 0, 0, 0, bot.TimeLocation)
+	expect("add 05.05.2030-19:00 Treffen in den Räumen location=Hackerspace end=22:00",
+		fmt.Sprintf(EVENTBOT_CMD_ADD_SUCCESS, 1, "Sun 5 May 2030, 19:00"))
+	expect("repeat 1 weekly", "Event 1 repeats")
+	bot.Db.Create(&EventException{EventId: 1, Starttime: start.AddDate(0, 0, 7)})
+	occurrences := bot.eventsBetween(start, start.AddDate(0, 0, 21))
+	if len(occurrences) != 2 || !occurrences[1].Starttime.Equal(start.AddDate(0, 0, 14)) ||
+		occurrences[0].Description != "Treffen in den Räumen" ||
+		!occurrences[0].Endtime.Equal(start.Add(3*time.Hour)) {
+		t.Fatalf("Unexpected occurrences: %v", occurrences)
+	}
+
+	expect("join 1", fmt.Sprintf(EVENTBOT_CMD_JOIN_SUCCESS, "Nick", 1))
+	expect("who 1", fmt.Sprintf(EVENTBOT_CMD_WHO_ATTENDEES, 1, 1, "Nick"))
+	expect("edit 1 desc Grillen", "Event 1: description changed")
+	var audits []EventAudit
+	bot.Db.Where("event_id = ?", 1).Order("id").Find(&audits)
+	if len(audits) != 2 || audits[0].Field != "rrule" || audits[1].NewValue != "Grillen" {
+		t.Fatalf("Unexpected audits: %v", audits)
+	}
+
+	// role grants are looked up case-insensitively by account
+	if err := bot.Commands.Permissions.Grant(RoleAdmin, "", "Alice", "test"); err != nil {
+		t.Fatalf("Failed to grant role: %s", err.Error())
+	}
+	if revoked, err := bot.Commands.Permissions.Revoke("", "alice"); err != nil || len(revoked) != 1 {
+		t.Fatalf("Failed to revoke role: %v %v", revoked, err)
+	}
+
+	// the outbox keeps failed mails for a retry
+	transport := &testTransport{err: errors.New("connection refused")}
+	bot.MailTransport = transport
+	entry, _ := bot.SendQueued(testMail())
+	bot.Db.Model(entry).UpdateColumn("next_attempt", time.Now().Add(-time.Second))
+	transport.err = nil
+	bot.DeliverOutbox()
+	if len(transport.delivered) != 1 {
+		t.Fatalf("Due mail not delivered")
+	}
+
+	expect("del 1", fmt.Sprintf(EVENTBOT_CMD_DELETED_EVENT, 1))
+	var events, exceptions, attendees int
+	bot.Db.Model(&Event{}).Count(&events)
+	bot.Db.Model(&EventException{}).Count(&exceptions)
+	bot.Db.Model(&Attendee{}).Count(&attendees)
+	if events != 0 || exceptions != 0 || attendees != 0 {
+		t.Fatalf("Deleted event left %d events, %d exceptions, %d attendees",
+			events, exceptions, attendees)
+	}
+}
+
+func TestMysqlDSN(t *testing.T) {
+	for dsn, expected := range map[string]string{
+		"her0ld:pw@/her0ld":                    "her0ld:pw@/her0ld?parseTime=true",
+		"her0ld:pw@/her0ld?charset=utf8mb4":    "her0ld:pw@/her0ld?charset=utf8mb4&parseTime=true",
+		"her0ld:pw@/her0ld?parseTime=true&x=y": "her0ld:pw@/her0ld?parseTime=true&x=y",
+	} {
+		if mysqlDSN(dsn) != expected {
+			t.Fatalf("%s: expected %s, got %s", dsn, expected, mysqlDSN(dsn))
+		}
+	}
+}
+
+func TestOpenDatabaseErrors(t *testing.T) {
+	if _, err := OpenDatabase(her0ld.EventbotConfig{}); err == nil {
+		t.Fatalf("Opening a database without configuration should fail")
+	}
+	cfg := her0ld.EventbotConfig{Database: her0ld.DatabaseSettings{Dialect: "oracle", DSN: "x"}}
+	if _, err := OpenDatabase(cfg); err == nil {
+		t.Fatalf("Opening a database with an unknown dialect should fail")
+	}
+}
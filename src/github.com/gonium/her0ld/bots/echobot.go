@@ -9,17 +9,22 @@ import (
 type EchoBot struct {
 	BotName            string
 	NumMessagesHandled int
+	Commands           *CommandRouter
 }
 
 func NewEchoBot(name string) *EchoBot {
-	return &EchoBot{BotName: name, NumMessagesHandled: 0}
+	return &EchoBot{
+		BotName:            name,
+		NumMessagesHandled: 0,
+		Commands:           NewCommandRouter(),
+	}
 }
 
 func (b *EchoBot) ProcessChannelEvent(msg InboundMessage) ([]OutboundMessage, error) {
 	b.NumMessagesHandled += 1
 	answer := fmt.Sprintf("%s: %s", msg.Nick, msg.Message)
 	reply := make([]OutboundMessage, 1)
-	reply[0] = OutboundMessage{Destination: msg.Channel, Message: answer}
+	reply[0] = OutboundMessage{Destination: msg.ReplyTarget(), Message: answer}
 	return reply, nil
 }
 
@@ -27,7 +32,7 @@ func (b *EchoBot) ProcessQueryEvent(msg InboundMessage) ([]OutboundMessage, erro
 	b.NumMessagesHandled += 1
 	answer := fmt.Sprintf("%s", msg.Message)
 	reply := make([]OutboundMessage, 1)
-	reply[0] = OutboundMessage{Destination: msg.Channel, Message: answer}
+	reply[0] = OutboundMessage{Destination: msg.ReplyTarget(), Message: answer}
 	return reply, nil
 }
 
@@ -35,7 +40,7 @@ func (b *EchoBot) GetName() string {
 	return b.BotName
 }
 
-func (b *EchoBot) GetHelpLines() []string {
-	return []string{"Echoes everything you send to this bot.",
-		"Intended for debugging during development."}
+// The echobot has no commands - it echoes everything you send to it.
+func (b *EchoBot) GetCommands() *CommandRouter {
+	return b.Commands
 }
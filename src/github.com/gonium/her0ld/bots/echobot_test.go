@@ -10,7 +10,7 @@ func TestValidMsg(t *testing.T) {
 	texts := [2]string{"!ping", "foobar"}
 	for _, text := range texts {
 		msg := InboundMessage{
-			Channel: "test",
+			Target:  "#test",
 			Nick:    "testnick",
 			Message: text,
 		}
@@ -2,63 +2,219 @@ package her0ldbot
 
 import (
 	"bytes"
+	"crypto/tls"
 	"fmt"
 	"github.com/gonium/her0ld"
 	"github.com/jinzhu/gorm"
-	_ "github.com/jinzhu/gorm/dialects/sqlite"
 	"github.com/robfig/cron"
-	"io"
+	htmltemplate "html/template"
+	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
+	"net/mail"
 	"net/smtp"
 	"sort"
 	"strconv"
 	"strings"
-	"text/template"
+	"sync"
 	"time"
 )
 
 const (
-	EVENTBOT_INVALID_TIME_FORMAT = "Invalid time format, use e.g.  01.01.2016-16:00"
+	EVENTBOT_INVALID_TIME_FORMAT = "Invalid time format, use e.g.  01.01.2016-16:00, 2016-01-01T16:00, tomorrow 19:00, next friday 20:00 or in 3 days 19:00"
 	EVENTBOT_TIME_FORMAT         = "02.01.2006-15:04"
 	EVENTBOT_INVALID_COMMAND     = "invalid command - see !event help."
-	EVENTBOT_PREFIX              = "!event"
-	EVENTBOT_CMD_HELP            = "help"
-	EVENTBOT_HELP_TEXT           = `* !event help - this text
-* !event add <date> <description> - add an event
-* !event list - list all upcoming events (with id)
-* !event del <id> - remove the event with the given id
-* !event today - show all events today`
+	EVENTBOT_CMD                 = "event"
+	// the event command with the default command prefix
+	EVENTBOT_PREFIX                      = DEFAULT_COMMAND_PREFIX + EVENTBOT_CMD
+	EVENTBOT_CMD_HELP                    = "help"
 	EVENTBOT_CMD_ADD                     = "add"
-	EVENTBOT_CMD_ADD_SUCCESS             = "Recorded new event."
+	EVENTBOT_CMD_ADD_SUCCESS             = "Recorded new event %d on %s."
+	EVENTBOT_CMD_ADD_NO_DESCRIPTION      = "Missing description after the date."
 	EVENTBOT_CMD_LIST                    = "list"
 	EVENTBOT_CMD_LIST_NONE_AVAILABLE     = "no upcoming events."
+	EVENTBOT_CMD_LIST_NONE_IN_CATEGORY   = "no upcoming events in category %s."
 	EVENTBOT_CMD_TODAY                   = "today"
 	EVENTBOT_CMD_TODAY_NONE_AVAILABLE    = "no events today."
 	EVENTBOT_CMD_DELETE                  = "del"
 	EVENTBOT_CMD_EVENT_UNKNOWN           = "Unknown event."
 	EVENTBOT_CMD_DELETED_EVENT           = "Event %d deleted."
+	EVENTBOT_CMD_DELETED_OCCURRENCE      = "Occurrence of event %d on %s deleted."
+	EVENTBOT_CMD_OCCURRENCE_UNKNOWN      = "Event %d does not take place on %s."
+	EVENTBOT_INVALID_DATE_FORMAT         = "Invalid date format, use e.g. 01.01.2016"
+	EVENTBOT_DATE_FORMAT                 = "02.01.2006"
+	EVENTBOT_CMD_REPEAT                  = "repeat"
+	EVENTBOT_CMD_REPEAT_SUCCESS          = "Event %d repeats %s."
+	EVENTBOT_CMD_REPEAT_NONE             = "Event %d no longer repeats."
+	EVENTBOT_INVALID_RECURRENCE          = "Invalid recurrence: %s"
+	EVENTBOT_CMD_IMPORT                  = "import"
+	EVENTBOT_IMPORT_FAILED               = "Import failed: %s"
+	EVENTBOT_CMD_EDIT                    = "edit"
+	EVENTBOT_CMD_EDIT_SUCCESS            = "Event %d: %s changed from \"%s\" to \"%s\"."
+	EVENTBOT_CMD_EDIT_UNCHANGED          = "Event %d: %s is already \"%s\"."
+	EVENTBOT_CMD_EDIT_INVALID_FIELD      = "Unknown field %s, use date, desc, location, end, duration, url, category or tags."
+	EVENTBOT_CMD_EDIT_EMPTY_DESCRIPTION  = "The description must not be empty."
+	EVENTBOT_INVALID_END                 = "Invalid end, use e.g. end=22:00, end=02.01.2016-01:00 or duration=2h30m, the end must be after the start."
+	EVENTBOT_INVALID_URL                 = "Invalid URL, use e.g. url=https://example.com/meetup"
+	EVENTBOT_CMD_JOIN                    = "join"
+	EVENTBOT_CMD_JOIN_SUCCESS            = "%s attends event %d."
+	EVENTBOT_CMD_JOIN_ALREADY            = "%s already attends event %d."
+	EVENTBOT_CMD_LEAVE                   = "leave"
+	EVENTBOT_CMD_LEAVE_SUCCESS           = "%s no longer attends event %d."
+	EVENTBOT_CMD_LEAVE_NOT_ATTENDING     = "%s does not attend event %d."
+	EVENTBOT_CMD_WHO                     = "who"
+	EVENTBOT_CMD_WHO_ATTENDEES           = "Event %d: %d attending - %s"
+	EVENTBOT_CMD_WHO_NONE                = "Nobody attends event %d yet."
+	EVENTBOT_ATTENDEE_COUNT              = " [%d attending]"
 	EVENTBOT_CMD_MAILTEST                = "mailtest"
-	EVENTBOT_MAILTEST_REPLY              = "Attempted to send test mail."
-	EVENTBOT_MAILTEST_NOTAUTHORIZED      = "Only my owner can do this."
+	EVENTBOT_MAILTEST_REPLY              = "Sent the test mail."
+	EVENTBOT_MAILTEST_QUEUED             = "Failed to send the test mail, will retry: %s"
+	EVENTBOT_MAILTEST_FAILED             = "Failed to send the test mail: %s"
 	EVENTBOT_CMD_MAILREMINDER            = "mailreminder"
-	EVENTBOT_MAILREMINDER_REPLY          = "Attempted to send a reminder mail."
-	EVENTBOT_MAILREMINDER_NOTAUTHORIZED  = "Only my owner can do this."
-	EVENTBOT_MAILREMINDER_SEND_ERROR     = "Failed to send reminder email, check log."
-	EVENTBOT_MAILREMINDER_NONE_AVAILABLE = "No events for today found, not sending."
+	EVENTBOT_MAILREMINDER_REPLY          = "Sent the reminder mail."
+	EVENTBOT_MAILREMINDER_SEND_ERROR     = "Failed to send the reminder mail: %s"
+	EVENTBOT_MAILREMINDER_NONE_AVAILABLE = "No events found, not sending."
+	// Clears the location of an event
+	EVENTBOT_EMPTY_VALUE = "-"
+	// Format of event times in replies
+	EVENTBOT_DISPLAY_TIME_FORMAT = "Mon 2 Jan 2006, 15:04"
 )
 
+// Recurring events are listed up to this far in the future, single
+// events are always listed.
+const EVENTBOT_RECURRENCE_HORIZON = 28 * 24 * time.Hour
+
 /********************************** Event *************************************/
 type Event struct {
 	Id          int `sql:"AUTO_INCREMENT" gorm:"primary_key"`
 	Starttime   time.Time
 	Description string
+	// Recurrence rule in RFC 5545 syntax, empty for single events. The
+	// start time is the first occurrence.
+	Rrule string
+	// The UID of imported events, see UID.
+	Uid      string `sql:"index"`
+	Location string
+	// The end of the first occurrence, zero if unknown. Occurrences of
+	// recurring events have the same duration.
+	Endtime  time.Time
+	Url      string
+	Category string
+	// Tags separated by commas, see normalizeTags.
+	Tags string
+	// Maintained by gorm, zero for events created before these columns.
+	CreatedAt time.Time
+	UpdatedAt time.Time
 }
 
 func (e *Event) String() string {
-	return fmt.Sprintf("(%d) %s - %s", e.Id,
-		e.Starttime.Format("Mon 2 Jan 2006, 15:04"),
-		e.Description)
+	when := e.Starttime.Format(EVENTBOT_DISPLAY_TIME_FORMAT)
+	if !e.Endtime.IsZero() {
+		end := e.Endtime.In(e.Starttime.Location())
+		if end.YearDay() == e.Starttime.YearDay() && end.Year() == e.Starttime.Year() {
+			when += "-" + end.Format("15:04")
+		} else {
+			when += " - " + end.Format(EVENTBOT_DISPLAY_TIME_FORMAT)
+		}
+	}
+	retval := fmt.Sprintf("(%d) %s - %s", e.Id, when, e.Description)
+	if e.Location != "" {
+		retval += " @ " + e.Location
+	}
+	if rec, err := e.Recurrence(); err == nil && rec != nil {
+		retval += fmt.Sprintf(" (%s)", rec.Describe(e.Starttime.Location()))
+	}
+	if e.Category != "" {
+		retval += " [" + e.Category + "]"
+	}
+	if e.Url != "" {
+		retval += " " + e.Url
+	}
+	return retval
+}
+
+// Recurrence returns the parsed recurrence rule of the event, nil for
+// single events.
+func (e *Event) Recurrence() (*Recurrence, error) {
+	if e.Rrule == "" {
+		return nil, nil
+	}
+	return ParseRRule(e.Rrule)
+}
+
+// Occurrences returns a copy of the event for every occurrence in
+// [from, to) that is not listed in exceptions. The start times are
+// converted to loc.
+func (e Event) Occurrences(from, to time.Time, loc *time.Location,
+	exceptions []time.Time) EventList {
+	start := e.Starttime.In(loc)
+	var starts []time.Time
+	rec, err := e.Recurrence()
+	if err != nil {
+		log.Printf("Eventbot: Ignoring invalid recurrence of event %d - %s",
+			e.Id, err.Error())
+	}
+	if rec != nil {
+		starts = rec.Occurrences(start, from, to)
+	} else if !start.Before(from) && start.Before(to) {
+		starts = []time.Time{start}
+	}
+	var retval EventList
+	for _, t := range starts {
+		skip := false
+		for _, exception := range exceptions {
+			if exception.Equal(t) {
+				skip = true
+			}
+		}
+		if !skip {
+			occurrence := e
+			occurrence.Starttime = t
+			if !e.Endtime.IsZero() {
+				occurrence.Endtime = t.Add(e.Duration())
+			}
+			retval = append(retval, occurrence)
+		}
+	}
+	return retval
+}
+
+/* An EventAudit records a change of an event. */
+type EventAudit struct {
+	Id        int `sql:"AUTO_INCREMENT" gorm:"primary_key"`
+	EventId   int `sql:"index"`
+	Field     string
+	OldValue  string
+	NewValue  string
+	ChangedBy string
+	CreatedAt time.Time
+}
+
+/* An Attendee has announced to attend an event. Attendees are
+ * identified by their services account if both accounts are known,
+ * otherwise by nick. */
+type Attendee struct {
+	Id        int `sql:"AUTO_INCREMENT" gorm:"primary_key"`
+	EventId   int `sql:"index"`
+	Nick      string
+	Account   string
+	CreatedAt time.Time
+}
+
+// Matches reports whether the sender of msg is this attendee.
+func (a Attendee) Matches(msg InboundMessage) bool {
+	if a.Account != "" && msg.Account != "" {
+		return strings.EqualFold(a.Account, msg.Account)
+	}
+	return strings.EqualFold(a.Nick, msg.Nick)
+}
+
+/* An EventException removes a single occurrence of a recurring event. */
+type EventException struct {
+	Id        int `sql:"AUTO_INCREMENT" gorm:"primary_key"`
+	EventId   int
+	Starttime time.Time
 }
 
 type EventList []Event
@@ -86,61 +242,163 @@ func (el ByDate) Less(i, j int) bool {
 }
 
 /********************************** MailSender ***********************************/
+/* A MailTransport delivers rendered messages. */
+type MailTransport interface {
+	Deliver(from string, to []string, msg []byte) error
+}
+
+/* The MailSender delivers mails via SMTP. */
 type MailSender struct {
 	FromAddress string
 	SMTPAuth    smtp.Auth
 	SMTPServer  string
 	SMTPPort    int
+	// One of the MAIL_TLS_* modes. If empty, STARTTLS is used if the
+	// server offers it.
+	TLSMode string
 }
 
-func NewMailSender(from, username, password, server string, port int) *MailSender {
-	return &MailSender{
+func NewMailSender(from, username, password, server string, port int,
+	tlsMode string) *MailSender {
+	ms := &MailSender{
 		FromAddress: from,
-		SMTPAuth:    smtp.PlainAuth("", username, password, server),
 		SMTPServer:  server,
 		SMTPPort:    port,
+		TLSMode:     tlsMode,
+	}
+	if username != "" {
+		ms.SMTPAuth = smtp.PlainAuth("", username, password, server)
+	}
+	return ms
+}
+
+// Deliver sends the message to the SMTP server. The envelope sender is
+// the address part of from.
+func (ms *MailSender) Deliver(from string, to []string, msg []byte) error {
+	if address, err := mail.ParseAddress(from); err == nil {
+		from = address.Address
+	}
+	serveradress := net.JoinHostPort(ms.SMTPServer, strconv.Itoa(ms.SMTPPort))
+	tlsConfig := &tls.Config{ServerName: ms.SMTPServer}
+	dialer := &net.Dialer{Timeout: EVENTBOT_MAIL_TIMEOUT}
+	var conn net.Conn
+	var err error
+	if ms.TLSMode == her0ld.MAIL_TLS_IMPLICIT {
+		conn, err = tls.DialWithDialer(dialer, "tcp", serveradress, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", serveradress)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(EVENTBOT_MAIL_TIMEOUT))
+	c, err := smtp.NewClient(conn, ms.SMTPServer)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if ms.TLSMode == "" || ms.TLSMode == her0ld.MAIL_TLS_STARTTLS {
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			if err = c.StartTLS(tlsConfig); err != nil {
+				return err
+			}
+		} else if ms.TLSMode == her0ld.MAIL_TLS_STARTTLS {
+			return fmt.Errorf("%s does not support STARTTLS", ms.SMTPServer)
+		}
+	}
+	if ms.SMTPAuth != nil {
+		if err = c.Auth(ms.SMTPAuth); err != nil {
+			return err
+		}
+	}
+	if err = c.Mail(from); err != nil {
+		return err
+	}
+	for _, recipient := range to {
+		if err = c.Rcpt(recipient); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(msg); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	// the server accepted the message, retrying would send it twice
+	if err = c.Quit(); err != nil {
+		log.Printf("Eventbot: QUIT after sending a mail failed: %s", err.Error())
 	}
+	return nil
 }
 
-func (ms *MailSender) SendPlainTextMail(msg string, toadress string) {
-	serveradress := ms.SMTPServer + ":" + strconv.Itoa(ms.SMTPPort)
-	err := smtp.SendMail(serveradress,
-		ms.SMTPAuth,
-		ms.FromAddress,
-		[]string{toadress},
-		[]byte(msg))
+func (ms *MailSender) SendPlainTextMail(msg string, toadresses ...string) error {
+	err := ms.Deliver(ms.FromAddress, toadresses, []byte(msg))
 	if err != nil {
 		log.Println("ERROR: failed to send email, ", err.Error())
 	}
+	return err
+}
+
+// SendMail composes the message and sends it to its recipients.
+func (ms *MailSender) SendMail(m *Mail) error {
+	recipients, err := m.Recipients()
+	if err != nil {
+		log.Println("ERROR: failed to compose email, ", err.Error())
+		return err
+	}
+	msg, err := m.Bytes()
+	if err != nil {
+		log.Println("ERROR: failed to compose email, ", err.Error())
+		return err
+	}
+	return ms.SendPlainTextMail(string(msg), recipients...)
 }
 
 /********************************** EventBot *************************************/
 /* The EventBot maintains a list of events and attempts to
  * remind people. */
 type EventBot struct {
-	BotName               string
-	TimeLocation          *time.Location
-	NumMessagesHandled    int
-	Db                    *gorm.DB
-	MailSender            *MailSender
-	OwnerNick             string
+	BotName            string
+	TimeLocation       *time.Location
+	NumMessagesHandled int
+	Db                 *gorm.DB
+	MailSender         *MailSender
+	// delivers the mails of the outbox, the MailSender by default
+	MailTransport         MailTransport
+	MailMaxAttempts       int
 	OwnerEmailAddress     string
-	RecipientAddress      string
 	EventListMailTemplate string
-	Cron                  *cron.Cron
-	HttpListenAddress     string
+	// files replacing EventListMailTemplate and the HTML version
+	EventListMailTemplateFile string
+	EventListMailHTMLFile     string
+	MailJobs                  []her0ld.MailJob
+	BackupSettings            her0ld.BackupSettings
+	Cron                      *cron.Cron
+	HttpListenAddress         string
+	APITokens                 []her0ld.APIToken
+	TemplateDir               string
+	Commands                  *CommandRouter
+	eventCommand              *Command
+	httpServer                *http.Server
+	templates                 *htmltemplate.Template
+	// reminders are announced these lead times before an event starts
+	LeadTimes  []time.Duration
+	announcers []Announcer
+	// the bot may be shared between several IRC connections.
+	mutex sync.Mutex
+	// serializes the delivery of the outbox
+	outboxMutex sync.Mutex
 }
 
-func NewEventBot(name string, cfg her0ld.EventbotConfig, generalcfg her0ld.GeneralConfig) *EventBot {
-	if cfg.DBFile == "" {
-		log.Fatalf("Eventbot: No database file given - aborting")
-	}
-	db, err := gorm.Open("sqlite3", cfg.DBFile)
-	// no sense in continuing w/o database
-	if err != nil {
-		log.Fatalf("Eventbot: Cannot open database - %s", err.Error())
-	}
-	db.AutoMigrate(&Event{})
+/* NewEventBot creates an event bot that stores its events in db, see
+ * OpenDatabase. */
+func NewEventBot(name string, db *gorm.DB, cfg her0ld.EventbotConfig, generalcfg her0ld.GeneralConfig) *EventBot {
 	if cfg.Timezone == "" {
 		log.Fatalf("Eventbot: No timezone configured - aborting.")
 	}
@@ -148,286 +406,715 @@ func NewEventBot(name string, cfg her0ld.EventbotConfig, generalcfg her0ld.Gener
 	if err != nil {
 		log.Fatalf("Eventbot: Cannot load location based on timezone - %s", err.Error())
 	}
+	for _, token := range cfg.HttpSettings.APITokens {
+		if len(token.Token) < API_MIN_TOKEN_LENGTH {
+			log.Fatalf("Eventbot: API token %s is shorter than %d characters - aborting.",
+				token.Name, API_MIN_TOKEN_LENGTH)
+		}
+	}
+	if err := cfg.EmailSettings.Validate(); err != nil {
+		log.Fatalf("Eventbot: Invalid mail settings - %s", err.Error())
+	}
+	leadtimes, err := ParseLeadTimes(cfg.Reminders.LeadTimes)
+	if err != nil {
+		log.Fatalf("Eventbot: Invalid reminder lead time - %s", err.Error())
+	}
 	ms := NewMailSender(
 		cfg.EmailSettings.FromAddress,
 		cfg.EmailSettings.SMTPUsername,
 		cfg.EmailSettings.SMTPPassword,
 		cfg.EmailSettings.SMTPServer,
 		cfg.EmailSettings.SMTPPort,
+		cfg.EmailSettings.TLSMode,
 	)
+	maxAttempts := cfg.EmailSettings.MaxAttempts
+	if maxAttempts == 0 {
+		maxAttempts = EVENTBOT_OUTBOX_MAX_ATTEMPTS
+	}
 	retval := &EventBot{
-		BotName:               name,
-		TimeLocation:          loc,
-		NumMessagesHandled:    0,
-		Db:                    db,
-		MailSender:            ms,
-		OwnerNick:             generalcfg.OwnerNick,
-		OwnerEmailAddress:     generalcfg.OwnerEmailAddress,
-		RecipientAddress:      cfg.EmailSettings.RecipientAddress,
-		EventListMailTemplate: cfg.EmailSettings.EventListMailTemplate,
-		Cron:              cron.New(),
-		HttpListenAddress: cfg.HttpSettings.ListenAddress,
-	}
-	retval.Cron.AddFunc("0 0 1 * * *", func() {
-		log.Println("Cron: Triggering event list email.")
-		switch retval.SendEventList() {
-		case NO_EVENT_TODAY:
-			log.Printf("Cron: %s", EVENTBOT_MAILREMINDER_NONE_AVAILABLE)
-		case SEND_ERROR:
-			log.Printf("Cron: %s", EVENTBOT_MAILREMINDER_SEND_ERROR)
-		case SEND_SUCCESS:
-			log.Printf("Cron: %s", EVENTBOT_MAILREMINDER_REPLY)
-		default:
-			log.Println("Cron: Unknown return code from SendEventList - WTF?")
+		BotName:                   name,
+		TimeLocation:              loc,
+		NumMessagesHandled:        0,
+		Db:                        db,
+		MailSender:                ms,
+		MailTransport:             ms,
+		MailMaxAttempts:           maxAttempts,
+		OwnerEmailAddress:         generalcfg.OwnerEmailAddress,
+		EventListMailTemplate:     cfg.EmailSettings.EventListMailTemplate,
+		EventListMailTemplateFile: cfg.EmailSettings.EventListMailTemplateFile,
+		EventListMailHTMLFile:     cfg.EmailSettings.EventListMailHTMLFile,
+		MailJobs:                  cfg.EffectiveMailJobs(),
+		BackupSettings:            cfg.Backup,
+		Cron:                      cron.New(),
+		HttpListenAddress:         cfg.HttpSettings.ListenAddress,
+		APITokens:                 cfg.HttpSettings.APITokens,
+		TemplateDir:               cfg.HttpSettings.TemplateDir,
+		Commands:                  NewCommandRouter(),
+		LeadTimes:                 leadtimes,
+	}
+	retval.registerCommands()
+	if len(leadtimes) > 0 {
+		// reminders are computed from the database every minute, so
+		// they follow edited and deleted events.
+		retval.Cron.AddFunc("0 * * * * *", retval.SendReminders)
+	}
+	retval.Cron.AddFunc("30 * * * * *", retval.DeliverOutbox)
+	for _, job := range retval.MailJobs {
+		if err := checkMailJob(job); err != nil {
+			log.Fatalf("Eventbot: Invalid mail job %s - %s", job.Name, err.Error())
 		}
-	})
-	retval.Cron.Start()
-	go retval.ServeHTTP()
+		if _, err := retval.mailTemplates(job); err != nil {
+			log.Fatalf("Eventbot: Invalid template of mail job %s - %s", job.Name,
+				err.Error())
+		}
+		job := job
+		if err := retval.Cron.AddFunc(job.Schedule, func() {
+			log.Printf("Cron: Triggering %s event list email.", job.Name)
+			log.Printf("Cron: %s", mailReminderReply(retval.SendEventList(job)))
+		}); err != nil {
+			log.Fatalf("Eventbot: Invalid schedule of mail job %s - %s", job.Name,
+				err.Error())
+		}
+	}
+	if cfg.Backup.Schedule != "" {
+		if err := checkBackupSettings(cfg.Backup, db.NewScope(nil).Dialect().GetName()); err != nil {
+			log.Fatalf("Eventbot: Invalid backup settings - %s", err.Error())
+		}
+		if err := retval.Cron.AddFunc(cfg.Backup.Schedule, func() {
+			if name, err := retval.BackupDatabase(); err != nil {
+				log.Printf("Cron: Backup failed - %s", err.Error())
+			} else {
+				log.Printf("Cron: Saved backup %s", name)
+			}
+		}); err != nil {
+			log.Fatalf("Eventbot: Invalid backup schedule - %s", err.Error())
+		}
+	}
 	return retval
 }
 
-func (b *EventBot) ServeHTTP() {
-	const header = `{{define "HEADER"}}
-<!DOCTYPE html>
-<html>
-	<head>
-		<meta charset="UTF-8">
-		<title>her0ld</title>
-	</head>
-	<body>
-	{{end}}
-	`
-	const footer = `{{define "FOOTER"}}
-	</body>
-</html>
-	{{end}}
-`
-	const index = `
-	{{template "HEADER"}}
-	<p>indexfoo</p>
-	{{template "FOOTER"}}
-	`
-	check := func(err error) {
-		if err != nil {
-			log.Fatal(err)
-		}
+/* Start runs the background jobs of the bot: the cron scheduler and,
+ * if a listen address is configured, the HTTP server. */
+func (b *EventBot) Start() {
+	b.Cron.Start()
+	if b.HttpListenAddress != "" {
+		b.httpServer = &http.Server{Addr: b.HttpListenAddress}
+		go b.ServeHTTP()
 	}
-	t := template.New("Webpage")
-	t, err := t.Parse(header)
-	check(err)
-	t, err = t.Parse(footer)
-	check(err)
-	t, err = t.Parse(index)
-	check(err)
-
-	// TODO: Generate template fu like this: http://stackoverflow.com/a/11468132
-	// or this: https://github.com/valyala/quicktemplate
-	hello := func(w http.ResponseWriter, r *http.Request) {
-		err = t.Execute(w, nil)
-		if err != nil {
-			io.WriteString(w, fmt.Sprintf("Failed to render page: %s",
-				err.Error()))
-			//io.WriteString(w, "Hello World!")
-		}
+}
+
+/* Stop terminates the background jobs. */
+func (b *EventBot) Stop() {
+	b.Cron.Stop()
+	if b.httpServer != nil {
+		b.httpServer.Close()
 	}
-	http.HandleFunc("/", hello)
-	http.ListenAndServe(b.HttpListenAddress, nil)
 }
 
-type SendEventListStatus int
+func (b *EventBot) ServeHTTP() {
+	b.httpServer.Handler = b.Handler()
+	err := b.httpServer.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
+		log.Printf("Eventbot: HTTP server failed - %s", err.Error())
+	}
+}
 
-const (
-	NO_EVENT_TODAY = iota
-	SEND_SUCCESS   = iota
-	SEND_ERROR     = iota
-)
+// Handler returns the HTTP handler with all pages of the bot.
+func (b *EventBot) Handler() http.Handler {
+	templates, err := loadTemplates(b.TemplateDir)
+	if err != nil {
+		log.Fatalf("Eventbot: Invalid web template - %s", err.Error())
+	}
+	b.templates = templates
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", b.serveIndex)
+	mux.HandleFunc("/calendar", b.serveCalendar)
+	mux.HandleFunc("/events/", b.serveEvents)
+	mux.HandleFunc("/events.ics", b.serveICS)
+	mux.HandleFunc("/events.atom", b.serveAtom)
+	mux.HandleFunc("/events.rss", b.serveRSS)
+	mux.HandleFunc(API_PREFIX, b.serveAPI)
+	mux.HandleFunc(API_PREFIX+"/", b.serveAPI)
+	// runtime statistics, e.g. the connection supervisor counters
+	mux.HandleFunc("/debug/vars", b.serveDebugVars)
+	return mux
+}
 
-// TODO: Refactor - should send return value via channel and live in
-// a goroutine...
-func (eb *EventBot) SendEventList() (status SendEventListStatus) {
-	var todayEvents EventList
-	todaytime := time.Now().Truncate(24 * time.Hour)
-	tomorrow := time.Now().Add(24 * time.Hour).Truncate(24 * time.Hour)
-	eb.Db.Where("starttime > ? and starttime < ?", todaytime, tomorrow).Find(&todayEvents)
-	if len(todayEvents) == 0 {
-		status = NO_EVENT_TODAY
-	} else {
-		var upcomingEvents EventList
-		eb.Db.Where("starttime > ?", tomorrow).Find(&upcomingEvents)
-		sort.Sort(ByDate(upcomingEvents))
-		type TemplateData struct {
-			From            string
-			To              string
-			Now             string
-			Subject         string
-			HighlightEvents string
-			UpcomingEvents  string
-		}
-		emailTemplate := `From: {{.From}}
-To: {{.To}}
-Subject: {{.Subject}}
-Date: {{.Now}}
-Content-Type: text/plain; charset=UTF-8
-
-` + eb.EventListMailTemplate
-		var doc bytes.Buffer
-		var err error
-		// TODO: Load Template from configuration file
-		context := &TemplateData{
-			From:            eb.MailSender.FromAddress,
-			To:              eb.RecipientAddress,
-			Now:             time.Now().Format(time.RFC822),
-			Subject:         "Reminder: Heute beim Chaos inKL.",
-			HighlightEvents: todayEvents.String(),
-			UpcomingEvents:  upcomingEvents.String(),
+// describeWithAttendees lists the events, each followed by a line with
+// its attendees.
+func (b *EventBot) describeWithAttendees(events EventList) string {
+	attendees := b.attendees(events)
+	var lines []string
+	for _, event := range events {
+		lines = append(lines, event.String())
+		if names := attendeeNames(attendees[event.Id]); len(names) > 0 {
+			lines = append(lines, fmt.Sprintf("    Attendees (%d): %s",
+				len(names), strings.Join(names, ", ")))
 		}
-		t := template.New("emailTemplate")
-		if t, err = t.Parse(emailTemplate); err != nil {
-			log.Print("error trying to parse mail template ", err)
-			status = SEND_ERROR
+	}
+	return strings.Join(lines, "\n")
+}
+
+// dayBounds returns the start of the day of t and the start of the
+// following day in the time zone of the bot.
+func (b *EventBot) dayBounds(t time.Time) (time.Time, time.Time) {
+	t = t.In(b.TimeLocation)
+	start := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, b.TimeLocation)
+	return start, start.AddDate(0, 0, 1)
+}
+
+// exceptions returns the deleted occurrences of the given events by
+// event id.
+func (b *EventBot) exceptions(events []Event) map[int][]time.Time {
+	exceptions := make(map[int][]time.Time)
+	var ids []int
+	for _, event := range events {
+		if event.Rrule != "" {
+			ids = append(ids, event.Id)
 		}
-		if err = t.Execute(&doc, context); err != nil {
-			log.Print("error trying to execute mail template ", err)
-			status = SEND_ERROR
-		} else {
-			eb.MailSender.SendPlainTextMail(doc.String(), eb.RecipientAddress)
-			status = SEND_SUCCESS
+	}
+	if len(ids) > 0 {
+		var stored []EventException
+		b.Db.Where("event_id in (?)", ids).Order("starttime").Find(&stored)
+		for _, exception := range stored {
+			exceptions[exception.EventId] = append(exceptions[exception.EventId],
+				exception.Starttime)
 		}
 	}
-	return status
+	return exceptions
 }
 
-func (b *EventBot) isFromOwner(msg InboundMessage) bool {
-	return msg.Nick == b.OwnerNick
+// expand returns the occurrences of the given events in [from, to),
+// sorted by date.
+func (b *EventBot) expand(events []Event, from, to time.Time) EventList {
+	exceptions := b.exceptions(events)
+	var retval EventList
+	for _, event := range events {
+		retval = append(retval, event.Occurrences(from, to, b.TimeLocation,
+			exceptions[event.Id])...)
+	}
+	sort.Sort(ByDate(retval))
+	return retval
+}
+
+// eventsBetween returns the occurrences of all events in [from, to),
+// sorted by date.
+func (b *EventBot) eventsBetween(from, to time.Time) EventList {
+	var events []Event
+	b.Db.Where("starttime < ? and (starttime >= ? or rrule <> '')", to,
+		from).Find(&events)
+	return b.expand(events, from, to)
+}
+
+// upcomingEvents returns all single events starting at or after from and
+// the occurrences of recurring events within EVENTBOT_RECURRENCE_HORIZON,
+// sorted by date.
+func (b *EventBot) upcomingEvents(from time.Time) EventList {
+	var single, recurring []Event
+	b.Db.Where("starttime >= ? and (rrule is null or rrule = '')",
+		from).Find(&single)
+	b.Db.Where("rrule <> ''").Find(&recurring)
+	retval := b.expand(recurring, from, from.Add(EVENTBOT_RECURRENCE_HORIZON))
+	for _, event := range single {
+		event.Starttime = event.Starttime.In(b.TimeLocation)
+		retval = append(retval, event)
+	}
+	sort.Sort(ByDate(retval))
+	return retval
 }
 
 func (b *EventBot) ProcessChannelEvent(msg InboundMessage) ([]OutboundMessage, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
 	b.NumMessagesHandled += 1
-	// look for commands
-	//log.Printf("Processing message %s", msg.Message)
-	if strings.HasPrefix(msg.Message, fmt.Sprintf("%s %s",
-		EVENTBOT_PREFIX, EVENTBOT_CMD_HELP)) {
-		// help command
-		answer := strings.Split(EVENTBOT_HELP_TEXT, "\n")
-		return strings2reply(msg.Channel, answer), nil
-	} else if strings.HasPrefix(msg.Message, fmt.Sprintf("%s %s",
-		EVENTBOT_PREFIX, EVENTBOT_CMD_ADD)) {
-		// add new event command
-		request := strings.Split(msg.Message, " ")
-		// request[0|1] contain the add command - we need date and
-		// description.
-		if date, err := time.ParseInLocation(EVENTBOT_TIME_FORMAT,
-			request[2], b.TimeLocation); err != nil {
-			answer := []string{
-				EVENTBOT_INVALID_TIME_FORMAT,
-				fmt.Sprintf("Error was: %s", err.Error()),
-			}
-			return strings2reply(msg.Channel, answer), nil
-		} else {
-			description := strings.Join(request[3:], " ")
-			newEvent := Event{
-				Starttime:   date,
-				Description: description,
-			}
-			b.Db.Create(&newEvent)
-			answer := []string{EVENTBOT_CMD_ADD_SUCCESS}
-			return strings2reply(msg.Channel, answer), nil
+	return b.Commands.Dispatch(msg)
+}
+
+// registerCommands sets up the !event command and its subcommands.
+func (b *EventBot) registerCommands() {
+	b.eventCommand = &Command{
+		Name:    EVENTBOT_CMD,
+		Handler: b.cmdInvalid,
+		Subcommands: []*Command{
+			{
+				Name:    EVENTBOT_CMD_HELP,
+				Help:    "this text",
+				Handler: b.cmdHelp,
+			},
+			{
+				Name:    EVENTBOT_CMD_ADD,
+				Args:    []Arg{{Name: "date"}, {Name: "description", Rest: true}},
+				Help:    "add an event, e.g. \"next friday 20:00 Meetup end=22:00 location=Lab url=https://example.com category=meetup tags=python,beginners\"",
+				Role:    RoleEventEditor,
+				Handler: b.cmdAdd,
+			},
+			{
+				Name:    EVENTBOT_CMD_LIST,
+				Args:    []Arg{{Name: "category", Optional: true}},
+				Help:    "list all upcoming events (with id), or those of a category or tag",
+				Handler: b.cmdList,
+			},
+			{
+				Name:    EVENTBOT_CMD_DELETE,
+				Args:    []Arg{{Name: "id"}, {Name: "date", Optional: true}},
+				Help:    "remove the event with the given id, or only its occurrence on the given date",
+				Role:    RoleEventEditor,
+				Handler: b.cmdDelete,
+			},
+			{
+				Name:    EVENTBOT_CMD_EDIT,
+				Args:    []Arg{{Name: "id"}, {Name: "field"}, {Name: "value", Rest: true}},
+				Help:    "change the date, desc, location, end, duration, url, category or tags of an event (- removes a value)",
+				Role:    RoleEventEditor,
+				Handler: b.cmdEdit,
+			},
+			{
+				Name:    EVENTBOT_CMD_REPEAT,
+				Args:    []Arg{{Name: "id"}, {Name: "rule", Rest: true}},
+				Help:    "repeat an event, e.g. \"weekly on tue, thu\", \"monthly on the first tue until 31.12.2016\", \"every 2 weeks 10 times\" or \"none\"",
+				Role:    RoleEventEditor,
+				Handler: b.cmdRepeat,
+			},
+			{
+				Name:    EVENTBOT_CMD_JOIN,
+				Args:    []Arg{{Name: "id"}},
+				Help:    "announce that you attend an event",
+				Handler: b.cmdJoin,
+			},
+			{
+				Name:    EVENTBOT_CMD_LEAVE,
+				Args:    []Arg{{Name: "id"}},
+				Help:    "withdraw from an event",
+				Handler: b.cmdLeave,
+			},
+			{
+				Name:    EVENTBOT_CMD_WHO,
+				Args:    []Arg{{Name: "id"}},
+				Help:    "list the attendees of an event",
+				Handler: b.cmdWho,
+			},
+			{
+				Name:    EVENTBOT_CMD_TODAY,
+				Help:    "show all events today",
+				Handler: b.cmdToday,
+			},
+			{
+				Name:    EVENTBOT_CMD_IMPORT,
+				Args:    []Arg{{Name: "url"}},
+				Help:    "import or update the events of an iCalendar file",
+				Role:    RoleOwner,
+				Handler: b.cmdImport,
+			},
+			{
+				Name:    EVENTBOT_CMD_MAILTEST,
+				Help:    "send a test mail to my owner",
+				Role:    RoleOwner,
+				Handler: b.cmdMailtest,
+			},
+			{
+				Name:    EVENTBOT_CMD_MAILREMINDER,
+				Args:    []Arg{{Name: "job", Optional: true}},
+				Help:    "send a reminder mail now, by default the first one configured",
+				Role:    RoleOwner,
+				Handler: b.cmdMailreminder,
+			},
+		},
+	}
+	b.Commands.Register(b.eventCommand)
+}
+
+// invalid command (!event foo)
+func (b *EventBot) cmdInvalid(msg InboundMessage, args []string) ([]string, error) {
+	return []string{EVENTBOT_INVALID_COMMAND}, nil
+}
+
+func (b *EventBot) cmdHelp(msg InboundMessage, args []string) ([]string, error) {
+	return b.Commands.HelpLines(b.eventCommand, msg.BotNick), nil
+}
+
+func (b *EventBot) cmdAdd(msg InboundMessage, args []string) ([]string, error) {
+	now := time.Now().In(b.TimeLocation)
+	date, used, err := ParseTime(args, now)
+	if err != nil {
+		return []string{
+			EVENTBOT_INVALID_TIME_FORMAT,
+			fmt.Sprintf("Error was: %s", err.Error()),
+		}, nil
+	}
+	description, values := splitFieldValues(args[used:])
+	if len(description) == 0 {
+		return []string{EVENTBOT_CMD_ADD_NO_DESCRIPTION}, nil
+	}
+	newEvent := Event{
+		Starttime:   date,
+		Description: strings.Join(description, " "),
+	}
+	for _, fv := range values {
+		if _, _, _, err := b.setField(&newEvent, fv.Key, fv.Words, now); err != nil {
+			return []string{err.Error()}, nil
 		}
-	} else if strings.HasPrefix(msg.Message, fmt.Sprintf("%s %s",
-		EVENTBOT_PREFIX, EVENTBOT_CMD_LIST)) {
-		var answer []string
-		var events []Event
-		b.Db.Where("starttime > ?", time.Now()).Find(&events)
-		if len(events) == 0 {
-			answer = append(answer, EVENTBOT_CMD_LIST_NONE_AVAILABLE)
-		} else {
-			sort.Sort(ByDate(events))
-			for _, event := range events {
-				answer = append(answer, event.String())
+	}
+	b.Db.Create(&newEvent)
+	log.Printf("Eventbot: %s added event %d on %s", msg.Source, newEvent.Id,
+		date.Format(EVENTBOT_TIME_FORMAT))
+	return []string{fmt.Sprintf(EVENTBOT_CMD_ADD_SUCCESS, newEvent.Id,
+		date.Format(EVENTBOT_DISPLAY_TIME_FORMAT))}, nil
+}
+
+func (b *EventBot) cmdList(msg InboundMessage, args []string) ([]string, error) {
+	var answer []string
+	events := b.upcomingEvents(time.Now())
+	if len(args) > 0 {
+		var filtered EventList
+		for _, event := range events {
+			if event.InCategory(args[0]) {
+				filtered = append(filtered, event)
 			}
 		}
-		return strings2reply(msg.Channel, answer), nil
-	} else if strings.HasPrefix(msg.Message, fmt.Sprintf("%s %s",
-		EVENTBOT_PREFIX, EVENTBOT_CMD_TODAY)) {
-		var answer []string
-		var events []Event
-		starttime := time.Now().Truncate(24 * time.Hour)
-		endtime := time.Now().Add(24 * time.Hour).Truncate(24 * time.Hour)
-		b.Db.Where("starttime > ? and starttime < ?", starttime, endtime).Find(&events)
-		if len(events) == 0 {
-			answer = append(answer, EVENTBOT_CMD_TODAY_NONE_AVAILABLE)
-		} else {
-			for _, event := range events {
-				answer = append(answer, event.String())
-			}
+		if len(filtered) == 0 {
+			return []string{fmt.Sprintf(EVENTBOT_CMD_LIST_NONE_IN_CATEGORY,
+				args[0])}, nil
 		}
-		return strings2reply(msg.Channel, answer), nil
-	} else if strings.HasPrefix(msg.Message, fmt.Sprintf("%s %s",
-		EVENTBOT_PREFIX, EVENTBOT_CMD_DELETE)) {
-		request := strings.Split(msg.Message, " ")
-		var answer []string
-		var events []Event
-		b.Db.Where("ID == ?", request[2]).Find(&events)
-		if len(events) == 0 {
-			answer = append(answer, EVENTBOT_CMD_EVENT_UNKNOWN)
-		} else {
-			for _, event := range events {
-				b.Db.Delete(&event)
-				answer = append(answer, fmt.Sprintf(EVENTBOT_CMD_DELETED_EVENT,
-					event.Id))
+		events = filtered
+	}
+	if len(events) == 0 {
+		answer = append(answer, EVENTBOT_CMD_LIST_NONE_AVAILABLE)
+	} else {
+		answer = b.withAttendeeCounts(events)
+	}
+	return answer, nil
+}
+
+func (b *EventBot) cmdToday(msg InboundMessage, args []string) ([]string, error) {
+	var answer []string
+	events := b.eventsBetween(b.dayBounds(time.Now()))
+	if len(events) == 0 {
+		answer = append(answer, EVENTBOT_CMD_TODAY_NONE_AVAILABLE)
+	} else {
+		answer = b.withAttendeeCounts(events)
+	}
+	return answer, nil
+}
+
+// findEvent returns the event with the given id.
+func (b *EventBot) findEvent(id string) (Event, bool) {
+	var events []Event
+	if id, err := strconv.Atoi(id); err == nil {
+		b.Db.Where("id = ?", id).Find(&events)
+	}
+	if len(events) == 0 {
+		return Event{}, false
+	}
+	return events[0], true
+}
+
+// deleteEvent removes an event including the exceptions of its series.
+func (b *EventBot) deleteEvent(event Event) {
+	b.Db.Where("event_id = ?", event.Id).Delete(EventException{})
+	b.Db.Where("event_id = ?", event.Id).Delete(ReminderLog{})
+	b.Db.Where("event_id = ?", event.Id).Delete(Attendee{})
+	b.Db.Delete(&event)
+}
+
+func (b *EventBot) cmdDelete(msg InboundMessage, args []string) ([]string, error) {
+	event, ok := b.findEvent(args[0])
+	if !ok {
+		return []string{EVENTBOT_CMD_EVENT_UNKNOWN}, nil
+	}
+	if len(args) == 1 {
+		b.deleteEvent(event)
+		return []string{fmt.Sprintf(EVENTBOT_CMD_DELETED_EVENT, event.Id)}, nil
+	}
+	// delete only the occurrences on the given day
+	day, err := time.ParseInLocation(EVENTBOT_DATE_FORMAT, args[1], b.TimeLocation)
+	if err != nil {
+		day, err = time.ParseInLocation(EVENTBOT_TIME_FORMAT, args[1], b.TimeLocation)
+	}
+	if err != nil {
+		return []string{EVENTBOT_INVALID_DATE_FORMAT}, nil
+	}
+	from, to := b.dayBounds(day)
+	occurrences := b.expand([]Event{event}, from, to)
+	if len(occurrences) == 0 {
+		return []string{fmt.Sprintf(EVENTBOT_CMD_OCCURRENCE_UNKNOWN, event.Id,
+			from.Format(EVENTBOT_DATE_FORMAT))}, nil
+	}
+	if event.Rrule == "" {
+		// the only occurrence of a single event
+		b.deleteEvent(event)
+		return []string{fmt.Sprintf(EVENTBOT_CMD_DELETED_EVENT, event.Id)}, nil
+	}
+	var answer []string
+	for _, occurrence := range occurrences {
+		b.Db.Create(&EventException{
+			EventId:   event.Id,
+			Starttime: occurrence.Starttime,
+		})
+		answer = append(answer, fmt.Sprintf(EVENTBOT_CMD_DELETED_OCCURRENCE,
+			event.Id, occurrence.Starttime.Format(EVENTBOT_TIME_FORMAT)))
+	}
+	return answer, nil
+}
+
+func (b *EventBot) cmdEdit(msg InboundMessage, args []string) ([]string, error) {
+	event, ok := b.findEvent(args[0])
+	if !ok {
+		return []string{EVENTBOT_CMD_EVENT_UNKNOWN}, nil
+	}
+	start := event.Starttime
+	field, oldValue, newValue, err := b.setField(&event, args[1], args[2:],
+		time.Now().In(b.TimeLocation))
+	if err != nil {
+		return []string{err.Error()}, nil
+	}
+	if oldValue == newValue {
+		return []string{fmt.Sprintf(EVENTBOT_CMD_EDIT_UNCHANGED, event.Id, field,
+			newValue)}, nil
+	}
+	b.updateEvent(event, start, []EventAudit{{
+		Field:    field,
+		OldValue: oldValue,
+		NewValue: newValue,
+	}}, msg.Source)
+	return []string{fmt.Sprintf(EVENTBOT_CMD_EDIT_SUCCESS, event.Id, field,
+		oldValue, newValue)}, nil
+}
+
+// updateEvent saves a changed event and records the changes. The
+// deleted occurrences of a series move along with its start.
+func (b *EventBot) updateEvent(event Event, oldStart time.Time,
+	changes []EventAudit, changedBy string) {
+	if event.Rrule == "" {
+		// single events have no deleted occurrences
+		b.Db.Where("event_id = ?", event.Id).Delete(EventException{})
+	} else if delta := event.Starttime.Sub(oldStart); delta != 0 {
+		var exceptions []EventException
+		b.Db.Where("event_id = ?", event.Id).Find(&exceptions)
+		for _, exception := range exceptions {
+			b.Db.Model(&exception).Update("starttime",
+				exception.Starttime.Add(delta))
+		}
+	}
+	b.Db.Save(&event)
+	for _, change := range changes {
+		change.EventId = event.Id
+		change.ChangedBy = changedBy
+		b.Db.Create(&change)
+		log.Printf("Eventbot: %s changed %s of event %d from %q to %q",
+			changedBy, change.Field, event.Id, change.OldValue, change.NewValue)
+	}
+}
+
+func (b *EventBot) cmdRepeat(msg InboundMessage, args []string) ([]string, error) {
+	event, ok := b.findEvent(args[0])
+	if !ok {
+		return []string{EVENTBOT_CMD_EVENT_UNKNOWN}, nil
+	}
+	// the deleted occurrences are removed along with the rule
+	rrule, reply := "", fmt.Sprintf(EVENTBOT_CMD_REPEAT_NONE, event.Id)
+	if len(args) != 2 || !strings.EqualFold(args[1], "none") {
+		rec, err := ParseRecurrence(args[1:], b.TimeLocation)
+		if err != nil {
+			return []string{fmt.Sprintf(EVENTBOT_INVALID_RECURRENCE, err.Error())}, nil
+		}
+		rrule = rec.String()
+		reply = fmt.Sprintf(EVENTBOT_CMD_REPEAT_SUCCESS, event.Id,
+			rec.Describe(b.TimeLocation))
+	}
+	if rrule != event.Rrule {
+		change := EventAudit{Field: "rrule", OldValue: event.Rrule, NewValue: rrule}
+		event.Rrule = rrule
+		b.updateEvent(event, event.Starttime, []EventAudit{change}, msg.Source)
+	}
+	return []string{reply}, nil
+}
+
+// attendees returns the attendees of the given events by event id, in
+// the order in which they joined.
+func (b *EventBot) attendees(events []Event) map[int][]Attendee {
+	retval := make(map[int][]Attendee)
+	var ids []int
+	for _, event := range events {
+		ids = append(ids, event.Id)
+	}
+	if len(ids) == 0 {
+		return retval
+	}
+	var attendees []Attendee
+	b.Db.Where("event_id in (?)", ids).Order("id").Find(&attendees)
+	for _, a := range attendees {
+		retval[a.EventId] = append(retval[a.EventId], a)
+	}
+	return retval
+}
+
+// attendeeNames returns the nicks of the given attendees.
+func attendeeNames(attendees []Attendee) []string {
+	var retval []string
+	for _, a := range attendees {
+		retval = append(retval, a.Nick)
+	}
+	return retval
+}
+
+// withAttendeeCounts formats the events for the channel, together with
+// the number of attendees.
+func (b *EventBot) withAttendeeCounts(events EventList) []string {
+	attendees := b.attendees(events)
+	var retval []string
+	for _, event := range events {
+		line := event.String()
+		if n := len(attendees[event.Id]); n > 0 {
+			line += fmt.Sprintf(EVENTBOT_ATTENDEE_COUNT, n)
+		}
+		retval = append(retval, line)
+	}
+	return retval
+}
+
+func (b *EventBot) cmdJoin(msg InboundMessage, args []string) ([]string, error) {
+	event, ok := b.findEvent(args[0])
+	if !ok {
+		return []string{EVENTBOT_CMD_EVENT_UNKNOWN}, nil
+	}
+	for _, a := range b.attendees([]Event{event})[event.Id] {
+		if a.Matches(msg) {
+			if a.Nick != msg.Nick {
+				// show the current nick of the attendee
+				b.Db.Model(&a).Update("nick", msg.Nick)
 			}
+			return []string{fmt.Sprintf(EVENTBOT_CMD_JOIN_ALREADY, msg.Nick,
+				event.Id)}, nil
 		}
-		return strings2reply(msg.Channel, answer), nil
-	} else if strings.HasPrefix(msg.Message, fmt.Sprintf("%s %s",
-		EVENTBOT_PREFIX, EVENTBOT_CMD_MAILTEST)) {
-		answer := []string{EVENTBOT_MAILTEST_NOTAUTHORIZED}
-		if b.isFromOwner(msg) {
-			mailtext := "To: " + b.OwnerEmailAddress + "\r\n" +
-				"From: " + b.MailSender.FromAddress + "\r\n" +
-				"Date: " + (time.Now().Format(time.RFC822)) + "\r\n" +
-				"Subject: her0ld mail test\r\n" +
-				"\r\n" +
-				"Testing mail. If you can read this everything should be working.\r\n"
-			go b.MailSender.SendPlainTextMail(mailtext, b.OwnerEmailAddress)
-			answer = []string{EVENTBOT_MAILTEST_REPLY}
+	}
+	b.Db.Create(&Attendee{
+		EventId: event.Id,
+		Nick:    msg.Nick,
+		Account: msg.Account,
+	})
+	return []string{fmt.Sprintf(EVENTBOT_CMD_JOIN_SUCCESS, msg.Nick,
+		event.Id)}, nil
+}
+
+func (b *EventBot) cmdLeave(msg InboundMessage, args []string) ([]string, error) {
+	event, ok := b.findEvent(args[0])
+	if !ok {
+		return []string{EVENTBOT_CMD_EVENT_UNKNOWN}, nil
+	}
+	for _, a := range b.attendees([]Event{event})[event.Id] {
+		if a.Matches(msg) {
+			b.Db.Delete(&a)
+			return []string{fmt.Sprintf(EVENTBOT_CMD_LEAVE_SUCCESS, msg.Nick,
+				event.Id)}, nil
+		}
+	}
+	return []string{fmt.Sprintf(EVENTBOT_CMD_LEAVE_NOT_ATTENDING, msg.Nick,
+		event.Id)}, nil
+}
+
+func (b *EventBot) cmdWho(msg InboundMessage, args []string) ([]string, error) {
+	event, ok := b.findEvent(args[0])
+	if !ok {
+		return []string{EVENTBOT_CMD_EVENT_UNKNOWN}, nil
+	}
+	attendees := b.attendees([]Event{event})[event.Id]
+	if len(attendees) == 0 {
+		return []string{fmt.Sprintf(EVENTBOT_CMD_WHO_NONE, event.Id)}, nil
+	}
+	return []string{fmt.Sprintf(EVENTBOT_CMD_WHO_ATTENDEES, event.Id,
+		len(attendees), strings.Join(attendeeNames(attendees), ", "))}, nil
+}
+
+func (b *EventBot) cmdImport(msg InboundMessage, args []string) ([]string, error) {
+	url := args[0]
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		// never read local files on behalf of IRC users
+		return []string{fmt.Sprintf(EVENTBOT_IMPORT_FAILED,
+			"only http and https URLs are supported")}, nil
+	}
+	// the download must not block the bot, the result follows
+	go func() {
+		msg.ReplyLater(b.importURL(url))
+	}()
+	return nil, nil
+}
+
+// importURL downloads a calendar and imports it. Only the import itself
+// holds the lock of the bot.
+func (b *EventBot) importURL(url string) string {
+	body, err := OpenICS(url)
+	if err != nil {
+		return fmt.Sprintf(EVENTBOT_IMPORT_FAILED, err.Error())
+	}
+	data, err := ioutil.ReadAll(body)
+	body.Close()
+	if err != nil {
+		return fmt.Sprintf(EVENTBOT_IMPORT_FAILED, err.Error())
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	result, err := b.ImportICS(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Sprintf(EVENTBOT_IMPORT_FAILED, err.Error())
+	}
+	return result.String()
+}
+
+// cmdMailtest sends a mail to the owner through the outbox. The result
+// is reported when the first attempt is done, so that a slow mail server
+// does not block the bot.
+func (b *EventBot) cmdMailtest(msg InboundMessage, args []string) ([]string, error) {
+	go func() {
+		entry, err := b.SendQueued(&Mail{
+			From:    b.MailSender.FromAddress,
+			To:      []string{b.OwnerEmailAddress},
+			Subject: "her0ld mail test",
+			Text:    "Testing mail. If you can read this everything should be working.\n",
+		})
+		switch {
+		case err == nil:
+			msg.ReplyLater(EVENTBOT_MAILTEST_REPLY)
+		case entry != nil && !entry.Failed:
+			msg.ReplyLater(fmt.Sprintf(EVENTBOT_MAILTEST_QUEUED, err.Error()))
+		default:
+			msg.ReplyLater(fmt.Sprintf(EVENTBOT_MAILTEST_FAILED, err.Error()))
 		}
-		return strings2reply(msg.Channel, answer), nil
-	} else if strings.HasPrefix(msg.Message, fmt.Sprintf("%s %s",
-		EVENTBOT_PREFIX, EVENTBOT_CMD_MAILREMINDER)) {
-		answer := []string{EVENTBOT_MAILREMINDER_NOTAUTHORIZED}
-		if b.isFromOwner(msg) {
-			switch b.SendEventList() {
-			case NO_EVENT_TODAY:
-				answer = []string{EVENTBOT_MAILREMINDER_NONE_AVAILABLE}
-			case SEND_ERROR:
-				answer = []string{EVENTBOT_MAILREMINDER_SEND_ERROR}
-			case SEND_SUCCESS:
-				answer = []string{EVENTBOT_MAILREMINDER_REPLY}
-			default:
-				log.Println("Unknown return code from SendEventList - WTF?")
+	}()
+	return nil, nil
+}
+
+func (b *EventBot) cmdMailreminder(msg InboundMessage, args []string) ([]string, error) {
+	if len(b.MailJobs) == 0 {
+		return []string{EVENTBOT_MAILREMINDER_NO_JOBS}, nil
+	}
+	job := b.MailJobs[0]
+	if len(args) > 0 {
+		var ok bool
+		if job, ok = b.findMailJob(args[0]); !ok {
+			var names []string
+			for _, j := range b.MailJobs {
+				names = append(names, j.Name)
 			}
+			return []string{fmt.Sprintf(EVENTBOT_MAILREMINDER_UNKNOWN_JOB, args[0],
+				strings.Join(names, ", "))}, nil
 		}
-		return strings2reply(msg.Channel, answer), nil
-	} else if strings.HasPrefix(msg.Message, EVENTBOT_PREFIX) {
-		// invalid command (!event foo)
-		answer := []string{EVENTBOT_INVALID_COMMAND}
-		return strings2reply(msg.Channel, answer), nil
-	} else { // something else
-		return nil, nil
 	}
+	// mails are composed and delivered in the background, the result
+	// follows
+	go func() {
+		msg.ReplyLater(mailReminderReply(b.SendEventList(job)))
+	}()
+	return nil, nil
 }
 
 func (b *EventBot) ProcessQueryEvent(msg InboundMessage) ([]OutboundMessage, error) {
-	b.NumMessagesHandled += 1
-	reply := make([]OutboundMessage, 1)
-	reply[0] = OutboundMessage{Destination: msg.Channel, Message: "Not implemented"}
-	return reply, nil
+	return b.ProcessChannelEvent(msg)
 }
 
 func (b *EventBot) GetName() string {
 	return b.BotName
 }
 
-func (b *EventBot) GetHelpLines() []string {
-	return strings.Split(EVENTBOT_HELP_TEXT, "\n")
+func (b *EventBot) GetCommands() *CommandRouter {
+	return b.Commands
 }
@@ -1,6 +1,7 @@
 package her0ldbot
 
 import (
+	"bytes"
 	"fmt"
 	"github.com/gonium/her0ld"
 	"os"
@@ -10,30 +11,42 @@ import (
 	"time"
 )
 
+const testOwnerNick = "testnick"
+
+// Everybody may edit events, the owner is identified by the hostmask.
 var genConfig her0ld.GeneralConfig = her0ld.GeneralConfig{
-	OwnerNick:        "testnick",
-	OwnerEmailAdress: "testowner@example.com",
+	OwnerEmailAddress: "testowner@example.com",
+	DefaultRole:       "event-editor",
+	Roles: []her0ld.RoleBinding{
+		{Role: "owner", Hostmask: testOwnerNick + "!*@owner.example.com"},
+	},
 }
 
 func MkEventBot() *EventBot {
 	file := filepath.Join(os.TempDir(), "her0ld-eventbot-test.db")
 	// delete test db if the file exists
 	_ = os.Remove(file)
-	return NewEventBot(
-		"EventBot",
-		her0ld.EventbotConfig{
-			DBFile:   file,
-			Timezone: "Europe/Berlin",
-		},
-		genConfig,
-	)
+	cfg := her0ld.EventbotConfig{
+		DBFile:   file,
+		Timezone: "Europe/Berlin",
+	}
+	db, err := OpenDatabase(cfg)
+	if err != nil {
+		panic(err)
+	}
+	bot := NewEventBot("EventBot", db, cfg, genConfig)
+	bot.Commands.Permissions, err = NewPermissions(genConfig, db)
+	if err != nil {
+		panic(err)
+	}
+	return bot
 }
 
 // Ignore ordinary chat messages
 func TestNoCommand(t *testing.T) {
 	bot := MkEventBot()
 	msg := InboundMessage{
-		Channel: "Channel",
+		Target:  "#channel",
 		Nick:    "Nick",
 		Message: "ordinarychatline",
 	}
@@ -53,7 +66,7 @@ func TestNoCommand(t *testing.T) {
 func TestInvalidCommand(t *testing.T) {
 	bot := MkEventBot()
 	msg := InboundMessage{
-		Channel: "Channel",
+		Target:  "#channel",
 		Nick:    "Nick",
 		Message: "!invalidcmd",
 	}
@@ -72,7 +85,7 @@ func TestInvalidCommand(t *testing.T) {
 func TestEmptyCommand(t *testing.T) {
 	bot := MkEventBot()
 	msg := InboundMessage{
-		Channel: "Channel",
+		Target:  "#channel",
 		Nick:    "Nick",
 		Message: EVENTBOT_PREFIX,
 	}
@@ -85,8 +98,8 @@ func TestEmptyCommand(t *testing.T) {
 			t.Fatalf("Invalid response length - expected %d, got %d", 1,
 				len(response))
 		} else {
-			if response[0].Destination != msg.Channel {
-				t.Fatalf("Invalid destination: Expected >%s<, got >%s<", msg.Channel,
+			if response[0].Destination != msg.Target {
+				t.Fatalf("Invalid destination: Expected >%s<, got >%s<", msg.Target,
 					response[0].Destination)
 			}
 			if response[0].Message != EVENTBOT_INVALID_COMMAND {
@@ -101,7 +114,7 @@ func TestHelpCommand(t *testing.T) {
 	bot := MkEventBot()
 	line := fmt.Sprintf("%s %s", EVENTBOT_PREFIX, EVENTBOT_CMD_HELP)
 	msg := InboundMessage{
-		Channel: "Channel",
+		Target:  "#channel",
 		Nick:    "Nick",
 		Message: line,
 	}
@@ -110,15 +123,15 @@ func TestHelpCommand(t *testing.T) {
 		t.Fatalf("Invalid command triggered unexpected error: %s",
 			err.Error())
 	} else {
-		expected := strings.Split(EVENTBOT_HELP_TEXT, "\n")
+		expected := bot.Commands.HelpLines(bot.eventCommand, "")
 		expected_len := len(expected)
 		if len(response) != expected_len {
 			t.Fatalf("Invalid length of response %#v - expected %d, got %d",
 				response, expected_len, len(response))
 		} else {
 			for idx, expected_line := range expected {
-				if response[idx].Destination != msg.Channel {
-					t.Fatalf("Invalid destination: Expected >%s<, got >%s<", msg.Channel,
+				if response[idx].Destination != msg.Target {
+					t.Fatalf("Invalid destination: Expected >%s<, got >%s<", msg.Target,
 						response[idx].Destination)
 				}
 				if response[idx].Message != expected_line {
@@ -130,20 +143,59 @@ func TestHelpCommand(t *testing.T) {
 	}
 }
 
+func TestQueryHelpCommand(t *testing.T) {
+	bot := MkEventBot()
+	line := fmt.Sprintf("%s %s", EVENTBOT_PREFIX, EVENTBOT_CMD_HELP)
+	msg := InboundMessage{
+		Target:  "EventBot",
+		Nick:    "Nick",
+		Message: line,
+	}
+	response, err := bot.ProcessQueryEvent(msg)
+	if err != nil {
+		t.Fatalf("Query command triggered unexpected error: %s",
+			err.Error())
+	}
+	expected := bot.Commands.HelpLines(bot.eventCommand, "")
+	if len(response) != len(expected) {
+		t.Fatalf("Invalid length of response %#v - expected %d, got %d",
+			response, len(expected), len(response))
+	}
+	for idx := range expected {
+		if response[idx].Destination != msg.Nick {
+			t.Fatalf("Invalid destination: Expected >%s<, got >%s<", msg.Nick,
+				response[idx].Destination)
+		}
+	}
+}
+
 // Test mail requested by owner of the bot
 func TestValidOwnerMail(t *testing.T) {
 	bot := MkEventBot()
+	transport := &testTransport{}
+	bot.MailTransport = transport
+	bot.MailSender.FromAddress = "bot@example.com"
+	bot.OwnerEmailAddress = "owner@example.com"
 	line := fmt.Sprintf("%s %s", EVENTBOT_PREFIX, EVENTBOT_CMD_MAILTEST)
 	msg := InboundMessage{
-		Channel: "Channel",
-		Nick:    genConfig.OwnerNick,
+		Target:  "#channel",
+		Nick:    testOwnerNick,
+		Source:  testOwnerNick + "!user@owner.example.com",
 		Message: line,
 	}
+	followup := collectFollowups(t, &msg)
 	response, err := bot.ProcessChannelEvent(msg)
 	if err != nil {
 		t.Fatalf("mailtest command triggered unexpected error: %s",
 			err.Error())
+	} else if len(response) != 0 {
+		t.Fatalf("The result of the mail test should follow, got %v", response)
 	} else {
+		// the mail is delivered in the background
+		response = followup()
+		if len(transport.delivered) != 1 {
+			t.Fatalf("Test mail not delivered")
+		}
 		expected := strings.Split(EVENTBOT_MAILTEST_REPLY, "\n")
 		expected_len := len(expected)
 		if len(response) != expected_len {
@@ -151,8 +203,8 @@ func TestValidOwnerMail(t *testing.T) {
 				len(response))
 		} else {
 			for idx, expected_line := range expected {
-				if response[idx].Destination != msg.Channel {
-					t.Fatalf("Invalid destination: Expected >%s<, got >%s<", msg.Channel,
+				if response[idx].Destination != msg.Target {
+					t.Fatalf("Invalid destination: Expected >%s<, got >%s<", msg.Target,
 						response[idx].Destination)
 				}
 				if response[idx].Message != expected_line {
@@ -168,9 +220,11 @@ func TestValidOwnerMail(t *testing.T) {
 func TestInvalidOwnerMail(t *testing.T) {
 	bot := MkEventBot()
 	line := fmt.Sprintf("%s %s", EVENTBOT_PREFIX, EVENTBOT_CMD_MAILTEST)
+	// same nick, but a different host
 	msg := InboundMessage{
-		Channel: "Channel",
-		Nick:    genConfig.OwnerNick + "fooo",
+		Target:  "#channel",
+		Nick:    testOwnerNick,
+		Source:  testOwnerNick + "!user@spoofed.example.com",
 		Message: line,
 	}
 	response, err := bot.ProcessChannelEvent(msg)
@@ -178,15 +232,15 @@ func TestInvalidOwnerMail(t *testing.T) {
 		t.Fatalf("mailtest command triggered unexpected error: %s",
 			err.Error())
 	} else {
-		expected := strings.Split(EVENTBOT_MAILTEST_NOTAUTHORIZED, "\n")
+		expected := []string{fmt.Sprintf(COMMAND_NOT_AUTHORIZED, RoleOwner)}
 		expected_len := len(expected)
 		if len(response) != expected_len {
 			t.Fatalf("Invalid response length - expected %d, got %d", expected_len,
 				len(response))
 		} else {
 			for idx, expected_line := range expected {
-				if response[idx].Destination != msg.Channel {
-					t.Fatalf("Invalid destination: Expected >%s<, got >%s<", msg.Channel,
+				if response[idx].Destination != msg.Target {
+					t.Fatalf("Invalid destination: Expected >%s<, got >%s<", msg.Target,
 						response[idx].Destination)
 				}
 				if response[idx].Message != expected_line {
@@ -202,7 +256,7 @@ func TestInvalidAddCommand(t *testing.T) {
 	bot := MkEventBot()
 	line := fmt.Sprintf("%s %s invalid date event", EVENTBOT_PREFIX, EVENTBOT_CMD_ADD)
 	msg := InboundMessage{
-		Channel: "Channel",
+		Target:  "#channel",
 		Nick:    "Nick",
 		Message: line,
 	}
@@ -218,8 +272,8 @@ func TestInvalidAddCommand(t *testing.T) {
 				response, expected_len, len(response))
 		} else {
 			for idx, expected_line := range expected {
-				if response[idx].Destination != msg.Channel {
-					t.Fatalf("Invalid destination: Expected >%s<, got >%s<", msg.Channel,
+				if response[idx].Destination != msg.Target {
+					t.Fatalf("Invalid destination: Expected >%s<, got >%s<", msg.Target,
 						response[idx].Destination)
 				}
 				if response[idx].Message != expected_line {
@@ -241,7 +295,7 @@ func TestValidAddCommand(t *testing.T) {
 	line := fmt.Sprintf("%s %s %s test", EVENTBOT_PREFIX,
 		EVENTBOT_CMD_ADD, EVENTBOT_TIME_FORMAT)
 	msg := InboundMessage{
-		Channel: "Channel",
+		Target:  "#channel",
 		Nick:    "Nick",
 		Message: line,
 	}
@@ -250,15 +304,16 @@ func TestValidAddCommand(t *testing.T) {
 		t.Fatalf("command triggered unexpected error: %s",
 			err.Error())
 	} else {
-		expected := strings.Split(EVENTBOT_CMD_ADD_SUCCESS, "\n")
+		expected := []string{fmt.Sprintf(EVENTBOT_CMD_ADD_SUCCESS, 1,
+			"Mon 2 Jan 2006, 15:04")}
 		expected_len := 1
 		if len(response) != expected_len {
 			t.Fatalf("Invalid length of response %#v - expected %d, got %d",
 				response, expected_len, len(response))
 		} else {
 			for idx, expected_line := range expected {
-				if response[idx].Destination != msg.Channel {
-					t.Fatalf("Invalid destination: Expected >%s<, got >%s<", msg.Channel,
+				if response[idx].Destination != msg.Target {
+					t.Fatalf("Invalid destination: Expected >%s<, got >%s<", msg.Target,
 						response[idx].Destination)
 				}
 				if response[idx].Message != expected_line {
@@ -285,7 +340,7 @@ func TestListNoAvailableEvents(t *testing.T) {
 	line := fmt.Sprintf("%s %s", EVENTBOT_PREFIX,
 		EVENTBOT_CMD_LIST)
 	msg := InboundMessage{
-		Channel: "Channel",
+		Target:  "#channel",
 		Nick:    "Nick",
 		Message: line,
 	}
@@ -301,8 +356,8 @@ func TestListNoAvailableEvents(t *testing.T) {
 				response, expected_len, len(response))
 		} else {
 			for idx, expected_line := range expected {
-				if response[idx].Destination != msg.Channel {
-					t.Fatalf("Invalid destination: Expected >%s<, got >%s<", msg.Channel,
+				if response[idx].Destination != msg.Target {
+					t.Fatalf("Invalid destination: Expected >%s<, got >%s<", msg.Target,
 						response[idx].Destination)
 				}
 				if response[idx].Message != expected_line {
@@ -328,7 +383,7 @@ func TestListOnlyUpcomingEvents(t *testing.T) {
 		Description: "Old legacy event",
 	}
 	upcomingEvent := Event{
-		Starttime:   time.Now().Add(10 * time.Second),
+		Starttime:   time.Now().In(bot.TimeLocation).Add(10 * time.Second),
 		Description: "Upcoming legacy event",
 	}
 	bot.Db.Create(&oldEvent)
@@ -340,7 +395,7 @@ func TestListOnlyUpcomingEvents(t *testing.T) {
 	line := fmt.Sprintf("%s %s", EVENTBOT_PREFIX,
 		EVENTBOT_CMD_LIST)
 	msg := InboundMessage{
-		Channel: "Channel",
+		Target:  "#channel",
 		Nick:    "Nick",
 		Message: line,
 	}
@@ -356,8 +411,8 @@ func TestListOnlyUpcomingEvents(t *testing.T) {
 				response, expected_len, len(response))
 		} else {
 			for idx, expected_line := range expected {
-				if response[idx].Destination != msg.Channel {
-					t.Fatalf("Invalid destination: Expected >%s<, got >%s<", msg.Channel,
+				if response[idx].Destination != msg.Target {
+					t.Fatalf("Invalid destination: Expected >%s<, got >%s<", msg.Target,
 						response[idx].Destination)
 				}
 				if response[idx].Message != expected_line {
@@ -395,7 +450,7 @@ func TestListTodayNoEvents(t *testing.T) {
 	line := fmt.Sprintf("%s %s", EVENTBOT_PREFIX,
 		EVENTBOT_CMD_TODAY)
 	msg := InboundMessage{
-		Channel: "Channel",
+		Target:  "#channel",
 		Nick:    "Nick",
 		Message: line,
 	}
@@ -411,8 +466,8 @@ func TestListTodayNoEvents(t *testing.T) {
 				response, expected_len, len(response))
 		} else {
 			for idx, expected_line := range expected {
-				if response[idx].Destination != msg.Channel {
-					t.Fatalf("Invalid destination: Expected >%s<, got >%s<", msg.Channel,
+				if response[idx].Destination != msg.Target {
+					t.Fatalf("Invalid destination: Expected >%s<, got >%s<", msg.Target,
 						response[idx].Destination)
 				}
 				if response[idx].Message != expected_line {
@@ -436,11 +491,11 @@ func TestListTodayTwoEvents(t *testing.T) {
 		Description: "Old legacy event",
 	}
 	firstEvent := Event{
-		Starttime:   time.Now(),
+		Starttime:   time.Now().In(bot.TimeLocation),
 		Description: "first today event",
 	}
 	secondEvent := Event{
-		Starttime:   time.Now().Add(10 * time.Second),
+		Starttime:   time.Now().In(bot.TimeLocation).Add(10 * time.Second),
 		Description: "second today event",
 	}
 	upcomingEvent := Event{
@@ -458,7 +513,7 @@ func TestListTodayTwoEvents(t *testing.T) {
 	line := fmt.Sprintf("%s %s", EVENTBOT_PREFIX,
 		EVENTBOT_CMD_TODAY)
 	msg := InboundMessage{
-		Channel: "Channel",
+		Target:  "#channel",
 		Nick:    "Nick",
 		Message: line,
 	}
@@ -476,8 +531,8 @@ func TestListTodayTwoEvents(t *testing.T) {
 				response, expected_len, len(response))
 		} else {
 			for idx, expected_line := range expected {
-				if response[idx].Destination != msg.Channel {
-					t.Fatalf("Invalid destination: Expected >%s<, got >%s<", msg.Channel,
+				if response[idx].Destination != msg.Target {
+					t.Fatalf("Invalid destination: Expected >%s<, got >%s<", msg.Target,
 						response[idx].Destination)
 				}
 				if response[idx].Message != expected_line {
@@ -513,7 +568,7 @@ func TestDeleteInvalidEventID(t *testing.T) {
 	line := fmt.Sprintf("%s %s 23", EVENTBOT_PREFIX,
 		EVENTBOT_CMD_DELETE)
 	msg := InboundMessage{
-		Channel: "Channel",
+		Target:  "#channel",
 		Nick:    "Nick",
 		Message: line,
 	}
@@ -529,8 +584,8 @@ func TestDeleteInvalidEventID(t *testing.T) {
 				response, expected_len, len(response))
 		} else {
 			for idx, expected_line := range expected {
-				if response[idx].Destination != msg.Channel {
-					t.Fatalf("Invalid destination: Expected >%s<, got >%s<", msg.Channel,
+				if response[idx].Destination != msg.Target {
+					t.Fatalf("Invalid destination: Expected >%s<, got >%s<", msg.Target,
 						response[idx].Destination)
 				}
 				if response[idx].Message != expected_line {
@@ -566,7 +621,7 @@ func TestDeleteEventID(t *testing.T) {
 	line := fmt.Sprintf("%s %s 1", EVENTBOT_PREFIX,
 		EVENTBOT_CMD_DELETE)
 	msg := InboundMessage{
-		Channel: "Channel",
+		Target:  "#channel",
 		Nick:    "Nick",
 		Message: line,
 	}
@@ -582,8 +637,8 @@ func TestDeleteEventID(t *testing.T) {
 				response, expected_len, len(response))
 		} else {
 			for idx, expected_line := range expected {
-				if response[idx].Destination != msg.Channel {
-					t.Fatalf("Invalid destination: Expected >%s<, got >%s<", msg.Channel,
+				if response[idx].Destination != msg.Target {
+					t.Fatalf("Invalid destination: Expected >%s<, got >%s<", msg.Target,
 						response[idx].Destination)
 				}
 				if response[idx].Message != expected_line {
@@ -599,3 +654,282 @@ func TestDeleteEventID(t *testing.T) {
 	}
 
 }
+
+func eventCommand(t *testing.T, bot *EventBot, command string) []string {
+	msg := InboundMessage{
+		Target:  "#channel",
+		Nick:    "Nick",
+		Source:  "Nick!user@example.com",
+		Message: EVENTBOT_PREFIX + " " + command,
+	}
+	response, err := bot.ProcessChannelEvent(msg)
+	if err != nil {
+		t.Fatalf("command %q triggered unexpected error: %s", command,
+			err.Error())
+	}
+	var lines []string
+	for _, line := range response {
+		lines = append(lines, line.Message)
+	}
+	return lines
+}
+
+// collectFollowups makes msg collect the answers sent after processing
+// it. The returned function waits for the next answer.
+func collectFollowups(t *testing.T, msg *InboundMessage) func() []OutboundMessage {
+	answers := make(chan []OutboundMessage, 10)
+	msg.Followup = func(lines []OutboundMessage) {
+		answers <- lines
+	}
+	return func() []OutboundMessage {
+		select {
+		case lines := <-answers:
+			return lines
+		case <-time.After(5 * time.Second):
+			t.Fatalf("No follow-up answer to %q", msg.Message)
+		}
+		return nil
+	}
+}
+
+func TestRecurringEvent(t *testing.T) {
+	bot := MkEventBot()
+	// tomorrow, 19:00
+	today, _ := bot.dayBounds(time.Now())
+	start := today.AddDate(0, 0, 1).Add(19 * time.Hour)
+	event := Event{Starttime: start, Description: "Weekly meetup"}
+	bot.Db.Create(&event)
+
+	response := eventCommand(t, bot, "repeat 1 weekly")
+	expected := fmt.Sprintf(EVENTBOT_CMD_REPEAT_SUCCESS, 1, "weekly")
+	if len(response) != 1 || response[0] != expected {
+		t.Fatalf("Invalid response: Expected >%s<, got %#v", expected, response)
+	}
+	response = eventCommand(t, bot, "repeat 1 sometimes")
+	if len(response) != 1 || !strings.HasPrefix(response[0], "Invalid recurrence") {
+		t.Fatalf("Invalid recurrence was accepted: %#v", response)
+	}
+	var audit EventAudit
+	bot.Db.Where("event_id = ? and field = ?", 1, "rrule").First(&audit)
+	if audit.OldValue != "" || audit.NewValue != "FREQ=WEEKLY" || audit.ChangedBy != "Nick!user@example.com" {
+		t.Fatalf("Invalid audit record: %#v", audit)
+	}
+
+	// four weekly occurrences within the horizon
+	response = eventCommand(t, bot, EVENTBOT_CMD_LIST)
+	if len(response) != 4 {
+		t.Fatalf("Expected 4 occurrences, got %#v", response)
+	}
+	second := start.AddDate(0, 0, 7)
+	expected = fmt.Sprintf("(1) %s - Weekly meetup (weekly)",
+		second.Format("Mon 2 Jan 2006, 15:04"))
+	if response[1] != expected {
+		t.Fatalf("Invalid occurrence: Expected >%s<, got >%s<", expected,
+			response[1])
+	}
+
+	// delete a single occurrence
+	response = eventCommand(t, bot, "del 1 "+second.Format(EVENTBOT_DATE_FORMAT))
+	expected = fmt.Sprintf(EVENTBOT_CMD_DELETED_OCCURRENCE, 1,
+		second.Format(EVENTBOT_TIME_FORMAT))
+	if len(response) != 1 || response[0] != expected {
+		t.Fatalf("Invalid response: Expected >%s<, got %#v", expected, response)
+	}
+	response = eventCommand(t, bot, "del 1 "+today.Format(EVENTBOT_DATE_FORMAT))
+	expected = fmt.Sprintf(EVENTBOT_CMD_OCCURRENCE_UNKNOWN, 1,
+		today.Format(EVENTBOT_DATE_FORMAT))
+	if len(response) != 1 || response[0] != expected {
+		t.Fatalf("Invalid response: Expected >%s<, got %#v", expected, response)
+	}
+	response = eventCommand(t, bot, EVENTBOT_CMD_LIST)
+	if len(response) != 3 {
+		t.Fatalf("Expected 3 occurrences after deleting one, got %#v", response)
+	}
+
+	// delete the whole series
+	response = eventCommand(t, bot, "del 1")
+	if len(response) != 1 || response[0] != fmt.Sprintf(EVENTBOT_CMD_DELETED_EVENT, 1) {
+		t.Fatalf("Failed to delete series: %#v", response)
+	}
+	var exceptions []EventException
+	bot.Db.Find(&exceptions)
+	if len(exceptions) != 0 {
+		t.Fatalf("Exceptions of the deleted series were kept")
+	}
+}
+
+func TestRecurringEventToday(t *testing.T) {
+	bot := MkEventBot()
+	// started a month ago, takes place every day
+	today, tomorrow := bot.dayBounds(time.Now())
+	event := Event{
+		Starttime:   today.AddDate(0, -1, 0).Add(20 * time.Hour),
+		Description: "Daily standup",
+		Rrule:       "FREQ=DAILY",
+	}
+	bot.Db.Create(&event)
+	events := bot.eventsBetween(today, tomorrow)
+	if len(events) != 1 {
+		t.Fatalf("Expected one occurrence today, got %d", len(events))
+	}
+	if events[0].Starttime.Hour() != 20 || events[0].Starttime.Day() != today.Day() {
+		t.Fatalf("Occurrence at wrong time: %s", events[0].Starttime)
+	}
+	response := eventCommand(t, bot, EVENTBOT_CMD_TODAY)
+	if len(response) != 1 || !strings.Contains(response[0], "Daily standup (daily)") {
+		t.Fatalf("Recurring event missing today: %#v", response)
+	}
+}
+
+func TestEditEvent(t *testing.T) {
+	bot := MkEventBot()
+	start := time.Date(2030, 5, 3, 19, 0, 0, 0, bot.TimeLocation)
+	bot.Db.Create(&Event{Starttime: start, Description: "Meetup"})
+	msg := InboundMessage{
+		Target:  "#channel",
+		Nick:    "editor",
+		Source:  "editor!user@example.com",
+		Message: EVENTBOT_PREFIX + " edit 1 date 04.05.2030-20:00",
+	}
+	tests := []struct {
+		command  string
+		expected string
+	}{
+		{"edit 1 date 04.05.2030-20:00", fmt.Sprintf(EVENTBOT_CMD_EDIT_SUCCESS, 1,
+			"date", "03.05.2030-19:00", "04.05.2030-20:00")},
+		{"edit 1 desc Monthly meetup", fmt.Sprintf(EVENTBOT_CMD_EDIT_SUCCESS, 1,
+			"description", "Meetup", "Monthly meetup")},
+		{"edit 1 location Hackerspace, 1st floor", fmt.Sprintf(EVENTBOT_CMD_EDIT_SUCCESS, 1,
+			"location", "", "Hackerspace, 1st floor")},
+		{"edit 1 location Hackerspace, 1st floor", fmt.Sprintf(EVENTBOT_CMD_EDIT_UNCHANGED, 1,
+			"location", "Hackerspace, 1st floor")},
+		{"edit 1 date tomorrow", EVENTBOT_INVALID_TIME_FORMAT},
+		{"edit 1 color red", fmt.Sprintf(EVENTBOT_CMD_EDIT_INVALID_FIELD, "color")},
+		{"edit 2 desc foo", EVENTBOT_CMD_EVENT_UNKNOWN},
+	}
+	for _, test := range tests {
+		msg.Message = EVENTBOT_PREFIX + " " + test.command
+		response, err := bot.ProcessChannelEvent(msg)
+		if err != nil {
+			t.Fatalf("command %q triggered unexpected error: %s", test.command,
+				err.Error())
+		}
+		if len(response) != 1 || response[0].Message != test.expected {
+			t.Fatalf("Invalid response to %q: Expected >%s<, got %#v",
+				test.command, test.expected, response)
+		}
+	}
+	event, _ := bot.findEvent("1")
+	if !event.Starttime.Equal(time.Date(2030, 5, 4, 20, 0, 0, 0, bot.TimeLocation)) ||
+		event.Description != "Monthly meetup" ||
+		event.Location != "Hackerspace, 1st floor" {
+		t.Fatalf("Event was not changed: %#v", event)
+	}
+	var audits []EventAudit
+	bot.Db.Order("id").Find(&audits)
+	if len(audits) != 3 {
+		t.Fatalf("Expected 3 audit records, got %d", len(audits))
+	}
+	if audits[1].Field != "description" || audits[1].OldValue != "Meetup" ||
+		audits[1].NewValue != "Monthly meetup" || audits[1].ChangedBy != msg.Source {
+		t.Fatalf("Invalid audit record: %#v", audits[1])
+	}
+}
+
+func TestAddRelativeDate(t *testing.T) {
+	bot := MkEventBot()
+	response := eventCommand(t, bot, "add tomorrow 19:00 Meetup at the lab")
+	tomorrow := time.Now().In(bot.TimeLocation).AddDate(0, 0, 1)
+	start := time.Date(tomorrow.Year(), tomorrow.Month(), tomorrow.Day(), 19,
+		0, 0, 0, bot.TimeLocation)
+	expected := fmt.Sprintf(EVENTBOT_CMD_ADD_SUCCESS, 1,
+		start.Format(EVENTBOT_DISPLAY_TIME_FORMAT))
+	if len(response) != 1 || response[0] != expected {
+		t.Fatalf("Expected >%s<, got %#v", expected, response)
+	}
+	var event Event
+	bot.Db.First(&event)
+	if !event.Starttime.Equal(start) {
+		t.Fatalf("Expected start %s, got %s", start, event.Starttime)
+	}
+	if event.Description != "Meetup at the lab" {
+		t.Fatalf("Unexpected description %q", event.Description)
+	}
+	response = eventCommand(t, bot, "add next friday 20:00")
+	if len(response) != 1 || response[0] != EVENTBOT_CMD_ADD_NO_DESCRIPTION {
+		t.Fatalf("Expected >%s<, got %#v", EVENTBOT_CMD_ADD_NO_DESCRIPTION,
+			response)
+	}
+}
+
+func TestAttendance(t *testing.T) {
+	bot := MkEventBot()
+	start := time.Now().Add(24 * time.Hour).Truncate(time.Minute)
+	bot.Db.Create(&Event{Starttime: start, Description: "Meetup"})
+	command := func(nick, account, command string) []string {
+		msg := InboundMessage{
+			Target:  "#channel",
+			Nick:    nick,
+			Account: account,
+			Message: EVENTBOT_PREFIX + " " + command,
+		}
+		response, err := bot.ProcessChannelEvent(msg)
+		if err != nil {
+			t.Fatalf("command %q triggered unexpected error: %s", command,
+				err.Error())
+		}
+		var lines []string
+		for _, line := range response {
+			lines = append(lines, line.Message)
+		}
+		return lines
+	}
+	for _, test := range []struct {
+		nick, account, command, expected string
+	}{
+		{"alice", "", "who 1", fmt.Sprintf(EVENTBOT_CMD_WHO_NONE, 1)},
+		{"alice", "", "join 1", fmt.Sprintf(EVENTBOT_CMD_JOIN_SUCCESS, "alice", 1)},
+		{"Alice", "", "join 1", fmt.Sprintf(EVENTBOT_CMD_JOIN_ALREADY, "Alice", 1)},
+		{"bob", "bobby", "join 1", fmt.Sprintf(EVENTBOT_CMD_JOIN_SUCCESS, "bob", 1)},
+		{"bob_away", "bobby", "join 1", fmt.Sprintf(EVENTBOT_CMD_JOIN_ALREADY, "bob_away", 1)},
+		{"carol", "", "leave 1", fmt.Sprintf(EVENTBOT_CMD_LEAVE_NOT_ATTENDING, "carol", 1)},
+		{"carol", "", "join 1", fmt.Sprintf(EVENTBOT_CMD_JOIN_SUCCESS, "carol", 1)},
+		{"carol", "", "leave 1", fmt.Sprintf(EVENTBOT_CMD_LEAVE_SUCCESS, "carol", 1)},
+		{"carol", "", "join 2", EVENTBOT_CMD_EVENT_UNKNOWN},
+		{"carol", "", "who 1", fmt.Sprintf(EVENTBOT_CMD_WHO_ATTENDEES, 1, 2,
+			"Alice, bob_away")},
+	} {
+		response := command(test.nick, test.account, test.command)
+		if len(response) != 1 || response[0] != test.expected {
+			t.Fatalf("%s %q: expected >%s<, got %#v", test.nick, test.command,
+				test.expected, response)
+		}
+	}
+
+	response := eventCommand(t, bot, "list")
+	expected := fmt.Sprintf("(1) %s - Meetup"+EVENTBOT_ATTENDEE_COUNT,
+		start.In(bot.TimeLocation).Format(EVENTBOT_DISPLAY_TIME_FORMAT), 2)
+	if len(response) != 1 || response[0] != expected {
+		t.Fatalf("Expected >%s<, got %#v", expected, response)
+	}
+
+	var events []Event
+	bot.Db.Find(&events)
+	mail := bot.describeWithAttendees(events)
+	if !strings.Contains(mail, "Attendees (2): Alice, bob_away") {
+		t.Fatalf("Attendees missing in mail text:\n%s", mail)
+	}
+	var ics bytes.Buffer
+	bot.WriteICS(&ics, events, nil)
+	if !strings.Contains(ics.String(), "ATTENDEE;CN=Alice;PARTSTAT=ACCEPTED:irc:Alice\r\n") {
+		t.Fatalf("Attendee missing in calendar:\n%s", ics.String())
+	}
+
+	// attendees are removed with their event
+	eventCommand(t, bot, "del 1")
+	var attendees []Attendee
+	bot.Db.Find(&attendees)
+	if len(attendees) != 0 {
+		t.Fatalf("Attendees of deleted event remain: %#v", attendees)
+	}
+}
@@ -0,0 +1,220 @@
+package her0ldbot
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Fields of an event that can be set with key=value arguments of the
+// add command, by their names and abbreviations.
+var eventFieldKeys = map[string]string{
+	"location": "location",
+	"loc":      "location",
+	"end":      "end",
+	"duration": "duration",
+	"dur":      "duration",
+	"url":      "url",
+	"category": "category",
+	"cat":      "category",
+	"tags":     "tags",
+}
+
+/* A fieldValue is a key=value argument, the value may span several
+ * words. */
+type fieldValue struct {
+	Key   string
+	Words []string
+}
+
+// Duration returns the length of the event, EVENTBOT_DEFAULT_DURATION
+// if it has no end.
+func (e *Event) Duration() time.Duration {
+	if e.Endtime.IsZero() || !e.Endtime.After(e.Starttime) {
+		return EVENTBOT_DEFAULT_DURATION
+	}
+	return e.Endtime.Sub(e.Starttime)
+}
+
+// TagList returns the tags of the event.
+func (e *Event) TagList() []string {
+	if e.Tags == "" {
+		return nil
+	}
+	return strings.Split(e.Tags, ",")
+}
+
+// InCategory reports whether the category or one of the tags of the
+// event is name.
+func (e *Event) InCategory(name string) bool {
+	if strings.EqualFold(e.Category, name) {
+		return true
+	}
+	for _, tag := range e.TagList() {
+		if strings.EqualFold(tag, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeTags turns a list of tags separated by commas or spaces into
+// the stored form, e.g. "python,beginners".
+func normalizeTags(s string) string {
+	var tags []string
+	seen := make(map[string]bool)
+	for _, tag := range strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return r == ',' || r == ' '
+	}) {
+		if !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	return strings.Join(tags, ",")
+}
+
+// splitFieldValues separates the description from the trailing
+// key=value arguments, e.g. "Meetup location=Room 2 url=https://x.org".
+// Words after a key=value argument belong to its value. Unknown keys are
+// part of the description.
+func splitFieldValues(words []string) ([]string, []fieldValue) {
+	var description []string
+	var values []fieldValue
+	for _, word := range words {
+		parts := strings.SplitN(word, "=", 2)
+		if key, ok := eventFieldKeys[strings.ToLower(parts[0])]; ok && len(parts) == 2 {
+			fv := fieldValue{Key: key}
+			if parts[1] != "" {
+				fv.Words = []string{parts[1]}
+			}
+			values = append(values, fv)
+		} else if len(values) > 0 {
+			last := &values[len(values)-1]
+			last.Words = append(last.Words, word)
+		} else {
+			description = append(description, word)
+		}
+	}
+	return description, values
+}
+
+// formatEnd formats the end of an event for the audit records.
+func (b *EventBot) formatEnd(e *Event) string {
+	if e.Endtime.IsZero() {
+		return ""
+	}
+	return e.Endtime.In(b.TimeLocation).Format(EVENTBOT_TIME_FORMAT)
+}
+
+// parseEnd parses the end of an event, either a time of day on the day
+// of the start (or the following day, if the time is before the start)
+// or a full date and time.
+func (b *EventBot) parseEnd(start time.Time, words []string, now time.Time) (time.Time, error) {
+	start = start.In(b.TimeLocation)
+	var end time.Time
+	if hour, minute, used, ok := parseClock(words); ok && used == len(words) {
+		end = time.Date(start.Year(), start.Month(), start.Day(), hour, minute,
+			0, 0, b.TimeLocation)
+		if !end.After(start) {
+			end = end.AddDate(0, 0, 1)
+		}
+	} else {
+		t, used, err := ParseTime(words, now)
+		if err != nil || used < len(words) {
+			return end, errors.New(EVENTBOT_INVALID_END)
+		}
+		end = t
+	}
+	if !end.After(start) {
+		return end, errors.New(EVENTBOT_INVALID_END)
+	}
+	return end, nil
+}
+
+// setField changes a field of the event, key is one of the keys of the
+// edit command. It returns the name of the changed field with its old
+// and new value. The errors are answers for the channel.
+func (b *EventBot) setField(event *Event, key string, words []string,
+	now time.Time) (field, oldValue, newValue string, err error) {
+	value := strings.Join(words, " ")
+	unset := value == EVENTBOT_EMPTY_VALUE
+	if k, ok := eventFieldKeys[strings.ToLower(key)]; ok {
+		key = k
+	}
+	switch strings.ToLower(key) {
+	case "date", "time":
+		field = "date"
+		date, used, perr := ParseTime(words, now)
+		if perr != nil || used < len(words) {
+			return field, "", "", errors.New(EVENTBOT_INVALID_TIME_FORMAT)
+		}
+		oldValue = event.Starttime.In(b.TimeLocation).Format(EVENTBOT_TIME_FORMAT)
+		newValue = date.Format(EVENTBOT_TIME_FORMAT)
+		if oldValue != newValue && !event.Endtime.IsZero() {
+			// the event keeps its duration
+			event.Endtime = event.Endtime.Add(date.Sub(event.Starttime))
+		}
+		event.Starttime = date
+	case "desc", "description":
+		field = "description"
+		if value == "" {
+			return field, "", "", errors.New(EVENTBOT_CMD_EDIT_EMPTY_DESCRIPTION)
+		}
+		oldValue, newValue = event.Description, value
+		event.Description = value
+	case "location":
+		field = "location"
+		if unset {
+			value = ""
+		}
+		oldValue, newValue = event.Location, value
+		event.Location = value
+	case "end", "duration":
+		field = "end"
+		oldValue = b.formatEnd(event)
+		var end time.Time
+		if key == "duration" && !unset {
+			d, perr := time.ParseDuration(value)
+			if perr != nil || d <= 0 {
+				return field, "", "", errors.New(EVENTBOT_INVALID_END)
+			}
+			end = event.Starttime.Add(d)
+		} else if !unset {
+			if end, err = b.parseEnd(event.Starttime, words, now); err != nil {
+				return field, "", "", err
+			}
+		}
+		event.Endtime = end
+		newValue = b.formatEnd(event)
+	case "url":
+		field = "url"
+		if unset {
+			value = ""
+		} else if u, perr := url.Parse(value); perr != nil ||
+			(u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return field, "", "", errors.New(EVENTBOT_INVALID_URL)
+		}
+		oldValue, newValue = event.Url, value
+		event.Url = value
+	case "category":
+		field = "category"
+		if unset {
+			value = ""
+		}
+		oldValue, newValue = event.Category, value
+		event.Category = value
+	case "tags":
+		field = "tags"
+		if unset {
+			value = ""
+		}
+		oldValue, newValue = event.Tags, normalizeTags(value)
+		event.Tags = newValue
+	default:
+		return "", "", "", fmt.Errorf(EVENTBOT_CMD_EDIT_INVALID_FIELD, key)
+	}
+	return field, oldValue, newValue, nil
+}
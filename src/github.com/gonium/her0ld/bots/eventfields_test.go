@@ -0,0 +1,168 @@
+package her0ldbot
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSplitFieldValues(t *testing.T) {
+	description, values := splitFieldValues(strings.Fields(
+		"Meetup a=b location=Room 2 url=https://example.com TAGS=python"))
+	if strings.Join(description, " ") != "Meetup a=b" {
+		t.Fatalf("Unexpected description %#v", description)
+	}
+	expected := []fieldValue{
+		{Key: "location", Words: []string{"Room", "2"}},
+		{Key: "url", Words: []string{"https://example.com"}},
+		{Key: "tags", Words: []string{"python"}},
+	}
+	if fmt.Sprint(values) != fmt.Sprint(expected) {
+		t.Fatalf("Expected %v, got %v", expected, values)
+	}
+}
+
+func TestAddEventWithFields(t *testing.T) {
+	bot := MkEventBot()
+	response := eventCommand(t, bot, "add 05.05.2016-19:00 Python meetup "+
+		"end=22:00 location=Lab, room 2 url=https://example.com/meetup "+
+		"category=meetup tags=Python, beginners")
+	if len(response) != 1 || !strings.HasPrefix(response[0], "Recorded new event 1") {
+		t.Fatalf("Unexpected response %#v", response)
+	}
+	var event Event
+	bot.Db.First(&event)
+	start := time.Date(2016, 5, 5, 19, 0, 0, 0, bot.TimeLocation)
+	if !event.Endtime.Equal(start.Add(3*time.Hour)) || event.Location != "Lab, room 2" ||
+		event.Url != "https://example.com/meetup" || event.Category != "meetup" ||
+		event.Tags != "python,beginners" || event.Description != "Python meetup" {
+		t.Fatalf("Unexpected event %#v", event)
+	}
+	event.Starttime = event.Starttime.In(bot.TimeLocation)
+	expected := "(1) Thu 5 May 2016, 19:00-22:00 - Python meetup @ Lab, room 2 " +
+		"[meetup] https://example.com/meetup"
+	if event.String() != expected {
+		t.Fatalf("Expected >%s<, got >%s<", expected, event.String())
+	}
+
+	for _, test := range []struct {
+		command  string
+		expected string
+	}{
+		{"add 05.05.2016-19:00 Meetup end=18:00 duration=-1h", EVENTBOT_INVALID_END},
+		{"add 05.05.2016-19:00 Meetup url=example.com", EVENTBOT_INVALID_URL},
+		{"add 05.05.2016-19:00 location=Lab", EVENTBOT_CMD_ADD_NO_DESCRIPTION},
+		{"edit 1 end 04.05.2016-19:00", EVENTBOT_INVALID_END},
+		{"edit 1 duration 90m", fmt.Sprintf(EVENTBOT_CMD_EDIT_SUCCESS, 1, "end",
+			"05.05.2016-22:00", "05.05.2016-20:30")},
+		{"edit 1 date 06.05.2016-19:00", fmt.Sprintf(EVENTBOT_CMD_EDIT_SUCCESS,
+			1, "date", "05.05.2016-19:00", "06.05.2016-19:00")},
+		{"edit 1 end 01:00", fmt.Sprintf(EVENTBOT_CMD_EDIT_SUCCESS, 1, "end",
+			"06.05.2016-20:30", "07.05.2016-01:00")},
+		{"edit 1 end -", fmt.Sprintf(EVENTBOT_CMD_EDIT_SUCCESS, 1, "end",
+			"07.05.2016-01:00", "")},
+		{"edit 1 cat talk", fmt.Sprintf(EVENTBOT_CMD_EDIT_SUCCESS, 1, "category",
+			"meetup", "talk")},
+		{"edit 1 tags go python", fmt.Sprintf(EVENTBOT_CMD_EDIT_SUCCESS, 1, "tags",
+			"python,beginners", "go,python")},
+		{"edit 1 url -", fmt.Sprintf(EVENTBOT_CMD_EDIT_SUCCESS, 1, "url",
+			"https://example.com/meetup", "")},
+	} {
+		response := eventCommand(t, bot, test.command)
+		if len(response) != 1 || response[0] != test.expected {
+			t.Fatalf("%q: expected >%s<, got %#v", test.command, test.expected,
+				response)
+		}
+	}
+}
+
+func TestListCategory(t *testing.T) {
+	bot := MkEventBot()
+	start := time.Now().Add(24 * time.Hour).Truncate(time.Minute)
+	bot.Db.Create(&Event{Starttime: start, Description: "Talk",
+		Category: "talk", Tags: "python"})
+	bot.Db.Create(&Event{Starttime: start.Add(time.Hour), Description: "Meetup",
+		Category: "meetup"})
+	for category, expected := range map[string]string{
+		"":       "Talk,Meetup",
+		"Meetup": "Meetup",
+		"python": "Talk",
+	} {
+		response := eventCommand(t, bot, strings.TrimSpace("list "+category))
+		var descriptions []string
+		for _, line := range response {
+			descriptions = append(descriptions, strings.Fields(line)[7])
+		}
+		if strings.Join(descriptions, ",") != expected {
+			t.Fatalf("list %s: expected %s, got %#v", category, expected, response)
+		}
+	}
+	response := eventCommand(t, bot, "list party")
+	expected := fmt.Sprintf(EVENTBOT_CMD_LIST_NONE_IN_CATEGORY, "party")
+	if len(response) != 1 || response[0] != expected {
+		t.Fatalf("Expected >%s<, got %#v", expected, response)
+	}
+}
+
+func TestEventFieldsICSRoundTrip(t *testing.T) {
+	bot := MkEventBot()
+	start := time.Date(2016, 5, 5, 19, 0, 0, 0, bot.TimeLocation)
+	event := Event{
+		Starttime:   start,
+		Endtime:     start.Add(150 * time.Minute),
+		Description: "Meetup",
+		Url:         "https://example.com/meetup",
+		Category:    "meetup, monthly",
+		Tags:        "python,beginners",
+		Rrule:       "FREQ=WEEKLY",
+	}
+	bot.Db.Create(&event)
+	var ics bytes.Buffer
+	bot.WriteICS(&ics, []Event{event}, nil)
+	for _, expected := range []string{
+		"DURATION:PT2H30M\r\n",
+		"URL:https://example.com/meetup\r\n",
+		"CATEGORIES:meetup\\, monthly,python,beginners\r\n",
+	} {
+		if !strings.Contains(ics.String(), expected) {
+			t.Fatalf("Calendar misses %q:\n%s", expected, ics.String())
+		}
+	}
+
+	other := MkEventBot()
+	if _, err := other.ImportICS(&ics); err != nil {
+		t.Fatalf("Import failed: %s", err.Error())
+	}
+	var imported Event
+	other.Db.First(&imported)
+	if !imported.Endtime.Equal(event.Endtime) || imported.Url != event.Url ||
+		imported.Category != event.Category || imported.Tags != event.Tags {
+		t.Fatalf("Expected %#v, got %#v", event, imported)
+	}
+	// occurrences keep the duration
+	occurrences := imported.Occurrences(start.AddDate(0, 0, 6),
+		start.AddDate(0, 0, 8), bot.TimeLocation, nil)
+	if len(occurrences) != 1 || occurrences[0].Duration() != 150*time.Minute {
+		t.Fatalf("Unexpected occurrences %#v", occurrences)
+	}
+}
+
+func TestParseICalDuration(t *testing.T) {
+	for value, expected := range map[string]time.Duration{
+		"PT2H30M": 150 * time.Minute,
+		"P1W":     7 * 24 * time.Hour,
+		"P1DT1S":  24*time.Hour + time.Second,
+		"-PT15M":  -15 * time.Minute,
+	} {
+		if d, err := parseICalDuration(value); err != nil || d != expected {
+			t.Fatalf("%s: expected %s, got %s (%v)", value, expected, d, err)
+		}
+	}
+	for _, invalid := range []string{"P", "PT", "2H", "PT2", "PTXH"} {
+		if _, err := parseICalDuration(invalid); err == nil {
+			t.Fatalf("%s should be invalid", invalid)
+		}
+	}
+}
@@ -0,0 +1,342 @@
+package her0ldbot
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	EXPORT_FORMAT_JSON = "json"
+	EXPORT_FORMAT_CSV  = "csv"
+	EXPORT_FORMAT_ICS  = "ics"
+	// Local times accepted in CSV files besides RFC 3339, e.g. from
+	// spreadsheets.
+	EXPORT_LOCAL_TIME_FORMAT = "2006-01-02 15:04"
+)
+
+// The columns of CSV exports. Imports need the start and description
+// columns, the others are optional.
+var csvColumns = []string{"uid", "start", "end", "description", "location", "url",
+	"category", "tags", "rrule", "exceptions", "attendees"}
+
+/* An exportedEvent is an event with its exceptions and attendees as
+ * written by ExportEvents. */
+type exportedEvent struct {
+	Uid         string             `json:"uid"`
+	Start       time.Time          `json:"start"`
+	End         *time.Time         `json:"end,omitempty"`
+	Description string             `json:"description"`
+	Location    string             `json:"location,omitempty"`
+	Url         string             `json:"url,omitempty"`
+	Category    string             `json:"category,omitempty"`
+	Tags        []string           `json:"tags,omitempty"`
+	Rrule       string             `json:"rrule,omitempty"`
+	Exceptions  []time.Time        `json:"exceptions,omitempty"`
+	Attendees   []exportedAttendee `json:"attendees,omitempty"`
+}
+
+type exportedAttendee struct {
+	Nick    string `json:"nick"`
+	Account string `json:"account,omitempty"`
+}
+
+/* The JSON document of an export. */
+type eventExport struct {
+	Exported time.Time       `json:"exported"`
+	Timezone string          `json:"timezone"`
+	Events   []exportedEvent `json:"events"`
+}
+
+// ExportFormat returns the format of a file by its extension, an empty
+// string if it is unknown.
+func ExportFormat(filename string) string {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".json":
+		return EXPORT_FORMAT_JSON
+	case ".csv":
+		return EXPORT_FORMAT_CSV
+	case ".ics", ".ical", ".ifb", ".icalendar":
+		return EXPORT_FORMAT_ICS
+	}
+	return ""
+}
+
+// allEvents returns all stored events, past ones included, ordered by
+// their start.
+func (b *EventBot) allEvents() []Event {
+	var events []Event
+	b.Db.Order("starttime, id").Find(&events)
+	return events
+}
+
+func (b *EventBot) exportedEvents() []exportedEvent {
+	events := b.allEvents()
+	exceptions := b.exceptions(events)
+	attendees := b.attendees(events)
+	var retval []exportedEvent
+	for _, event := range events {
+		e := exportedEvent{
+			Uid:         event.UID(),
+			Start:       event.Starttime.In(b.TimeLocation),
+			Description: event.Description,
+			Location:    event.Location,
+			Url:         event.Url,
+			Category:    event.Category,
+			Tags:        event.TagList(),
+			Rrule:       event.Rrule,
+		}
+		if !event.Endtime.IsZero() {
+			end := event.Endtime.In(b.TimeLocation)
+			e.End = &end
+		}
+		for _, exception := range exceptions[event.Id] {
+			e.Exceptions = append(e.Exceptions, exception.In(b.TimeLocation))
+		}
+		for _, a := range attendees[event.Id] {
+			e.Attendees = append(e.Attendees, exportedAttendee{Nick: a.Nick,
+				Account: a.Account})
+		}
+		retval = append(retval, e)
+	}
+	return retval
+}
+
+// ExportEvents writes all events in the given format. JSON exports are
+// complete, CSV exports lose the accounts of the attendees and
+// iCalendar exports lose the attendees on import.
+func (b *EventBot) ExportEvents(out io.Writer, format string) error {
+	switch format {
+	case EXPORT_FORMAT_JSON:
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(eventExport{
+			Exported: time.Now().In(b.TimeLocation),
+			Timezone: b.TimeLocation.String(),
+			Events:   b.exportedEvents(),
+		})
+	case EXPORT_FORMAT_CSV:
+		w := csv.NewWriter(out)
+		w.Write(csvColumns)
+		for _, e := range b.exportedEvents() {
+			end := ""
+			if e.End != nil {
+				end = e.End.Format(time.RFC3339)
+			}
+			var exceptions, attendees []string
+			for _, exception := range e.Exceptions {
+				exceptions = append(exceptions, exception.Format(time.RFC3339))
+			}
+			for _, a := range e.Attendees {
+				attendees = append(attendees, a.Nick)
+			}
+			w.Write([]string{e.Uid, e.Start.Format(time.RFC3339), end, e.Description,
+				e.Location, e.Url, e.Category, strings.Join(e.Tags, ","), e.Rrule,
+				strings.Join(exceptions, " "), strings.Join(attendees, " ")})
+		}
+		w.Flush()
+		return w.Error()
+	case EXPORT_FORMAT_ICS:
+		events := b.allEvents()
+		return b.WriteICS(out, events, b.exceptions(events))
+	}
+	return fmt.Errorf("unknown format %q, use %s, %s or %s", format,
+		EXPORT_FORMAT_JSON, EXPORT_FORMAT_CSV, EXPORT_FORMAT_ICS)
+}
+
+// parseExportTime parses a time in RFC 3339 or EXPORT_LOCAL_TIME_FORMAT.
+func (b *EventBot) parseExportTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation(EXPORT_LOCAL_TIME_FORMAT, value, b.TimeLocation)
+	if err != nil {
+		return t, fmt.Errorf("invalid time %q, use e.g. 2016-05-02T19:00:00+02:00 or %s",
+			value, EXPORT_LOCAL_TIME_FORMAT)
+	}
+	return t, nil
+}
+
+// readCSV reads the events of a CSV file with a header line.
+func (b *EventBot) readCSV(r io.Reader, result *ImportResult) ([]exportedEvent, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the header - %s", err.Error())
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"start", "description"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("no %s column, use the columns %s", required,
+				strings.Join(csvColumns, ","))
+		}
+	}
+	var retval []exportedEvent
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return retval, nil
+		} else if err != nil {
+			return nil, err
+		}
+		get := func(column string) string {
+			if i, ok := columns[column]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		e := exportedEvent{
+			Uid:         get("uid"),
+			Description: get("description"),
+			Location:    get("location"),
+			Url:         get("url"),
+			Category:    get("category"),
+			Rrule:       get("rrule"),
+		}
+		if tags := normalizeTags(get("tags")); tags != "" {
+			e.Tags = strings.Split(tags, ",")
+		}
+		for _, nick := range strings.Fields(get("attendees")) {
+			e.Attendees = append(e.Attendees, exportedAttendee{Nick: nick})
+		}
+		var times []time.Time
+		for _, value := range append([]string{get("start"), get("end")},
+			strings.Fields(get("exceptions"))...) {
+			var t time.Time
+			if value != "" {
+				if t, err = b.parseExportTime(value); err != nil {
+					break
+				}
+			}
+			times = append(times, t)
+		}
+		if err != nil {
+			result.Skipped++
+			result.warn("line %d: %s", line, err.Error())
+			continue
+		}
+		e.Start = times[0]
+		if !times[1].IsZero() {
+			e.End = &times[1]
+		}
+		e.Exceptions = times[2:]
+		retval = append(retval, e)
+	}
+}
+
+// ImportEvents reads events in the given format. Events are identified
+// by their UID like in ImportICS, importing an export again updates
+// the events.
+func (b *EventBot) ImportEvents(r io.Reader, format string) (ImportResult, error) {
+	var result ImportResult
+	var events []exportedEvent
+	switch format {
+	case EXPORT_FORMAT_ICS:
+		return b.ImportICS(r)
+	case EXPORT_FORMAT_JSON:
+		var doc eventExport
+		if err := json.NewDecoder(r).Decode(&doc); err != nil {
+			return result, err
+		}
+		events = doc.Events
+	case EXPORT_FORMAT_CSV:
+		var err error
+		if events, err = b.readCSV(r, &result); err != nil {
+			return result, err
+		}
+	default:
+		return result, fmt.Errorf("unknown format %q, use %s, %s or %s", format,
+			EXPORT_FORMAT_JSON, EXPORT_FORMAT_CSV, EXPORT_FORMAT_ICS)
+	}
+	for _, e := range events {
+		if err := b.importExported(e, &result); err != nil {
+			result.Skipped++
+			result.warn("%s", err.Error())
+		}
+	}
+	for _, warning := range result.Warnings {
+		log.Printf("Eventbot: Import: %s", warning)
+	}
+	return result, nil
+}
+
+// importExported stores an event, replacing the event with the same UID.
+// Exceptions are replaced, attendees are added.
+func (b *EventBot) importExported(e exportedEvent, result *ImportResult) error {
+	name := e.Uid
+	if name == "" {
+		name = e.Description
+	}
+	if e.Start.IsZero() {
+		return fmt.Errorf("%s: event without start", name)
+	}
+	description := strings.Join(strings.Fields(e.Description), " ")
+	if description == "" {
+		return fmt.Errorf("%s: event without description", name)
+	}
+	rrule := ""
+	if e.Rrule != "" {
+		rec, err := ParseRRule(e.Rrule)
+		if err != nil {
+			return fmt.Errorf("%s (%s): %s", name, description, err.Error())
+		}
+		rrule = rec.String()
+	}
+	var existing Event
+	found := false
+	if e.Uid != "" {
+		existing, found = b.findByUID(e.Uid)
+	}
+	event := existing
+	event.Uid = e.Uid
+	event.Starttime = e.Start
+	event.Endtime = time.Time{}
+	if e.End != nil && e.End.After(e.Start) {
+		event.Endtime = *e.End
+	}
+	event.Description = description
+	event.Location = strings.Join(strings.Fields(e.Location), " ")
+	event.Url = e.Url
+	event.Category = e.Category
+	event.Tags = normalizeTags(strings.Join(e.Tags, ","))
+	event.Rrule = rrule
+	if found {
+		b.Db.Save(&event)
+		b.Db.Where("event_id = ?", event.Id).Delete(EventException{})
+		result.Updated++
+	} else {
+		b.Db.Create(&event)
+		result.Created++
+	}
+	for _, exception := range e.Exceptions {
+		b.Db.Create(&EventException{EventId: event.Id, Starttime: exception})
+	}
+	known := b.attendees([]Event{event})[event.Id]
+	for _, a := range e.Attendees {
+		if a.Nick == "" {
+			continue
+		}
+		msg := InboundMessage{Nick: a.Nick, Account: a.Account}
+		duplicate := false
+		for _, k := range known {
+			if k.Matches(msg) {
+				duplicate = true
+			}
+		}
+		if !duplicate {
+			attendee := Attendee{EventId: event.Id, Nick: a.Nick, Account: a.Account}
+			b.Db.Create(&attendee)
+			known = append(known, attendee)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package her0ldbot
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mkExportBot returns a bot with a recurring event with an exception
+// and an attendee and a single event.
+func mkExportBot(t *testing.T) *EventBot {
+	bot := MkEventBot()
+	eventCommand(t, bot, "add 05.05.2030-19:00 Treffen location=Hackerspace end=22:00 cat=meetup tags=meeting,weekly")
+	eventCommand(t, bot, "repeat 1 weekly")
+	eventCommand(t, bot, "join 1")
+	eventCommand(t, bot, "add 10.05.2030-18:00 Grillen url=https://example.com/grill")
+	bot.Db.Create(&EventException{EventId: 1,
+		Starttime: time.Date(2030, 5, 12, 19, 0, 0, 0, bot.TimeLocation)})
+	return bot
+}
+
+func TestExportFormat(t *testing.T) {
+	for name, expected := range map[string]string{
+		"events.json": EXPORT_FORMAT_JSON,
+		"events.CSV":  EXPORT_FORMAT_CSV,
+		"events.ics":  EXPORT_FORMAT_ICS,
+		"events.txt":  "",
+	} {
+		if format := ExportFormat(name); format != expected {
+			t.Fatalf("%s: expected %q, got %q", name, expected, format)
+		}
+	}
+}
+
+func TestExportImportRoundtrip(t *testing.T) {
+	for _, format := range []string{EXPORT_FORMAT_JSON, EXPORT_FORMAT_CSV, EXPORT_FORMAT_ICS} {
+		source := mkExportBot(t)
+		var buf bytes.Buffer
+		if err := source.ExportEvents(&buf, format); err != nil {
+			t.Fatalf("%s: export failed: %s", format, err.Error())
+		}
+		export := buf.String()
+		source.Db.Close()
+
+		target := MkEventBot()
+		result, err := target.ImportEvents(strings.NewReader(export), format)
+		if err != nil || result.Created != 2 || result.Skipped != 0 {
+			t.Fatalf("%s: unexpected import %v (%v)", format, result, err)
+		}
+		var events []Event
+		target.Db.Order("starttime").Find(&events)
+		if len(events) != 2 || events[0].Description != "Treffen" ||
+			events[0].Location != "Hackerspace" || events[0].Category != "meetup" ||
+			events[0].Tags != "meeting,weekly" ||
+			events[0].Rrule != "FREQ=WEEKLY" || events[0].Duration() != 3*time.Hour ||
+			events[1].Url != "https://example.com/grill" {
+			t.Fatalf("%s: unexpected events %v", format, events)
+		}
+		occurrences := target.eventsBetween(events[0].Starttime,
+			events[0].Starttime.AddDate(0, 0, 14))
+		if len(occurrences) != 2 || occurrences[1].Description != "Grillen" {
+			t.Fatalf("%s: exception lost, got %v", format, occurrences)
+		}
+		var attendees []Attendee
+		target.Db.Find(&attendees)
+		if format != EXPORT_FORMAT_ICS && (len(attendees) != 1 || attendees[0].Nick != "Nick") {
+			t.Fatalf("%s: unexpected attendees %v", format, attendees)
+		}
+
+		// importing again updates the events
+		result, err = target.ImportEvents(strings.NewReader(export), format)
+		if err != nil || result.Created != 0 || result.Updated != 2 {
+			t.Fatalf("%s: unexpected second import %v (%v)", format, result, err)
+		}
+		var count int
+		target.Db.Model(&Attendee{}).Count(&count)
+		if format != EXPORT_FORMAT_ICS && count != 1 {
+			t.Fatalf("%s: attendees duplicated, got %d", format, count)
+		}
+		target.Db.Close()
+	}
+}
+
+func TestImportCSV(t *testing.T) {
+	bot := MkEventBot()
+	defer bot.Db.Close()
+	csv := "Description,Start,Location\n" +
+		"Treffen,2030-05-05 19:00,Hackerspace\n" +
+		"Kaputt,gestern,\n" +
+		",2030-05-06 19:00,\n"
+	result, err := bot.ImportEvents(strings.NewReader(csv), EXPORT_FORMAT_CSV)
+	if err != nil || result.Created != 1 || result.Skipped != 2 || len(result.Warnings) != 2 {
+		t.Fatalf("Unexpected import %v (%v)", result, err)
+	}
+	var event Event
+	bot.Db.First(&event)
+	if !event.Starttime.Equal(time.Date(2030, 5, 5, 19, 0, 0, 0, bot.TimeLocation)) ||
+		event.Location != "Hackerspace" {
+		t.Fatalf("Unexpected event %v", event)
+	}
+
+	if _, err := bot.ImportEvents(strings.NewReader("uid,location\n"), EXPORT_FORMAT_CSV); err == nil {
+		t.Fatalf("CSV without start column should fail")
+	}
+	if _, err := bot.ImportEvents(strings.NewReader(""), "xml"); err == nil {
+		t.Fatalf("Unknown format should fail")
+	}
+}
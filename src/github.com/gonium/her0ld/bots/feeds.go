@@ -0,0 +1,227 @@
+package her0ldbot
+
+import (
+	"bytes"
+	"encoding/xml"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ATOM_CONTENT_TYPE = "application/atom+xml; charset=utf-8"
+	RSS_CONTENT_TYPE  = "application/rss+xml; charset=utf-8"
+	ATOM_NAMESPACE    = "http://www.w3.org/2005/Atom"
+	// The newest events are listed in the feeds.
+	FEED_MAX_ITEMS = 50
+)
+
+/* An Atom feed, see RFC 4287. */
+type atomFeed struct {
+	XMLName xml.Name    `xml:"feed"`
+	Xmlns   string      `xml:"xmlns,attr"`
+	Id      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Author  atomAuthor  `xml:"author"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	Id        string     `xml:"id"`
+	Title     string     `xml:"title"`
+	Updated   string     `xml:"updated"`
+	Published string     `xml:"published"`
+	Links     []atomLink `xml:"link"`
+	Summary   string     `xml:"summary"`
+	Category  []atomCat  `xml:"category"`
+}
+
+type atomCat struct {
+	Term string `xml:"term,attr"`
+}
+
+/* An RSS 2.0 feed. */
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	Guid        rssGuid  `xml:"guid"`
+	PubDate     string   `xml:"pubDate"`
+	Description string   `xml:"description"`
+	Categories  []string `xml:"category"`
+}
+
+type rssGuid struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+/* An event of a feed with its absolute link. */
+type feedItem struct {
+	Event   Event
+	Link    string
+	Summary string
+	// Events created before the timestamps were recorded use their
+	// start time.
+	Created time.Time
+	Updated time.Time
+}
+
+// baseURL returns the address of the server as seen by the client.
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// feedItems returns the upcoming events, the most recently added
+// first, and the time of the latest change.
+func (b *EventBot) feedItems(base string) ([]feedItem, time.Time) {
+	today, _ := b.dayBounds(time.Now())
+	events, _ := b.calendarEvents(today)
+	var items []feedItem
+	var updated time.Time
+	for _, event := range events {
+		event.Starttime = event.Starttime.In(b.TimeLocation)
+		item := feedItem{
+			Event:   event,
+			Link:    base + "/events/" + strconv.Itoa(event.Id),
+			Created: event.CreatedAt,
+			Updated: event.UpdatedAt,
+		}
+		if item.Created.IsZero() {
+			item.Created = event.Starttime
+		}
+		if item.Updated.Before(item.Created) {
+			item.Updated = item.Created
+		}
+		if item.Updated.After(updated) {
+			updated = item.Updated
+		}
+		// the summary is the event line of the channel without the id
+		item.Summary = strings.TrimPrefix(event.String(),
+			"("+strconv.Itoa(event.Id)+") ")
+		if rec, err := event.Recurrence(); err == nil && rec != nil {
+			item.Summary += " (" + rec.Describe(b.TimeLocation) + ")"
+		}
+		items = append(items, item)
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].Created.After(items[j].Created)
+	})
+	if len(items) > FEED_MAX_ITEMS {
+		items = items[:FEED_MAX_ITEMS]
+	}
+	if updated.IsZero() {
+		updated = time.Now()
+	}
+	return items, updated
+}
+
+// writeXML answers with the document and an XML declaration.
+func writeXML(w http.ResponseWriter, contentType string, doc interface{}) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(&buf)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	buf.WriteTo(w)
+}
+
+// serveAtom serves the upcoming events as Atom feed.
+func (b *EventBot) serveAtom(w http.ResponseWriter, r *http.Request) {
+	base := baseURL(r)
+	items, updated := b.feedItems(base)
+	feed := atomFeed{
+		Xmlns:   ATOM_NAMESPACE,
+		Id:      base + "/events.atom",
+		Title:   b.BotName + " events",
+		Updated: updated.UTC().Format(time.RFC3339),
+		Links: []atomLink{
+			{Rel: "self", Type: "application/atom+xml", Href: base + "/events.atom"},
+			{Rel: "alternate", Type: "text/html", Href: base + "/"},
+		},
+		Author: atomAuthor{Name: b.BotName},
+	}
+	for _, item := range items {
+		entry := atomEntry{
+			Id:        item.Link,
+			Title:     item.Event.Description,
+			Updated:   item.Updated.UTC().Format(time.RFC3339),
+			Published: item.Created.UTC().Format(time.RFC3339),
+			Links:     []atomLink{{Rel: "alternate", Type: "text/html", Href: item.Link}},
+			Summary:   item.Summary,
+		}
+		if item.Event.Category != "" {
+			entry.Category = append(entry.Category, atomCat{Term: item.Event.Category})
+		}
+		for _, tag := range item.Event.TagList() {
+			entry.Category = append(entry.Category, atomCat{Term: tag})
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+	writeXML(w, ATOM_CONTENT_TYPE, feed)
+}
+
+// serveRSS serves the upcoming events as RSS 2.0 feed.
+func (b *EventBot) serveRSS(w http.ResponseWriter, r *http.Request) {
+	base := baseURL(r)
+	items, updated := b.feedItems(base)
+	feed := rssFeed{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:         b.BotName + " events",
+			Link:          base + "/",
+			Description:   "Upcoming events",
+			LastBuildDate: updated.Format(time.RFC1123Z),
+		},
+	}
+	for _, item := range items {
+		rss := rssItem{
+			Title:       item.Event.Description,
+			Link:        item.Link,
+			Guid:        rssGuid{IsPermaLink: true, Value: item.Link},
+			PubDate:     item.Created.Format(time.RFC1123Z),
+			Description: item.Summary,
+		}
+		if item.Event.Category != "" {
+			rss.Categories = append(rss.Categories, item.Event.Category)
+		}
+		rss.Categories = append(rss.Categories, item.Event.TagList()...)
+		feed.Channel.Items = append(feed.Channel.Items, rss)
+	}
+	writeXML(w, RSS_CONTENT_TYPE, feed)
+}
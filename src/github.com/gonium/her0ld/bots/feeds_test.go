@@ -0,0 +1,58 @@
+package her0ldbot
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFeeds(t *testing.T) {
+	bot := mkAPIBot()
+	start := time.Now().In(bot.TimeLocation).AddDate(0, 0, 1).Truncate(time.Hour)
+	bot.Db.Create(&Event{Starttime: start.Add(time.Hour), Description: "Talk & beer",
+		Category: "talk"})
+	bot.Db.Create(&Event{Starttime: start, Description: "Meetup"})
+	// past events are not part of the feeds
+	bot.Db.Create(&Event{Starttime: start.AddDate(0, 0, -3), Description: "Over"})
+	// the newest event comes first
+	bot.Db.Model(&Event{Id: 1}).UpdateColumn("created_at", time.Now().Add(-time.Hour))
+
+	recorder := webRequest(bot, "GET", "/events.atom", nil, false)
+	if recorder.Code != 200 || recorder.Header().Get("Content-Type") != ATOM_CONTENT_TYPE {
+		t.Fatalf("Atom feed failed: %d %s", recorder.Code, recorder.Header().Get("Content-Type"))
+	}
+	var atom atomFeed
+	if err := xml.Unmarshal(recorder.Body.Bytes(), &atom); err != nil {
+		t.Fatalf("Invalid Atom feed: %s", err.Error())
+	}
+	if len(atom.Entries) != 2 || atom.Entries[0].Title != "Meetup" ||
+		atom.Entries[1].Title != "Talk & beer" {
+		t.Fatalf("Unexpected Atom entries: %v", atom.Entries)
+	}
+	if atom.Entries[0].Id != "http://example.com/events/2" ||
+		len(atom.Entries[1].Category) != 1 || atom.Entries[1].Category[0].Term != "talk" {
+		t.Fatalf("Unexpected Atom entry: %v", atom.Entries)
+	}
+	if _, err := time.Parse(time.RFC3339, atom.Entries[0].Published); err != nil {
+		t.Fatalf("Invalid publication date: %s", atom.Entries[0].Published)
+	}
+
+	recorder = webRequest(bot, "GET", "/events.rss", nil, false)
+	if recorder.Code != 200 || recorder.Header().Get("Content-Type") != RSS_CONTENT_TYPE {
+		t.Fatalf("RSS feed failed: %d %s", recorder.Code, recorder.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(recorder.Body.String(), "Talk &amp; beer") {
+		t.Fatalf("Description not escaped: %s", recorder.Body.String())
+	}
+	var rss rssFeed
+	if err := xml.Unmarshal(recorder.Body.Bytes(), &rss); err != nil {
+		t.Fatalf("Invalid RSS feed: %s", err.Error())
+	}
+	if len(rss.Channel.Items) != 2 || rss.Channel.Items[0].Guid.Value != "http://example.com/events/2" {
+		t.Fatalf("Unexpected RSS items: %v", rss.Channel.Items)
+	}
+	if _, err := time.Parse(time.RFC1123Z, rss.Channel.Items[1].PubDate); err != nil {
+		t.Fatalf("Invalid publication date: %s", rss.Channel.Items[1].PubDate)
+	}
+}
@@ -2,57 +2,58 @@ package her0ldbot
 
 import (
 	"fmt"
-	"strings"
 )
 
 type HelpBot struct {
 	BotName            string
 	NumMessagesHandled int
+	Commands           *CommandRouter
 	bots               []Bot
 }
 
 func NewHelpBot(name string, bots []Bot) *HelpBot {
-	return &HelpBot{
+	b := &HelpBot{
 		BotName:            name,
 		NumMessagesHandled: 0,
+		Commands:           NewCommandRouter(),
 		bots:               bots,
 	}
+	b.Commands.Register(&Command{
+		Name:    "help",
+		Help:    "prints the help texts of all available bots.",
+		Handler: b.help,
+	})
+	return b
 }
 
-func (b *HelpBot) ProcessChannelEvent(msg InboundMessage) ([]OutboundMessage, error) {
-	if strings.ToLower(msg.Message) == "!help" {
-		b.NumMessagesHandled += 1
-		var answer []string
-		for _, b := range b.bots {
-			answer = append(answer, fmt.Sprintf("%s commands:", b.GetName()))
-			lines := b.GetHelpLines()
-			for _, l := range lines {
-				answer = append(answer, l)
-			}
-		}
-		answer = append(answer, "Find my code at https://github.com/gonium/her0ld")
-		reply := make([]OutboundMessage, len(answer))
-		for idx, line := range answer {
-			reply[idx] = OutboundMessage{
-				Destination: msg.Channel,
-				Message:     line,
-				//Message:     answer[idx],
-			}
+// help lists the registered commands of all bots.
+func (b *HelpBot) help(msg InboundMessage, args []string) ([]string, error) {
+	b.NumMessagesHandled += 1
+	var answer []string
+	for _, bot := range b.bots {
+		lines := bot.GetCommands().HelpLines(nil, msg.BotNick)
+		if len(lines) == 0 {
+			continue
 		}
-		return reply, nil
-	} else {
-		return nil, nil
+		answer = append(answer, fmt.Sprintf("%s commands:", bot.GetName()))
+		answer = append(answer, lines...)
 	}
+	answer = append(answer, "Find my code at https://github.com/gonium/her0ld")
+	return answer, nil
+}
+
+func (b *HelpBot) ProcessChannelEvent(msg InboundMessage) ([]OutboundMessage, error) {
+	return b.Commands.Dispatch(msg)
 }
 
 func (b *HelpBot) ProcessQueryEvent(msg InboundMessage) ([]OutboundMessage, error) {
-	return nil, fmt.Errorf("HelpBot does not implement query event handling")
+	return b.ProcessChannelEvent(msg)
 }
 
 func (b *HelpBot) GetName() string {
 	return b.BotName
 }
 
-func (b *HelpBot) GetHelpLines() []string {
-	return []string{"!help prints the help texts of all available bots."}
+func (b *HelpBot) GetCommands() *CommandRouter {
+	return b.Commands
 }
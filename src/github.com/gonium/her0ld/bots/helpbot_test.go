@@ -0,0 +1,37 @@
+package her0ldbot
+
+import (
+	"testing"
+)
+
+func TestHelpListsCommands(t *testing.T) {
+	pingbot := NewPingBot("Pingbot")
+	bot := NewHelpBot("Helpbot", []Bot{NewEchoBot("Echobot"), pingbot})
+	msg := InboundMessage{
+		Target:  "#test",
+		Nick:    "testnick",
+		Message: "!help",
+	}
+	answer, err := bot.ProcessChannelEvent(msg)
+	if err != nil {
+		t.Fatalf("Help command triggered unexpected error: %s", err.Error())
+	}
+	// the echobot has no commands and is skipped.
+	expected := []string{"Pingbot commands:"}
+	expected = append(expected, pingbot.Commands.HelpLines(nil, "")...)
+	if len(answer) != len(expected)+1 {
+		t.Fatalf("Unexpected help output %#v", answer)
+	}
+	for idx, line := range expected {
+		if answer[idx].Message != line {
+			t.Fatalf("Expected >%s<, got >%s<", line, answer[idx].Message)
+		}
+		if answer[idx].Destination != msg.Target {
+			t.Fatalf("Invalid destination %s", answer[idx].Destination)
+		}
+	}
+	if bot.NumMessagesHandled != 1 {
+		t.Fatalf("The bot handled %d messages - expected 1.",
+			bot.NumMessagesHandled)
+	}
+}
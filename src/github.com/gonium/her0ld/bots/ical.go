@@ -0,0 +1,360 @@
+package her0ldbot
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	ICAL_PRODID = "-//gonium//her0ld//EN"
+	// Local time with a TZID parameter
+	ICAL_LOCAL_TIME_FORMAT = "20060102T150405"
+	ICAL_UTC_TIME_FORMAT   = "20060102T150405Z"
+	// Content lines are folded after 75 octets, see RFC 5545, 3.1.
+	ICAL_LINE_LENGTH = 75
+	// Events without an end are shown with this duration.
+	EVENTBOT_DEFAULT_DURATION = 2 * time.Hour
+	ICAL_CONTENT_TYPE         = "text/calendar; charset=utf-8"
+)
+
+/* An icalWriter writes the content lines of an iCalendar object with
+ * CRLF line endings and line folding. */
+type icalWriter struct {
+	buf bytes.Buffer
+}
+
+// line writes a content line. The value must already be escaped.
+func (w *icalWriter) line(name, value string) {
+	content := name + ":" + value
+	length := 0
+	for _, r := range content {
+		size := utf8.RuneLen(r)
+		// never split a multibyte character
+		if length+size > ICAL_LINE_LENGTH {
+			w.buf.WriteString("\r\n ")
+			// the leading space counts as well
+			length = 1
+		}
+		w.buf.WriteRune(r)
+		length += size
+	}
+	w.buf.WriteString("\r\n")
+}
+
+func (w *icalWriter) WriteTo(out io.Writer) (int64, error) {
+	return w.buf.WriteTo(out)
+}
+
+// icalEscape escapes a TEXT value, see RFC 5545, 3.3.11.
+func icalEscape(s string) string {
+	return strings.NewReplacer(
+		"\\", "\\\\",
+		";", "\\;",
+		",", "\\,",
+		"\r\n", "\\n",
+		"\n", "\\n",
+	).Replace(s)
+}
+
+// icalDuration formats a duration as e.g. PT2H30M, see RFC 5545, 3.3.6.
+func icalDuration(d time.Duration) string {
+	if d <= 0 {
+		return "PT0S"
+	}
+	retval := "P"
+	days := d / (24 * time.Hour)
+	d -= days * 24 * time.Hour
+	if days > 0 {
+		retval += fmt.Sprintf("%dD", days)
+	}
+	if d == 0 {
+		return retval
+	}
+	retval += "T"
+	if h := d / time.Hour; h > 0 {
+		retval += fmt.Sprintf("%dH", h)
+		d -= h * time.Hour
+	}
+	if m := d / time.Minute; m > 0 {
+		retval += fmt.Sprintf("%dM", m)
+		d -= m * time.Minute
+	}
+	if s := d / time.Second; s > 0 {
+		retval += fmt.Sprintf("%dS", s)
+	}
+	return retval
+}
+
+// icalParam quotes a parameter value if necessary, see RFC 5545, 3.2.
+func icalParam(s string) string {
+	s = strings.Replace(s, "\"", "'", -1)
+	if strings.ContainsAny(s, ":;,") {
+		return "\"" + s + "\""
+	}
+	return s
+}
+
+func icalOffset(seconds int) string {
+	sign := "+"
+	if seconds < 0 {
+		sign = "-"
+		seconds = -seconds
+	}
+	return fmt.Sprintf("%s%02d%02d", sign, seconds/3600, seconds%3600/60)
+}
+
+/* A zoneTransition is a change of the UTC offset of a time zone, e.g.
+ * the start of daylight saving time. */
+type zoneTransition struct {
+	// the first instant with the new offset
+	At         time.Time
+	OffsetFrom int
+	OffsetTo   int
+	Name       string
+	IsDST      bool
+}
+
+// localStart returns the wall clock time of the transition in the old
+// offset, as used by DTSTART of a VTIMEZONE observance.
+func (t zoneTransition) localStart() time.Time {
+	return t.At.UTC().Add(time.Duration(t.OffsetFrom) * time.Second)
+}
+
+// yearlyRule returns the rule of a transition that takes place on the
+// n-th or last weekday of a month, e.g. FREQ=YEARLY;BYMONTH=3;BYDAY=-1SU.
+func (t zoneTransition) yearlyRule() string {
+	start := t.localStart()
+	wd := WeekdayNum{N: (start.Day()-1)/7 + 1, Day: start.Weekday()}
+	if start.Day()+7 > daysIn(start) {
+		wd.N = -1
+	}
+	return fmt.Sprintf("FREQ=YEARLY;BYMONTH=%d;BYDAY=%s", int(start.Month()), wd)
+}
+
+// zoneTransitions returns the offset changes of loc in the given year.
+func zoneTransitions(loc *time.Location, year int) []zoneTransition {
+	var retval []zoneTransition
+	t := time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(year+1, 1, 1, 0, 0, 0, 0, time.UTC)
+	_, offset := t.In(loc).Zone()
+	for t.Before(end) {
+		next := t.Add(time.Hour)
+		if _, nextOffset := next.In(loc).Zone(); nextOffset != offset {
+			// find the exact second of the change
+			lo, hi := t, next
+			for hi.Sub(lo) > time.Second {
+				mid := lo.Add(hi.Sub(lo) / 2)
+				if _, o := mid.In(loc).Zone(); o == offset {
+					lo = mid
+				} else {
+					hi = mid
+				}
+			}
+			name, _ := hi.In(loc).Zone()
+			retval = append(retval, zoneTransition{
+				At:         hi,
+				OffsetFrom: offset,
+				OffsetTo:   nextOffset,
+				Name:       name,
+				IsDST:      hi.In(loc).IsDST(),
+			})
+			offset = nextOffset
+		}
+		t = next
+	}
+	return retval
+}
+
+// writeVTimezone writes the VTIMEZONE component of loc. The observances
+// start in the year of from. Regular transitions like "last Sunday in
+// March" are written as yearly rules, irregular ones are listed for
+// every year up to the year of to.
+func writeVTimezone(w *icalWriter, loc *time.Location, from, to time.Time) {
+	w.line("BEGIN", "VTIMEZONE")
+	w.line("TZID", loc.String())
+	observance := func(t zoneTransition, rule string) {
+		kind := "STANDARD"
+		if t.IsDST {
+			kind = "DAYLIGHT"
+		}
+		w.line("BEGIN", kind)
+		w.line("DTSTART", t.localStart().Format(ICAL_LOCAL_TIME_FORMAT))
+		w.line("TZOFFSETFROM", icalOffset(t.OffsetFrom))
+		w.line("TZOFFSETTO", icalOffset(t.OffsetTo))
+		if rule != "" {
+			w.line("RRULE", rule)
+		}
+		if t.Name != "" {
+			w.line("TZNAME", icalEscape(t.Name))
+		}
+		w.line("END", kind)
+	}
+	first := from.In(loc).Year()
+	last := to.In(loc).Year()
+	transitions := zoneTransitions(loc, first)
+	if len(transitions) == 0 {
+		// no changes in this year, the zone has a fixed offset
+		ref := time.Date(first, 1, 1, 0, 0, 0, 0, loc)
+		name, offset := ref.Zone()
+		observance(zoneTransition{
+			At:         ref,
+			OffsetFrom: offset,
+			OffsetTo:   offset,
+			Name:       name,
+			IsDST:      ref.IsDST(),
+		}, "")
+	} else if regularTransitions(loc, transitions, first+1) {
+		for _, t := range transitions {
+			observance(t, t.yearlyRule())
+		}
+	} else {
+		for year := first; year <= last; year++ {
+			for _, t := range zoneTransitions(loc, year) {
+				observance(t, "")
+			}
+		}
+	}
+	w.line("END", "VTIMEZONE")
+}
+
+// regularTransitions reports whether the transitions of year follow the
+// yearly rules of the given transitions.
+func regularTransitions(loc *time.Location, transitions []zoneTransition, year int) bool {
+	next := zoneTransitions(loc, year)
+	if len(next) != len(transitions) {
+		return false
+	}
+	for idx, t := range transitions {
+		n := next[idx]
+		if n.OffsetFrom != t.OffsetFrom || n.OffsetTo != t.OffsetTo ||
+			n.yearlyRule() != t.yearlyRule() {
+			return false
+		}
+		a, b := t.localStart(), n.localStart()
+		if a.Hour() != b.Hour() || a.Minute() != b.Minute() {
+			return false
+		}
+	}
+	return true
+}
+
+// UID returns the unique and stable identifier of the event in
+// calendars. Imported events keep the UID of their source.
+func (e *Event) UID() string {
+	if e.Uid != "" {
+		return e.Uid
+	}
+	return fmt.Sprintf("event-%d@her0ld", e.Id)
+}
+
+// WriteICS writes the given events as an iCalendar object. Recurring
+// events are written as one VEVENT with their recurrence rule and the
+// deleted occurrences.
+func (b *EventBot) WriteICS(out io.Writer, events []Event,
+	exceptions map[int][]time.Time) error {
+	loc := b.TimeLocation
+	now := time.Now()
+	from, to := now, now
+	for _, event := range events {
+		if event.Starttime.Before(from) {
+			from = event.Starttime
+		}
+		if event.Starttime.After(to) {
+			to = event.Starttime
+		}
+	}
+	localTime := func(t time.Time) string {
+		return t.In(loc).Format(ICAL_LOCAL_TIME_FORMAT)
+	}
+
+	w := &icalWriter{}
+	w.line("BEGIN", "VCALENDAR")
+	w.line("VERSION", "2.0")
+	w.line("PRODID", ICAL_PRODID)
+	w.line("CALSCALE", "GREGORIAN")
+	w.line("METHOD", "PUBLISH")
+	w.line("X-WR-CALNAME", icalEscape(b.BotName))
+	w.line("X-WR-TIMEZONE", loc.String())
+	writeVTimezone(w, loc, from, to)
+	attendees := b.attendees(events)
+	for _, event := range events {
+		w.line("BEGIN", "VEVENT")
+		w.line("UID", icalEscape(event.UID()))
+		w.line("DTSTAMP", now.UTC().Format(ICAL_UTC_TIME_FORMAT))
+		w.line("DTSTART;TZID="+loc.String(), localTime(event.Starttime))
+		w.line("DURATION", icalDuration(event.Duration()))
+		w.line("SUMMARY", icalEscape(event.Description))
+		if event.Location != "" {
+			w.line("LOCATION", icalEscape(event.Location))
+		}
+		if event.Url != "" {
+			w.line("URL", event.Url)
+		}
+		var categories []string
+		if event.Category != "" {
+			categories = append(categories, icalEscape(event.Category))
+		}
+		for _, tag := range event.TagList() {
+			categories = append(categories, icalEscape(tag))
+		}
+		if len(categories) > 0 {
+			w.line("CATEGORIES", strings.Join(categories, ","))
+		}
+		for _, a := range attendees[event.Id] {
+			w.line("ATTENDEE;CN="+icalParam(a.Nick)+";PARTSTAT=ACCEPTED",
+				"irc:"+url.PathEscape(a.Nick))
+		}
+		if event.Rrule != "" {
+			w.line("RRULE", event.Rrule)
+			var exdates []string
+			for _, exception := range exceptions[event.Id] {
+				exdates = append(exdates, localTime(exception))
+			}
+			if len(exdates) > 0 {
+				w.line("EXDATE;TZID="+loc.String(), strings.Join(exdates, ","))
+			}
+		}
+		w.line("END", "VEVENT")
+	}
+	w.line("END", "VCALENDAR")
+	_, err := w.WriteTo(out)
+	return err
+}
+
+// calendarEvents returns the single events starting at or after from
+// and all recurring events that have occurrences after from, together
+// with the exceptions of the recurring events.
+func (b *EventBot) calendarEvents(from time.Time) ([]Event, map[int][]time.Time) {
+	var single, recurring, retval []Event
+	b.Db.Where("starttime >= ? and (rrule is null or rrule = '')",
+		from).Order("starttime").Find(&single)
+	b.Db.Where("rrule <> ''").Order("starttime").Find(&recurring)
+	for _, event := range recurring {
+		rec, err := event.Recurrence()
+		if err != nil || rec.Ended(event.Starttime.In(b.TimeLocation), from) {
+			continue
+		}
+		retval = append(retval, event)
+	}
+	retval = append(retval, single...)
+	return retval, b.exceptions(retval)
+}
+
+// serveICS serves all upcoming events as iCalendar.
+func (b *EventBot) serveICS(w http.ResponseWriter, r *http.Request) {
+	today, _ := b.dayBounds(time.Now())
+	events, exceptions := b.calendarEvents(today)
+	var doc bytes.Buffer
+	if err := b.WriteICS(&doc, events, exceptions); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", ICAL_CONTENT_TYPE)
+	doc.WriteTo(w)
+}
@@ -0,0 +1,134 @@
+package her0ldbot
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIcalLineFolding(t *testing.T) {
+	w := &icalWriter{}
+	w.line("SUMMARY", strings.Repeat("ä", 50))
+	for _, line := range strings.Split(strings.TrimSuffix(w.buf.String(), "\r\n"), "\r\n") {
+		if len(line) > ICAL_LINE_LENGTH {
+			t.Fatalf("Line longer than %d octets: %q", ICAL_LINE_LENGTH, line)
+		}
+		if !strings.HasPrefix(line, "SUMMARY") && !strings.HasPrefix(line, " ") {
+			t.Fatalf("Continuation line does not start with a space: %q", line)
+		}
+	}
+	unfolded := strings.Replace(w.buf.String(), "\r\n ", "", -1)
+	if unfolded != "SUMMARY:"+strings.Repeat("ä", 50)+"\r\n" {
+		t.Fatalf("Folded line does not unfold to the original: %q", unfolded)
+	}
+}
+
+func TestIcalEscapeAndDuration(t *testing.T) {
+	if escaped := icalEscape("a,b;c\\d\ne"); escaped != "a\\,b\\;c\\\\d\\ne" {
+		t.Fatalf("Wrong escaping: %s", escaped)
+	}
+	durations := map[time.Duration]string{
+		2 * time.Hour:               "PT2H",
+		90 * time.Minute:            "PT1H30M",
+		26 * time.Hour:              "P1DT2H",
+		48 * time.Hour:              "P2D",
+		time.Minute + 5*time.Second: "PT1M5S",
+		0:                           "PT0S",
+	}
+	for d, expected := range durations {
+		if icalDuration(d) != expected {
+			t.Fatalf("Duration %s should be %s, got %s", d, expected,
+				icalDuration(d))
+		}
+	}
+}
+
+func TestVTimezone(t *testing.T) {
+	loc := mustLoadLocation("Europe/Berlin")
+	w := &icalWriter{}
+	from := time.Date(2016, 1, 1, 0, 0, 0, 0, loc)
+	writeVTimezone(w, loc, from, from)
+	tz := w.buf.String()
+	for _, expected := range []string{
+		"TZID:Europe/Berlin\r\n",
+		"BEGIN:DAYLIGHT\r\nDTSTART:20160327T020000\r\nTZOFFSETFROM:+0100\r\nTZOFFSETTO:+0200\r\nRRULE:FREQ=YEARLY;BYMONTH=3;BYDAY=-1SU\r\nTZNAME:CEST\r\nEND:DAYLIGHT\r\n",
+		"BEGIN:STANDARD\r\nDTSTART:20161030T030000\r\nTZOFFSETFROM:+0200\r\nTZOFFSETTO:+0100\r\nRRULE:FREQ=YEARLY;BYMONTH=10;BYDAY=-1SU\r\nTZNAME:CET\r\nEND:STANDARD\r\n",
+	} {
+		if !strings.Contains(tz, expected) {
+			t.Fatalf("VTIMEZONE misses %q:\n%s", expected, tz)
+		}
+	}
+	// zones without daylight saving time have a single observance
+	w = &icalWriter{}
+	writeVTimezone(w, mustLoadLocation("Asia/Tokyo"), from, from)
+	tz = w.buf.String()
+	if strings.Count(tz, "BEGIN:STANDARD") != 1 || strings.Contains(tz, "DAYLIGHT") ||
+		!strings.Contains(tz, "TZOFFSETTO:+0900") {
+		t.Fatalf("Invalid VTIMEZONE for a fixed offset:\n%s", tz)
+	}
+}
+
+func TestEventsICS(t *testing.T) {
+	bot := MkEventBot()
+	today, _ := bot.dayBounds(time.Now())
+	start := today.AddDate(0, 0, 1).Add(19 * time.Hour)
+	bot.Db.Create(&Event{Starttime: start, Description: "Meetup; with snacks"})
+	bot.Db.Create(&Event{Starttime: today.AddDate(0, 0, -3), Description: "Past event"})
+	series := Event{
+		Starttime:   today.AddDate(0, -2, 0).Add(20 * time.Hour),
+		Description: "Weekly",
+		Rrule:       "FREQ=WEEKLY",
+	}
+	bot.Db.Create(&series)
+	exception := series.Starttime.AddDate(0, 0, 7)
+	bot.Db.Create(&EventException{EventId: series.Id, Starttime: exception})
+	bot.Db.Create(&Event{
+		Starttime:   today.AddDate(0, -2, 0),
+		Description: "Finished series",
+		Rrule:       "FREQ=DAILY;COUNT=3",
+	})
+
+	recorder := httptest.NewRecorder()
+	bot.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/events.ics", nil))
+	if recorder.Code != 200 {
+		t.Fatalf("Unexpected status %d", recorder.Code)
+	}
+	if ct := recorder.Header().Get("Content-Type"); ct != ICAL_CONTENT_TYPE {
+		t.Fatalf("Unexpected content type %s", ct)
+	}
+	ics := recorder.Body.String()
+	for _, expected := range []string{
+		"BEGIN:VCALENDAR\r\nVERSION:2.0\r\n",
+		"BEGIN:VTIMEZONE\r\nTZID:Europe/Berlin\r\n",
+		"UID:event-1@her0ld\r\n",
+		"DTSTART;TZID=Europe/Berlin:" + start.Format(ICAL_LOCAL_TIME_FORMAT) + "\r\n",
+		"DURATION:PT2H\r\n",
+		"SUMMARY:Meetup\\; with snacks\r\n",
+		"UID:event-3@her0ld\r\n",
+		"RRULE:FREQ=WEEKLY\r\n",
+		"EXDATE;TZID=Europe/Berlin:" + exception.Format(ICAL_LOCAL_TIME_FORMAT) + "\r\n",
+		"END:VCALENDAR\r\n",
+	} {
+		if !strings.Contains(ics, expected) {
+			t.Fatalf("Calendar misses %q:\n%s", expected, ics)
+		}
+	}
+	for _, unexpected := range []string{"Past event", "Finished series"} {
+		if strings.Contains(ics, unexpected) {
+			t.Fatalf("Calendar contains %q:\n%s", unexpected, ics)
+		}
+	}
+	if strings.Count(ics, "BEGIN:VEVENT") != 2 {
+		t.Fatalf("Expected 2 events:\n%s", ics)
+	}
+
+	// the UID is stable
+	var again bytes.Buffer
+	events, exceptions := bot.calendarEvents(today)
+	bot.WriteICS(&again, events, exceptions)
+	if !strings.Contains(again.String(), "UID:event-1@her0ld\r\n") {
+		t.Fatalf("UID changed between requests")
+	}
+}
@@ -0,0 +1,561 @@
+package her0ldbot
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	// Remote calendars larger than this are rejected.
+	ICAL_MAX_SIZE      = 5 * 1024 * 1024
+	ICAL_FETCH_TIMEOUT = 30 * time.Second
+)
+
+// UIDs of the events exported by her0ld itself, see Event.UID.
+var ownUIDPattern = regexp.MustCompile(`^event-(\d+)@her0ld$`)
+
+/* A property of an iCalendar component, e.g.
+ * DTSTART;TZID=Europe/Berlin:20160301T190000 */
+type icalProperty struct {
+	Name   string
+	Params map[string]string
+	Value  string
+}
+
+/* A component of an iCalendar object, e.g. VCALENDAR or VEVENT. */
+type icalComponent struct {
+	Name       string
+	Properties []icalProperty
+	Components []*icalComponent
+}
+
+func (c *icalComponent) get(name string) (icalProperty, bool) {
+	for _, p := range c.Properties {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return icalProperty{}, false
+}
+
+func (c *icalComponent) all(name string) []icalProperty {
+	var retval []icalProperty
+	for _, p := range c.Properties {
+		if p.Name == name {
+			retval = append(retval, p)
+		}
+	}
+	return retval
+}
+
+// parseICal parses an iCalendar object and returns its top level
+// component, usually a VCALENDAR.
+func parseICal(r io.Reader) (*icalComponent, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), ICAL_MAX_SIZE)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(line) > 0 && (line[0] == ' ' || line[0] == '\t') && len(lines) > 0 {
+			// unfold continuation lines
+			lines[len(lines)-1] += line[1:]
+		} else if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	var root *icalComponent
+	var stack []*icalComponent
+	for idx, line := range lines {
+		p, err := parseICalProperty(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", idx+1, err.Error())
+		}
+		switch p.Name {
+		case "BEGIN":
+			c := &icalComponent{Name: strings.ToUpper(p.Value)}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.Components = append(parent.Components, c)
+			} else if root == nil {
+				root = c
+			}
+			stack = append(stack, c)
+		case "END":
+			if len(stack) == 0 || stack[len(stack)-1].Name != strings.ToUpper(p.Value) {
+				return nil, fmt.Errorf("line %d: unexpected END:%s", idx+1, p.Value)
+			}
+			stack = stack[:len(stack)-1]
+		default:
+			if len(stack) > 0 {
+				c := stack[len(stack)-1]
+				c.Properties = append(c.Properties, p)
+			}
+		}
+	}
+	if root == nil {
+		return nil, fmt.Errorf("no calendar found")
+	}
+	if len(stack) > 0 {
+		return nil, fmt.Errorf("missing END:%s", stack[len(stack)-1].Name)
+	}
+	return root, nil
+}
+
+// parseICalProperty splits a content line into name, parameters and
+// value. Parameter values may be quoted and contain colons.
+func parseICalProperty(line string) (icalProperty, error) {
+	p := icalProperty{Params: make(map[string]string)}
+	nameEnd := strings.IndexAny(line, ";:")
+	if nameEnd <= 0 {
+		return p, fmt.Errorf("invalid content line %q", line)
+	}
+	p.Name = strings.ToUpper(line[:nameEnd])
+	rest := line[nameEnd:]
+	for len(rest) > 0 && rest[0] == ';' {
+		rest = rest[1:]
+		eq := strings.IndexByte(rest, '=')
+		if eq < 0 {
+			return p, fmt.Errorf("invalid parameter in %q", line)
+		}
+		key := strings.ToUpper(rest[:eq])
+		rest = rest[eq+1:]
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.IndexByte(rest[1:], '"')
+			if end < 0 {
+				return p, fmt.Errorf("unterminated quote in %q", line)
+			}
+			value = rest[1 : end+1]
+			rest = rest[end+2:]
+		} else {
+			end := strings.IndexAny(rest, ";:")
+			if end < 0 {
+				return p, fmt.Errorf("missing value in %q", line)
+			}
+			value = rest[:end]
+			rest = rest[end:]
+		}
+		p.Params[key] = value
+	}
+	if !strings.HasPrefix(rest, ":") {
+		return p, fmt.Errorf("missing value in %q", line)
+	}
+	p.Value = rest[1:]
+	return p, nil
+}
+
+// icalUnescape reverses icalEscape.
+func icalUnescape(s string) string {
+	var retval []rune
+	escaped := false
+	for _, r := range s {
+		if escaped {
+			if r == 'n' || r == 'N' {
+				r = '\n'
+			}
+			retval = append(retval, r)
+			escaped = false
+		} else if r == '\\' {
+			escaped = true
+		} else {
+			retval = append(retval, r)
+		}
+	}
+	return string(retval)
+}
+
+/* ImportResult summarizes an import of events. */
+type ImportResult struct {
+	Created  int
+	Updated  int
+	Deleted  int
+	Skipped  int
+	Warnings []string
+}
+
+func (r ImportResult) String() string {
+	retval := fmt.Sprintf("Imported %d new and %d updated events", r.Created,
+		r.Updated)
+	if r.Deleted > 0 {
+		retval += fmt.Sprintf(", deleted %d cancelled events", r.Deleted)
+	}
+	if r.Skipped > 0 {
+		retval += fmt.Sprintf(", skipped %d events", r.Skipped)
+	}
+	return retval + "."
+}
+
+func (r *ImportResult) warn(format string, args ...interface{}) {
+	r.Warnings = append(r.Warnings, fmt.Sprintf(format, args...))
+}
+
+/* The icalImporter resolves the times of a calendar. */
+type icalImporter struct {
+	bot *EventBot
+	// offsets of the VTIMEZONEs of the calendar whose TZID is not a
+	// known time zone name
+	zones  map[string]*time.Location
+	result *ImportResult
+}
+
+func (imp *icalImporter) location(tzid string) *time.Location {
+	tzid = strings.TrimPrefix(tzid, "/")
+	if loc, ok := imp.zones[tzid]; ok {
+		return loc
+	}
+	if loc, err := time.LoadLocation(tzid); err == nil {
+		imp.zones[tzid] = loc
+		return loc
+	}
+	imp.result.warn("unknown time zone %s, using %s", tzid, imp.bot.TimeLocation)
+	imp.zones[tzid] = imp.bot.TimeLocation
+	return imp.bot.TimeLocation
+}
+
+// addTimezone remembers the standard offset of a VTIMEZONE for TZIDs
+// that are not IANA time zone names, e.g. from Outlook.
+func (imp *icalImporter) addTimezone(c *icalComponent) {
+	tzid, ok := c.get("TZID")
+	if !ok {
+		return
+	}
+	if _, err := time.LoadLocation(strings.TrimPrefix(tzid.Value, "/")); err == nil {
+		return
+	}
+	for _, observance := range c.Components {
+		if observance.Name != "STANDARD" {
+			continue
+		}
+		offset, ok := observance.get("TZOFFSETTO")
+		if !ok {
+			continue
+		}
+		var sign byte
+		var hours, minutes int
+		if _, err := fmt.Sscanf(offset.Value, "%c%02d%02d", &sign, &hours,
+			&minutes); err != nil {
+			continue
+		}
+		seconds := hours*3600 + minutes*60
+		if sign == '-' {
+			seconds = -seconds
+		}
+		imp.zones[tzid.Value] = time.FixedZone(tzid.Value, seconds)
+	}
+}
+
+// parseTime parses a DATE or DATE-TIME value. Floating times and dates
+// are interpreted in the time zone of the bot.
+func (imp *icalImporter) parseTime(p icalProperty, value string) (time.Time, error) {
+	loc := imp.bot.TimeLocation
+	if tzid, ok := p.Params["TZID"]; ok {
+		loc = imp.location(tzid)
+	}
+	if icalIsDate(p, value) {
+		return time.ParseInLocation("20060102", value, loc)
+	}
+	if strings.HasSuffix(value, "Z") {
+		return time.Parse(ICAL_UTC_TIME_FORMAT, value)
+	}
+	return time.ParseInLocation(ICAL_LOCAL_TIME_FORMAT, value, loc)
+}
+
+// icalIsDate reports whether a value of the property is a DATE.
+func icalIsDate(p icalProperty, value string) bool {
+	return p.Params["VALUE"] == "DATE" || len(value) == 8
+}
+
+// parseTimes parses a property with a list of times, e.g. EXDATE.
+func (imp *icalImporter) parseTimes(p icalProperty) ([]time.Time, error) {
+	var retval []time.Time
+	for _, value := range strings.Split(p.Value, ",") {
+		t, err := imp.parseTime(p, value)
+		if err != nil {
+			return nil, err
+		}
+		retval = append(retval, t)
+	}
+	return retval, nil
+}
+
+// findByUID returns the event with the given UID. Events exported by
+// her0ld are found by their id.
+func (b *EventBot) findByUID(uid string) (Event, bool) {
+	var events []Event
+	b.Db.Where("uid = ?", uid).Find(&events)
+	if len(events) == 0 {
+		if match := ownUIDPattern.FindStringSubmatch(uid); match != nil {
+			b.Db.Where("id = ? and (uid is null or uid = '')", match[1]).Find(&events)
+		}
+	}
+	if len(events) == 0 {
+		return Event{}, false
+	}
+	return events[0], true
+}
+
+// parseICalDuration parses a DURATION value, e.g. PT1H30M or P1W, see
+// RFC 5545, 3.3.6.
+func parseICalDuration(value string) (time.Duration, error) {
+	s := strings.ToUpper(value)
+	sign := time.Duration(1)
+	if strings.HasPrefix(s, "-") {
+		sign = -1
+	}
+	s = strings.TrimLeft(s, "+-")
+	if !strings.HasPrefix(s, "P") || len(s) < 3 {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	units := map[byte]time.Duration{
+		'W': 7 * 24 * time.Hour, 'D': 24 * time.Hour,
+		'H': time.Hour, 'M': time.Minute, 'S': time.Second,
+	}
+	var retval time.Duration
+	n := -1
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= '0' && c <= '9':
+			if n < 0 {
+				n = 0
+			}
+			n = n*10 + int(c-'0')
+		case c == 'T':
+			// the time part, M are minutes from here on
+		case units[c] != 0 && n >= 0:
+			retval += time.Duration(n) * units[c]
+			n = -1
+		default:
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+	}
+	if n >= 0 {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	return sign * retval, nil
+}
+
+// icalSplitList splits a list of TEXT values at the unescaped commas
+// and unescapes the values.
+func icalSplitList(value string) []string {
+	var retval []string
+	start := 0
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case '\\':
+			i++
+		case ',':
+			retval = append(retval, icalUnescape(value[start:i]))
+			start = i + 1
+		}
+	}
+	return append(retval, icalUnescape(value[start:]))
+}
+
+// ImportICS reads the VEVENTs of an iCalendar object into the event
+// table. Events are identified by their UID, importing the same
+// calendar again updates the events.
+func (b *EventBot) ImportICS(r io.Reader) (ImportResult, error) {
+	var result ImportResult
+	calendar, err := parseICal(r)
+	if err != nil {
+		return result, err
+	}
+	imp := &icalImporter{
+		bot:    b,
+		zones:  make(map[string]*time.Location),
+		result: &result,
+	}
+	var events, overrides []*icalComponent
+	for _, c := range calendar.Components {
+		switch c.Name {
+		case "VTIMEZONE":
+			imp.addTimezone(c)
+		case "VEVENT":
+			if _, ok := c.get("RECURRENCE-ID"); ok {
+				overrides = append(overrides, c)
+			} else {
+				events = append(events, c)
+			}
+		}
+	}
+	// the series have to exist before their modified occurrences
+	for _, c := range append(events, overrides...) {
+		if err := imp.importEvent(c); err != nil {
+			result.Skipped++
+			result.warn("%s", err.Error())
+		}
+	}
+	for _, warning := range result.Warnings {
+		log.Printf("Eventbot: Import: %s", warning)
+	}
+	return result, nil
+}
+
+func (imp *icalImporter) importEvent(c *icalComponent) error {
+	b := imp.bot
+	uidProp, ok := c.get("UID")
+	if !ok || uidProp.Value == "" {
+		return fmt.Errorf("event without UID")
+	}
+	uid := uidProp.Value
+	summary := ""
+	if p, ok := c.get("SUMMARY"); ok {
+		// IRC messages are single lines
+		summary = strings.Join(strings.Fields(icalUnescape(p.Value)), " ")
+	}
+	location := ""
+	if p, ok := c.get("LOCATION"); ok {
+		location = strings.Join(strings.Fields(icalUnescape(p.Value)), " ")
+	}
+	existing, found := b.findByUID(uid)
+
+	// a modified occurrence of a series replaces the original occurrence
+	if recurrenceId, ok := c.get("RECURRENCE-ID"); ok {
+		if !found {
+			return fmt.Errorf("%s: modified occurrence of an unknown series", uid)
+		}
+		original, err := imp.parseTime(recurrenceId, recurrenceId.Value)
+		if err != nil {
+			return fmt.Errorf("%s: invalid RECURRENCE-ID: %s", uid, err.Error())
+		}
+		b.Db.Where("event_id = ? and starttime = ?", existing.Id,
+			original).Delete(EventException{})
+		b.Db.Create(&EventException{EventId: existing.Id, Starttime: original})
+		uid = uid + "/" + original.UTC().Format(ICAL_UTC_TIME_FORMAT)
+		existing, found = b.findByUID(uid)
+	}
+
+	if status, ok := c.get("STATUS"); ok && strings.ToUpper(status.Value) == "CANCELLED" {
+		if found {
+			b.deleteEvent(existing)
+			imp.result.Deleted++
+		}
+		return nil
+	}
+
+	dtstart, ok := c.get("DTSTART")
+	if !ok {
+		return fmt.Errorf("%s: event without DTSTART", uid)
+	}
+	start, err := imp.parseTime(dtstart, dtstart.Value)
+	if err != nil {
+		return fmt.Errorf("%s: invalid DTSTART: %s", uid, err.Error())
+	}
+	var end time.Time
+	if p, ok := c.get("DTEND"); ok {
+		if end, err = imp.parseTime(p, p.Value); err != nil {
+			return fmt.Errorf("%s: invalid DTEND: %s", uid, err.Error())
+		}
+	} else if p, ok := c.get("DURATION"); ok {
+		d, err := parseICalDuration(p.Value)
+		if err != nil {
+			return fmt.Errorf("%s: %s", uid, err.Error())
+		}
+		end = start.Add(d)
+	}
+	if !end.After(start) {
+		end = time.Time{}
+	}
+	var categories []string
+	for _, p := range c.all("CATEGORIES") {
+		for _, category := range icalSplitList(p.Value) {
+			if category = strings.TrimSpace(category); category != "" {
+				categories = append(categories, category)
+			}
+		}
+	}
+	url := ""
+	if p, ok := c.get("URL"); ok {
+		url = strings.TrimSpace(p.Value)
+	}
+	rrule := ""
+	if p, ok := c.get("RRULE"); ok {
+		rec, err := ParseRRule(p.Value)
+		if err != nil {
+			return fmt.Errorf("%s (%s): %s", uid, summary, err.Error())
+		}
+		rrule = rec.String()
+	}
+	var exdates []time.Time
+	timed := !icalIsDate(dtstart, dtstart.Value)
+	for _, p := range c.all("EXDATE") {
+		times, err := imp.parseTimes(p)
+		if err != nil {
+			return fmt.Errorf("%s: invalid EXDATE: %s", uid, err.Error())
+		}
+		if timed && icalIsDate(p, strings.SplitN(p.Value, ",", 2)[0]) {
+			// a date excludes the occurrence on that day
+			for i, t := range times {
+				times[i] = time.Date(t.Year(), t.Month(), t.Day(), start.Hour(),
+					start.Minute(), start.Second(), 0, start.Location())
+			}
+		}
+		exdates = append(exdates, times...)
+	}
+
+	event := existing
+	event.Uid = uid
+	event.Starttime = start
+	event.Description = summary
+	event.Location = location
+	event.Rrule = rrule
+	event.Endtime = end
+	event.Url = url
+	event.Category = ""
+	event.Tags = ""
+	if len(categories) > 0 {
+		// the first category is the category of the event, the others
+		// are its tags
+		event.Category = categories[0]
+		var tags []string
+		for _, tag := range categories[1:] {
+			// tags are single words
+			tags = append(tags, strings.Join(strings.Fields(tag), "-"))
+		}
+		event.Tags = normalizeTags(strings.Join(tags, ","))
+	}
+	if found {
+		b.Db.Save(&event)
+		b.Db.Where("event_id = ?", event.Id).Delete(EventException{})
+		imp.result.Updated++
+	} else {
+		b.Db.Create(&event)
+		imp.result.Created++
+	}
+	for _, exdate := range exdates {
+		b.Db.Create(&EventException{EventId: event.Id, Starttime: exdate})
+	}
+	return nil
+}
+
+// OpenICS opens a local iCalendar file or downloads it if the source is
+// an HTTP(S) URL.
+func OpenICS(source string) (io.ReadCloser, error) {
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		return os.Open(source)
+	}
+	client := &http.Client{Timeout: ICAL_FETCH_TIMEOUT}
+	resp, err := client.Get(source)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("cannot download %s: %s", source, resp.Status)
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(resp.Body, ICAL_MAX_SIZE), resp.Body}, nil
+}
@@ -0,0 +1,249 @@
+package her0ldbot
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testCalendar = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"PRODID:-//Test//Test//EN\r\n" +
+	"BEGIN:VTIMEZONE\r\n" +
+	"TZID:W. Europe Standard Time\r\n" +
+	"BEGIN:STANDARD\r\n" +
+	"DTSTART:16010101T030000\r\n" +
+	"TZOFFSETFROM:+0200\r\n" +
+	"TZOFFSETTO:+0100\r\n" +
+	"END:STANDARD\r\n" +
+	"END:VTIMEZONE\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:weekly@example.com\r\n" +
+	"DTSTART;TZID=Europe/Berlin:20160301T190000\r\n" +
+	"SUMMARY:Weekly meetup\\, with \r\n" +
+	" pizza\r\n" +
+	"RRULE:FREQ=WEEKLY;BYDAY=TU;COUNT=10\r\n" +
+	"EXDATE;TZID=Europe/Berlin:20160308T190000,20160315T190000\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:weekly@example.com\r\n" +
+	"RECURRENCE-ID;TZID=Europe/Berlin:20160322T190000\r\n" +
+	"DTSTART;TZID=Europe/Berlin:20160323T200000\r\n" +
+	"SUMMARY:Weekly meetup (moved)\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:single@example.com\r\n" +
+	"DTSTART:20160401T170000Z\r\n" +
+	"SUMMARY:Talk\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:outlook@example.com\r\n" +
+	"DTSTART;TZID=\"W. Europe Standard Time\":20160402T100000\r\n" +
+	"SUMMARY:Outlook event\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:yearly@example.com\r\n" +
+	"DTSTART:20160101\r\n" +
+	"SUMMARY:New year\r\n" +
+	"RRULE:FREQ=YEARLY\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestParseICalProperty(t *testing.T) {
+	p, err := parseICalProperty(`DTSTART;TZID="Europe/Berlin";VALUE=DATE-TIME:20160301T190000`)
+	if err != nil {
+		t.Fatalf("Failed to parse property: %s", err.Error())
+	}
+	if p.Name != "DTSTART" || p.Params["TZID"] != "Europe/Berlin" ||
+		p.Params["VALUE"] != "DATE-TIME" || p.Value != "20160301T190000" {
+		t.Fatalf("Invalid property: %#v", p)
+	}
+	p, err = parseICalProperty(`ATTENDEE;CN="Doe: John":mailto:john@example.com`)
+	if err != nil || p.Params["CN"] != "Doe: John" || p.Value != "mailto:john@example.com" {
+		t.Fatalf("Invalid property with quoted colon: %#v (%v)", p, err)
+	}
+	if _, err := parseICalProperty("no value"); err == nil {
+		t.Fatalf("Invalid content line was accepted")
+	}
+	if icalUnescape(icalEscape("a,b;c\\d\ne")) != "a,b;c\\d\ne" {
+		t.Fatalf("Unescaping does not reverse escaping")
+	}
+}
+
+func TestImportICS(t *testing.T) {
+	bot := MkEventBot()
+	result, err := bot.ImportICS(strings.NewReader(testCalendar))
+	if err != nil {
+		t.Fatalf("Import failed: %s", err.Error())
+	}
+	if result.Created != 4 || result.Updated != 0 || result.Skipped != 1 {
+		t.Fatalf("Unexpected import result: %#v", result)
+	}
+
+	series, ok := bot.findByUID("weekly@example.com")
+	if !ok {
+		t.Fatalf("Series not imported")
+	}
+	berlin := bot.TimeLocation
+	if !series.Starttime.Equal(time.Date(2016, 3, 1, 19, 0, 0, 0, berlin)) ||
+		series.Description != "Weekly meetup, with pizza" ||
+		series.Rrule != "FREQ=WEEKLY;BYDAY=TU;COUNT=10" {
+		t.Fatalf("Invalid series: %#v", series)
+	}
+	occurrences := bot.eventsBetween(time.Date(2016, 3, 1, 0, 0, 0, 0, berlin),
+		time.Date(2016, 3, 31, 0, 0, 0, 0, berlin))
+	var got []string
+	for _, o := range occurrences {
+		got = append(got, o.Starttime.Format("02.01. 15:04 ")+o.Description)
+	}
+	expected := "01.03. 19:00 Weekly meetup, with pizza|" +
+		"23.03. 20:00 Weekly meetup (moved)|" +
+		"29.03. 19:00 Weekly meetup, with pizza"
+	if strings.Join(got, "|") != expected {
+		t.Fatalf("Invalid occurrences:\nexpected %s\ngot      %s", expected,
+			strings.Join(got, "|"))
+	}
+
+	single, _ := bot.findByUID("single@example.com")
+	if !single.Starttime.Equal(time.Date(2016, 4, 1, 17, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Invalid UTC start: %s", single.Starttime)
+	}
+	outlook, _ := bot.findByUID("outlook@example.com")
+	if !outlook.Starttime.Equal(time.Date(2016, 4, 2, 9, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Invalid start with VTIMEZONE offset: %s", outlook.Starttime)
+	}
+
+	// importing again updates the events
+	updated := strings.Replace(testCalendar, "SUMMARY:Talk", "SUMMARY:Talk (updated)", 1)
+	result, err = bot.ImportICS(strings.NewReader(updated))
+	if err != nil {
+		t.Fatalf("Import failed: %s", err.Error())
+	}
+	if result.Created != 0 || result.Updated != 4 {
+		t.Fatalf("Unexpected result of the second import: %#v", result)
+	}
+	var count int
+	bot.Db.Model(&Event{}).Count(&count)
+	if count != 4 {
+		t.Fatalf("Expected 4 events after the second import, got %d", count)
+	}
+	single, _ = bot.findByUID("single@example.com")
+	if single.Description != "Talk (updated)" {
+		t.Fatalf("Event was not updated: %#v", single)
+	}
+	var exceptions []EventException
+	bot.Db.Where("event_id = ?", series.Id).Find(&exceptions)
+	if len(exceptions) != 3 {
+		t.Fatalf("Expected 3 exceptions after the second import, got %d",
+			len(exceptions))
+	}
+
+	// cancelled events are removed
+	cancelled := strings.Replace(updated, "SUMMARY:Talk (updated)",
+		"SUMMARY:Talk\r\nSTATUS:CANCELLED", 1)
+	result, _ = bot.ImportICS(strings.NewReader(cancelled))
+	if result.Deleted != 1 {
+		t.Fatalf("Cancelled event was not deleted: %#v", result)
+	}
+	if _, ok := bot.findByUID("single@example.com"); ok {
+		t.Fatalf("Cancelled event still exists")
+	}
+}
+
+// Dates exclude the occurrences of timed series on these days.
+func TestImportDateExdate(t *testing.T) {
+	bot := MkEventBot()
+	calendar := "BEGIN:VCALENDAR\r\n" +
+		"BEGIN:VEVENT\r\n" +
+		"UID:weekly@example.com\r\n" +
+		"DTSTART;TZID=Europe/Berlin:20160301T190000\r\n" +
+		"SUMMARY:Weekly meetup\r\n" +
+		"RRULE:FREQ=WEEKLY;COUNT=4\r\n" +
+		"EXDATE;VALUE=DATE:20160308,20160322\r\n" +
+		"END:VEVENT\r\n" +
+		"END:VCALENDAR\r\n"
+	if _, err := bot.ImportICS(strings.NewReader(calendar)); err != nil {
+		t.Fatalf("Import failed: %s", err.Error())
+	}
+	berlin := bot.TimeLocation
+	occurrences := bot.eventsBetween(time.Date(2016, 3, 1, 0, 0, 0, 0, berlin),
+		time.Date(2016, 3, 31, 0, 0, 0, 0, berlin))
+	var got []string
+	for _, o := range occurrences {
+		got = append(got, o.Starttime.Format("02.01. 15:04"))
+	}
+	if expected := "01.03. 19:00|15.03. 19:00"; strings.Join(got, "|") != expected {
+		t.Fatalf("Invalid occurrences: expected %s, got %s", expected,
+			strings.Join(got, "|"))
+	}
+}
+
+// Importing the calendar of her0ld itself does not duplicate events.
+func TestImportOwnCalendar(t *testing.T) {
+	bot := MkEventBot()
+	today, _ := bot.dayBounds(time.Now())
+	bot.Db.Create(&Event{Starttime: today.AddDate(0, 0, 2), Description: "Own event"})
+	var doc bytes.Buffer
+	events, exceptions := bot.calendarEvents(today)
+	bot.WriteICS(&doc, events, exceptions)
+	result, err := bot.ImportICS(&doc)
+	if err != nil || result.Updated != 1 || result.Created != 0 {
+		t.Fatalf("Unexpected result: %#v (%v)", result, err)
+	}
+}
+
+func TestImportCommand(t *testing.T) {
+	bot := MkEventBot()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/calendar.ics" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(testCalendar))
+	}))
+	defer server.Close()
+
+	owner := InboundMessage{
+		Target:  "#channel",
+		Nick:    testOwnerNick,
+		Source:  testOwnerNick + "!user@owner.example.com",
+		Message: EVENTBOT_PREFIX + " import " + server.URL + "/calendar.ics",
+	}
+	followup := collectFollowups(t, &owner)
+	response, err := bot.ProcessChannelEvent(owner)
+	if err != nil || len(response) != 0 {
+		t.Fatalf("The import result should follow, got %#v (%v)", response, err)
+	}
+	if response = followup(); len(response) != 1 {
+		t.Fatalf("Unexpected follow-up %#v", response)
+	}
+	expected := "Imported 4 new and 0 updated events, skipped 1 events."
+	if response[0].Message != expected {
+		t.Fatalf("Invalid response: Expected >%s<, got >%s<", expected,
+			response[0].Message)
+	}
+
+	owner.Message = EVENTBOT_PREFIX + " import " + server.URL + "/missing.ics"
+	bot.ProcessChannelEvent(owner)
+	response = followup()
+	if len(response) != 1 || !strings.HasPrefix(response[0].Message, "Import failed") {
+		t.Fatalf("Download error not reported: %#v", response)
+	}
+	owner.Message = EVENTBOT_PREFIX + " import /etc/passwd"
+	response, _ = bot.ProcessChannelEvent(owner)
+	if len(response) != 1 || !strings.HasPrefix(response[0].Message, "Import failed") {
+		t.Fatalf("Local file was not rejected: %#v", response)
+	}
+
+	// only the owner may import
+	editor := owner
+	editor.Source = testOwnerNick + "!user@spoofed.example.com"
+	response, _ = bot.ProcessChannelEvent(editor)
+	if len(response) != 1 || response[0].Message != fmt.Sprintf(COMMAND_NOT_AUTHORIZED, RoleOwner) {
+		t.Fatalf("Import by non-owner was not rejected: %#v", response)
+	}
+}
@@ -0,0 +1,187 @@
+package her0ldbot
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/gonium/her0ld"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	EVENTBOT_MAILREMINDER_NO_JOBS     = "No reminder mails configured."
+	EVENTBOT_MAILREMINDER_UNKNOWN_JOB = "Unknown reminder mail %s, use one of: %s"
+	EVENTBOT_MAILREMINDER_QUEUED      = "Failed to send the reminder mail, will retry: %s"
+)
+
+type SendEventListStatus int
+
+const (
+	NO_EVENT_TODAY = iota
+	SEND_SUCCESS   = iota
+	SEND_ERROR     = iota
+	// the mail is in the outbox and will be retried
+	SEND_QUEUED = iota
+)
+
+// mailReminderReply describes the result of SendEventList.
+func mailReminderReply(status SendEventListStatus, err error) string {
+	switch status {
+	case NO_EVENT_TODAY:
+		return EVENTBOT_MAILREMINDER_NONE_AVAILABLE
+	case SEND_ERROR:
+		return fmt.Sprintf(EVENTBOT_MAILREMINDER_SEND_ERROR, err.Error())
+	case SEND_QUEUED:
+		return fmt.Sprintf(EVENTBOT_MAILREMINDER_QUEUED, err.Error())
+	default:
+		return EVENTBOT_MAILREMINDER_REPLY
+	}
+}
+
+// checkMailJob validates the window and recipients of a mail job. The
+// schedule is checked by the cron scheduler.
+func checkMailJob(job her0ld.MailJob) error {
+	if job.Name == "" {
+		return errors.New("the job has no name")
+	}
+	if len(job.Recipients) == 0 {
+		return errors.New("no recipients")
+	}
+	switch job.Window {
+	case her0ld.MAIL_WINDOW_TODAY, her0ld.MAIL_WINDOW_WEEK, her0ld.MAIL_WINDOW_NEXT7DAYS:
+		return nil
+	}
+	return fmt.Errorf("unknown window %q, use %s, %s or %s", job.Window,
+		her0ld.MAIL_WINDOW_TODAY, her0ld.MAIL_WINDOW_WEEK, her0ld.MAIL_WINDOW_NEXT7DAYS)
+}
+
+// findMailJob returns the mail job with the given name.
+func (b *EventBot) findMailJob(name string) (her0ld.MailJob, bool) {
+	for _, job := range b.MailJobs {
+		if strings.EqualFold(job.Name, name) {
+			return job, true
+		}
+	}
+	return her0ld.MailJob{}, false
+}
+
+// mailWindow returns the period of time covered by a mail sent at now:
+// the rest of the day, the rest of the week (until Sunday) or the
+// next seven days, each starting at the beginning of today.
+func (b *EventBot) mailWindow(window string, now time.Time) (time.Time, time.Time) {
+	today, tomorrow := b.dayBounds(now)
+	switch window {
+	case her0ld.MAIL_WINDOW_WEEK:
+		// the weeks start on Monday
+		return today, today.AddDate(0, 0, 7-(int(today.Weekday())+6)%7)
+	case her0ld.MAIL_WINDOW_NEXT7DAYS:
+		return today, today.AddDate(0, 0, 7)
+	}
+	return today, tomorrow
+}
+
+// composeEventList composes the mail of the job with a text and an HTML
+// version and the events of the window as iCalendar attachment. It
+// returns false if there are no events in the window and the job does
+// not send empty mails.
+func (b *EventBot) composeEventList(job her0ld.MailJob, now time.Time) (*Mail, bool, error) {
+	templates, err := b.mailTemplates(job)
+	if err != nil {
+		return nil, false, err
+	}
+	start, end := b.mailWindow(job.Window, now)
+	events := b.eventsBetween(start, end)
+	sort.Sort(ByDate(events))
+	if len(events) == 0 && !job.SendEmpty {
+		return nil, false, nil
+	}
+	upcoming := b.upcomingEvents(end)
+	data := eventListMail{
+		BotName:         b.BotName,
+		From:            b.MailSender.FromAddress,
+		To:              strings.Join(job.Recipients, ", "),
+		Now:             now.Format(time.RFC822),
+		Job:             job.Name,
+		Window:          job.Window,
+		Date:            now.In(b.TimeLocation),
+		Start:           start,
+		End:             end,
+		Events:          b.webEvents(events),
+		Upcoming:        b.webEvents(upcoming),
+		HighlightEvents: b.describeWithAttendees(events),
+		UpcomingEvents:  upcoming.String(),
+	}
+	m := &Mail{
+		From: b.MailSender.FromAddress,
+		To:   job.Recipients,
+		Date: now,
+	}
+	if m.Subject, m.Text, m.HTML, err = templates.render(data); err != nil {
+		return nil, false, err
+	}
+	if len(events) > 0 {
+		// the stored events, recurring events with their rule
+		var ids []int
+		seen := make(map[int]bool)
+		for _, event := range events {
+			if !seen[event.Id] {
+				seen[event.Id] = true
+				ids = append(ids, event.Id)
+			}
+		}
+		var stored []Event
+		b.Db.Where("id in (?)", ids).Order("starttime").Find(&stored)
+		var ics bytes.Buffer
+		if err := b.WriteICS(&ics, stored, b.exceptions(stored)); err != nil {
+			return nil, false, err
+		}
+		m.Attachments = []MailAttachment{{
+			Filename:    "events.ics",
+			ContentType: ICAL_CONTENT_TYPE,
+			Data:        ics.Bytes(),
+		}}
+	}
+	return m, true, nil
+}
+
+// RenderEventList composes the mail of the named job as it would be
+// sent at now, even if there are no events.
+func (b *EventBot) RenderEventList(name string, now time.Time) (*Mail, error) {
+	job, ok := b.findMailJob(name)
+	if !ok {
+		var names []string
+		for _, j := range b.MailJobs {
+			names = append(names, j.Name)
+		}
+		return nil, fmt.Errorf(EVENTBOT_MAILREMINDER_UNKNOWN_JOB, name,
+			strings.Join(names, ", "))
+	}
+	job.SendEmpty = true
+	m, _, err := b.composeEventList(job, now)
+	return m, err
+}
+
+// SendEventList sends the mail of the job to its recipients via the
+// outbox. If the delivery fails, the error is returned together with
+// SEND_QUEUED if the mail will be retried.
+func (b *EventBot) SendEventList(job her0ld.MailJob) (SendEventListStatus, error) {
+	mail, ok, err := b.composeEventList(job, time.Now())
+	if err != nil {
+		log.Printf("Eventbot: Failed to render mail %s - %s", job.Name, err.Error())
+		return SEND_ERROR, err
+	}
+	if !ok {
+		return NO_EVENT_TODAY, nil
+	}
+	entry, err := b.SendQueued(mail)
+	if err == nil {
+		return SEND_SUCCESS, nil
+	}
+	if entry != nil && !entry.Failed {
+		return SEND_QUEUED, err
+	}
+	return SEND_ERROR, err
+}
@@ -0,0 +1,150 @@
+package her0ldbot
+
+import (
+	"github.com/gonium/her0ld"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMailWindow(t *testing.T) {
+	bot := MkEventBot()
+	// a Wednesday
+	now := time.Date(2026, 10, 14, 10, 0, 0, 0, bot.TimeLocation)
+	day := func(d int) time.Time {
+		return time.Date(2026, 10, d, 0, 0, 0, 0, bot.TimeLocation)
+	}
+	for _, test := range []struct {
+		window   string
+		from, to time.Time
+	}{
+		{her0ld.MAIL_WINDOW_TODAY, day(14), day(15)},
+		{her0ld.MAIL_WINDOW_WEEK, day(14), day(19)},
+		{her0ld.MAIL_WINDOW_NEXT7DAYS, day(14), day(21)},
+	} {
+		from, to := bot.mailWindow(test.window, now)
+		if !from.Equal(test.from) || !to.Equal(test.to) {
+			t.Fatalf("%s: expected %s - %s, got %s - %s", test.window,
+				test.from, test.to, from, to)
+		}
+	}
+	// a weekly digest on Monday covers the whole week
+	from, to := bot.mailWindow(her0ld.MAIL_WINDOW_WEEK, day(19).Add(8*time.Hour))
+	if !from.Equal(day(19)) || !to.Equal(day(26)) {
+		t.Fatalf("Unexpected window on Monday: %s - %s", from, to)
+	}
+}
+
+func TestComposeEventList(t *testing.T) {
+	bot := MkEventBot()
+	bot.EventListMailTemplate = "Events:\n{{.HighlightEvents}}\nLater:\n{{.UpcomingEvents}}"
+	now := time.Date(2026, 10, 14, 10, 0, 0, 0, bot.TimeLocation)
+	for _, event := range []Event{
+		{Starttime: now.Add(9 * time.Hour), Description: "Today"},
+		{Starttime: now.AddDate(0, 0, 3), Description: "Saturday"},
+		{Starttime: now.AddDate(0, 0, 6), Description: "Tuesday"},
+	} {
+		bot.Db.Create(&event)
+	}
+	job := her0ld.MailJob{
+		Name:       "weekly",
+		Recipients: []string{"a@example.com", "b@example.com"},
+		Window:     her0ld.MAIL_WINDOW_WEEK,
+		Subject:    "This week",
+	}
+	mail, ok, err := bot.composeEventList(job, now)
+	if err != nil || !ok {
+		t.Fatalf("Failed to compose mail: %v", err)
+	}
+	if mail.Subject != "This week" || len(mail.To) != 2 {
+		t.Fatalf("Unexpected header of mail: %#v", mail)
+	}
+	for _, expected := range []string{"Events:\n(1) ", "Saturday\nLater:\n(3) "} {
+		if !strings.Contains(mail.Text, expected) {
+			t.Fatalf("Missing %q in mail:\n%s", expected, mail.Text)
+		}
+	}
+	if !strings.Contains(mail.HTML, "<strong>Sat 17 Oct 2026, 10:00</strong> Saturday") {
+		t.Fatalf("Missing event in HTML version:\n%s", mail.HTML)
+	}
+	if len(mail.Attachments) != 1 ||
+		!strings.Contains(string(mail.Attachments[0].Data), "SUMMARY:Saturday") ||
+		strings.Contains(string(mail.Attachments[0].Data), "SUMMARY:Tuesday") {
+		t.Fatalf("Unexpected attachments: %#v", mail.Attachments)
+	}
+
+	job.Window = her0ld.MAIL_WINDOW_TODAY
+	job.Subject = ""
+	job.Template = "{{.Job}}: {{.HighlightEvents}}"
+	mail, ok, err = bot.composeEventList(job, now)
+	if err != nil || !ok || mail.Text != "weekly: (1) Wed 14 Oct 2026, 19:00 - Today" {
+		t.Fatalf("Unexpected mail with job template: %#v", mail)
+	}
+	if mail.Subject != mailSubjects[her0ld.MAIL_WINDOW_TODAY] {
+		t.Fatalf("Unexpected default subject %q", mail.Subject)
+	}
+	// nothing happens tomorrow
+	if _, ok, _ = bot.composeEventList(job, now.AddDate(0, 0, 1)); ok {
+		t.Fatalf("Mail without events should not be sent")
+	}
+	job.SendEmpty = true
+	if _, ok, _ = bot.composeEventList(job, now.AddDate(0, 0, 1)); !ok {
+		t.Fatalf("Mail without events should be sent if SendEmpty is set")
+	}
+	job.Template = "{{.Unknown}}"
+	if _, _, err = bot.composeEventList(job, now); err == nil {
+		t.Fatalf("Invalid template should fail")
+	}
+}
+
+func TestCheckMailJob(t *testing.T) {
+	valid := her0ld.MailJob{Name: "daily", Recipients: []string{"a@example.com"},
+		Window: her0ld.MAIL_WINDOW_NEXT7DAYS}
+	if err := checkMailJob(valid); err != nil {
+		t.Fatalf("Valid job rejected: %s", err.Error())
+	}
+	for _, job := range []her0ld.MailJob{
+		{Recipients: valid.Recipients, Window: valid.Window},
+		{Name: "daily", Window: valid.Window},
+		{Name: "daily", Recipients: valid.Recipients, Window: "month"},
+	} {
+		if err := checkMailJob(job); err == nil {
+			t.Fatalf("Invalid job accepted: %#v", job)
+		}
+	}
+}
+
+func TestMailreminderCommand(t *testing.T) {
+	bot := MkEventBot()
+	msg := InboundMessage{
+		Target:  "#channel",
+		Nick:    testOwnerNick,
+		Source:  testOwnerNick + "!user@owner.example.com",
+		Message: EVENTBOT_PREFIX + " " + EVENTBOT_CMD_MAILREMINDER,
+	}
+	response, err := bot.ProcessChannelEvent(msg)
+	if err != nil || len(response) != 1 || response[0].Message != EVENTBOT_MAILREMINDER_NO_JOBS {
+		t.Fatalf("Unexpected answer without mail jobs: %v %v", response, err)
+	}
+	bot.MailJobs = []her0ld.MailJob{
+		{Name: "today", Window: her0ld.MAIL_WINDOW_TODAY},
+		{Name: "weekly", Window: her0ld.MAIL_WINDOW_WEEK},
+	}
+	msg.Message += " monthly"
+	response, err = bot.ProcessChannelEvent(msg)
+	expected := "Unknown reminder mail monthly, use one of: today, weekly"
+	if err != nil || len(response) != 1 || response[0].Message != expected {
+		t.Fatalf("Unexpected answer for unknown job: %v %v", response, err)
+	}
+	// no events, so nothing is sent
+	msg.Message = EVENTBOT_PREFIX + " " + EVENTBOT_CMD_MAILREMINDER + " Weekly"
+	followup := collectFollowups(t, &msg)
+	response, err = bot.ProcessChannelEvent(msg)
+	if err != nil || len(response) != 0 {
+		t.Fatalf("The result of the weekly job should follow: %v %v", response, err)
+	}
+	if response = followup(); len(response) != 1 ||
+		response[0].Message != EVENTBOT_MAILREMINDER_NONE_AVAILABLE {
+		t.Fatalf("Unexpected answer for weekly job: %v", response)
+	}
+}
@@ -0,0 +1,166 @@
+package her0ldbot
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/gonium/her0ld"
+	htmltemplate "html/template"
+	"io/ioutil"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// The built-in text version of the event list mails.
+const EVENTBOT_DEFAULT_MAIL_TEMPLATE = `{{range .Events}}{{template "event" .}}
+{{else}}No events.
+{{end}}{{if .Upcoming}}
+Upcoming events:
+{{range .Upcoming}}{{template "event" .}}
+{{end}}{{end}}`
+
+// The built-in HTML version of the event list mails.
+const EVENTBOT_DEFAULT_MAIL_HTML_TEMPLATE = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="UTF-8">
+<title>{{.Subject}}</title>
+</head>
+<body>
+<h1>{{.Subject}}</h1>
+{{if .Events}}
+<ul>
+{{range .Events}}
+	<li>
+		<strong>{{date .Starttime}}</strong> {{.Description}}{{if .Location}} @ {{.Location}}{{end}}
+		{{if .Recurrence}}({{.Recurrence}}){{end}}
+		{{if .Url}}<br><a href="{{.Url}}">{{.Url}}</a>{{end}}
+		{{if .Attendees}}<br>Attendees ({{len .Attendees}}): {{join .Attendees ", "}}{{end}}
+	</li>
+{{end}}
+</ul>
+{{else}}
+<p>No events.</p>
+{{end}}
+{{if .Upcoming}}
+<h2>Upcoming events</h2>
+<ul>
+{{range .Upcoming}}
+	<li>{{date .Starttime}} {{.Description}}{{if .Location}} @ {{.Location}}{{end}}</li>
+{{end}}
+</ul>
+{{end}}
+</body>
+</html>
+`
+
+// A text template "event" for the event lines, which the text
+// templates can use with {{template "event" .}}.
+const mailEventTemplate = `{{define "event"}}{{date .Starttime}}{{if not .Endtime.IsZero}} - {{if sameDay .Starttime .Endtime}}{{clock .Endtime}}{{else}}{{date .Endtime}}{{end}}{{end}}: {{.Description}}{{if .Location}} @ {{.Location}}{{end}}{{if .Url}} {{.Url}}{{end}}{{if .Attendees}}
+    Attendees ({{len .Attendees}}): {{join .Attendees ", "}}{{end}}{{end}}`
+
+// The subjects of mails without a configured subject.
+var mailSubjects = map[string]string{
+	her0ld.MAIL_WINDOW_TODAY:     "Events today",
+	her0ld.MAIL_WINDOW_WEEK:      "Events this week",
+	her0ld.MAIL_WINDOW_NEXT7DAYS: "Events in the next 7 days",
+}
+
+/* The data of the event list mail templates. */
+type eventListMail struct {
+	BotName string
+	From    string
+	To      string
+	// The time of sending, Now is formatted according to RFC 822.
+	Now     string
+	Date    time.Time
+	Subject string
+	// Name and window of the mail job, the window is [Start, End).
+	Job    string
+	Window string
+	Start  time.Time
+	End    time.Time
+	// The events in the window and those after it.
+	Events   []*webEvent
+	Upcoming []*webEvent
+	// The same events as text, one line per event as in the channel.
+	HighlightEvents string
+	UpcomingEvents  string
+}
+
+/* The parsed templates of a mail job. */
+type mailTemplateSet struct {
+	subject *template.Template
+	text    *template.Template
+	html    *htmltemplate.Template
+}
+
+// templateSource returns the text of a template, from the first given
+// file or else the first non-empty inline text.
+func templateSource(files []string, inline ...string) (string, error) {
+	for _, file := range files {
+		if file != "" {
+			text, err := ioutil.ReadFile(file)
+			return string(text), err
+		}
+	}
+	for _, text := range inline {
+		if text != "" {
+			return text, nil
+		}
+	}
+	return "", nil
+}
+
+// mailTemplates parses the templates of the job. Template files are
+// read again every time, so changes apply to the next mail.
+func (b *EventBot) mailTemplates(job her0ld.MailJob) (*mailTemplateSet, error) {
+	set := &mailTemplateSet{}
+	subject := job.Subject
+	if subject == "" {
+		subject = mailSubjects[job.Window]
+	}
+	var err error
+	if set.subject, err = template.New("subject").Funcs(templateFuncs).Parse(subject); err != nil {
+		return nil, err
+	}
+	text, err := templateSource([]string{job.TemplateFile, b.EventListMailTemplateFile},
+		job.Template, b.EventListMailTemplate, EVENTBOT_DEFAULT_MAIL_TEMPLATE)
+	if err != nil {
+		return nil, err
+	}
+	set.text = template.Must(template.New("text").Funcs(templateFuncs).Parse(mailEventTemplate))
+	if _, err = set.text.Parse(text); err != nil {
+		return nil, err
+	}
+	html, err := templateSource([]string{job.HTMLTemplateFile, b.EventListMailHTMLFile},
+		EVENTBOT_DEFAULT_MAIL_HTML_TEMPLATE)
+	if err != nil {
+		return nil, err
+	}
+	if set.html, err = htmltemplate.New("html").Funcs(templateFuncs).Parse(html); err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+// render executes the templates. The subject is rendered first and
+// passed to the body templates.
+func (set *mailTemplateSet) render(data eventListMail) (subject, text, html string, err error) {
+	var buf bytes.Buffer
+	if err = set.subject.Execute(&buf, data); err != nil {
+		return "", "", "", fmt.Errorf("subject: %s", err.Error())
+	}
+	// the subject is a single line
+	data.Subject = strings.Join(strings.Fields(buf.String()), " ")
+	buf.Reset()
+	if err = set.text.Execute(&buf, data); err != nil {
+		return "", "", "", err
+	}
+	text = buf.String()
+	buf.Reset()
+	if err = set.html.Execute(&buf, data); err != nil {
+		return "", "", "", err
+	}
+	return data.Subject, text, buf.String(), nil
+}
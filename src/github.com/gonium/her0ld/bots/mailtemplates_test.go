@@ -0,0 +1,94 @@
+package her0ldbot
+
+import (
+	"github.com/gonium/her0ld"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDefaultMailTemplates(t *testing.T) {
+	bot := MkEventBot()
+	now := time.Date(2026, 10, 14, 10, 0, 0, 0, bot.TimeLocation)
+	bot.Db.Create(&Event{Starttime: now.Add(9 * time.Hour), Endtime: now.Add(11 * time.Hour),
+		Description: "Grillen", Location: "Hof"})
+	bot.Db.Create(&Attendee{EventId: 1, Nick: "alice"})
+	bot.Db.Create(&Event{Starttime: now.AddDate(0, 0, 2), Description: "Later"})
+	job := her0ld.MailJob{Name: "today", Recipients: []string{"a@example.com"},
+		Window: her0ld.MAIL_WINDOW_TODAY}
+	mail, ok, err := bot.composeEventList(job, now)
+	if err != nil || !ok {
+		t.Fatalf("Failed to compose mail: %v", err)
+	}
+	expected := "Wed 14 Oct 2026, 19:00 - 21:00: Grillen @ Hof\n" +
+		"    Attendees (1): alice\n\nUpcoming events:\nFri 16 Oct 2026, 10:00: Later\n"
+	if mail.Text != expected {
+		t.Fatalf("Unexpected text:\n%s\nexpected:\n%s", mail.Text, expected)
+	}
+	if mail.Subject != "Events today" || !strings.Contains(mail.HTML, "<title>Events today</title>") {
+		t.Fatalf("Unexpected subject %q", mail.Subject)
+	}
+}
+
+func TestMailTemplateFiles(t *testing.T) {
+	bot := MkEventBot()
+	dir, err := ioutil.TempDir("", "her0ld-mail")
+	if err != nil {
+		t.Fatalf("Failed to create directory: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	textFile := filepath.Join(dir, "today.txt")
+	htmlFile := filepath.Join(dir, "today.html")
+	ioutil.WriteFile(textFile, []byte(`{{range .Events}}* {{clock .Starttime}} {{.Description}}{{end}}`), 0644)
+	ioutil.WriteFile(htmlFile, []byte(`<p>{{.Subject}}</p>`), 0644)
+
+	now := time.Date(2026, 10, 14, 10, 0, 0, 0, bot.TimeLocation)
+	bot.Db.Create(&Event{Starttime: now.Add(9 * time.Hour), Description: "Grillen & Bier"})
+	job := her0ld.MailJob{Name: "today", Recipients: []string{"a@example.com"},
+		Window: her0ld.MAIL_WINDOW_TODAY, Subject: "{{len .Events}} event(s) on {{day .Start}}",
+		TemplateFile: textFile, HTMLTemplateFile: htmlFile}
+	mail, _, err := bot.composeEventList(job, now)
+	if err != nil {
+		t.Fatalf("Failed to compose mail: %s", err.Error())
+	}
+	if mail.Subject != "1 event(s) on Wed 14 Oct" || mail.Text != "* 19:00 Grillen & Bier" ||
+		mail.HTML != "<p>1 event(s) on Wed 14 Oct</p>" {
+		t.Fatalf("Unexpected mail: %#v", mail)
+	}
+
+	// changed files apply to the next mail
+	ioutil.WriteFile(textFile, []byte(`{{.Job}} {{format "02.01." .Date}}`), 0644)
+	mail, _, _ = bot.composeEventList(job, now)
+	if mail.Text != "today 14.10." {
+		t.Fatalf("Template file not reloaded: %q", mail.Text)
+	}
+
+	// the file of the settings is used by jobs without their own template
+	job.TemplateFile = ""
+	bot.EventListMailTemplateFile = filepath.Join(dir, "missing.txt")
+	if _, err := bot.mailTemplates(job); err == nil {
+		t.Fatalf("Missing template file should fail")
+	}
+	job.Subject = "{{.Unclosed"
+	bot.EventListMailTemplateFile = ""
+	if _, err := bot.mailTemplates(job); err == nil {
+		t.Fatalf("Invalid subject template should fail")
+	}
+}
+
+func TestRenderEventList(t *testing.T) {
+	bot := MkEventBot()
+	bot.MailJobs = []her0ld.MailJob{{Name: "weekly", Recipients: []string{"a@example.com"},
+		Window: her0ld.MAIL_WINDOW_WEEK}}
+	mail, err := bot.RenderEventList("weekly", time.Now())
+	if err != nil || mail.Text != "No events.\n" {
+		t.Fatalf("Unexpected preview without events: %v %v", mail, err)
+	}
+	if _, err := bot.RenderEventList("daily", time.Now()); err == nil ||
+		err.Error() != "Unknown reminder mail daily, use one of: weekly" {
+		t.Fatalf("Unexpected error for unknown job: %v", err)
+	}
+}
@@ -0,0 +1,370 @@
+package her0ldbot
+
+import (
+	"fmt"
+	"github.com/gonium/her0ld"
+	"github.com/jinzhu/gorm"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+const (
+	// The table of the applied migrations.
+	SCHEMA_VERSION_TABLE = "schema_version"
+	// Time in the names of the copies of SQLite databases made before
+	// migrations, e.g. events.db.v2-20160502-190000.bak
+	MIGRATION_BACKUP_TIME_FORMAT = "20060102-150405"
+)
+
+/* A Migration changes the schema of the database from the previous
+ * version to Version, and back. Migrations use their own copies of
+ * the models, so that they keep working when the models change. */
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *gorm.DB) error
+	// nil if the migration cannot be reverted.
+	Down func(tx *gorm.DB) error
+}
+
+/* A SchemaVersion records an applied migration. */
+type SchemaVersion struct {
+	Version   int `gorm:"primary_key"`
+	Name      string
+	AppliedAt time.Time
+}
+
+func (SchemaVersion) TableName() string {
+	return SCHEMA_VERSION_TABLE
+}
+
+/* The state of a migration, see Migrator.Status. */
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+func (ms MigrationStatus) String() string {
+	state := "pending"
+	if ms.Applied {
+		state = "applied " + ms.AppliedAt.Format(EVENTBOT_TIME_FORMAT)
+	}
+	return fmt.Sprintf("%3d %-30s %s", ms.Version, ms.Name, state)
+}
+
+/* The Migrator applies and reverts the migrations of a database. */
+type Migrator struct {
+	Db         *gorm.DB
+	Migrations []Migration
+	// The SQLite database file, which is copied before migrations. Empty
+	// for other databases.
+	BackupFile string
+}
+
+// NewMigrator returns a migrator with all migrations of the bots.
+func NewMigrator(db *gorm.DB, cfg her0ld.EventbotConfig) *Migrator {
+	m := &Migrator{Db: db, Migrations: migrations}
+	if dialect, dsn, err := cfg.DatabaseSource(); err == nil && dialect == her0ld.DB_SQLITE {
+		m.BackupFile = dsn
+	}
+	return m
+}
+
+// Latest returns the version of the newest migration.
+func (m *Migrator) Latest() int {
+	if len(m.Migrations) == 0 {
+		return 0
+	}
+	return m.Migrations[len(m.Migrations)-1].Version
+}
+
+// applied returns the applied migrations by version. The table of the
+// versions is created if necessary.
+func (m *Migrator) applied() (map[int]SchemaVersion, error) {
+	if err := m.Db.AutoMigrate(&SchemaVersion{}).Error; err != nil {
+		return nil, err
+	}
+	var versions []SchemaVersion
+	if err := m.Db.Find(&versions).Error; err != nil {
+		return nil, err
+	}
+	retval := make(map[int]SchemaVersion)
+	for _, v := range versions {
+		retval[v.Version] = v
+	}
+	return retval, nil
+}
+
+// Version returns the version of the database, 0 for an empty database.
+func (m *Migrator) Version() (int, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return 0, err
+	}
+	version := 0
+	for v := range applied {
+		if v > version {
+			version = v
+		}
+	}
+	return version, nil
+}
+
+// Status lists all migrations and whether they are applied.
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+	var retval []MigrationStatus
+	for _, migration := range m.Migrations {
+		v, ok := applied[migration.Version]
+		retval = append(retval, MigrationStatus{Migration: migration,
+			Applied: ok, AppliedAt: v.AppliedAt})
+	}
+	return retval, nil
+}
+
+// backup copies the SQLite database file and returns the name of the
+// copy. New databases without tables are not copied.
+func (m *Migrator) backup(version int) (string, error) {
+	if m.BackupFile == "" || (version == 0 && !m.Db.HasTable(&eventV1{})) {
+		return "", nil
+	}
+	in, err := os.Open(m.BackupFile)
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	if info, err := in.Stat(); err != nil || info.Size() == 0 {
+		return "", err
+	}
+	name := fmt.Sprintf("%s.v%d-%s.bak", m.BackupFile, version,
+		time.Now().Format(MIGRATION_BACKUP_TIME_FORMAT))
+	out, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(name)
+		return "", err
+	}
+	return name, out.Close()
+}
+
+// run applies a migration in a transaction. Note that MySQL commits
+// schema changes immediately.
+func (m *Migrator) run(migration Migration, up bool) error {
+	tx := m.Db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+	var err error
+	if up {
+		err = migration.Up(tx)
+		if err == nil {
+			err = tx.Create(&SchemaVersion{Version: migration.Version,
+				Name: migration.Name, AppliedAt: time.Now()}).Error
+		}
+	} else {
+		err = migration.Down(tx)
+		if err == nil {
+			err = tx.Delete(&SchemaVersion{Version: migration.Version}).Error
+		}
+	}
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("migration %d (%s): %s", migration.Version, migration.Name,
+			err.Error())
+	}
+	return tx.Commit().Error
+}
+
+// Up applies the pending migrations up to the target version, all of
+// them if target is 0. It returns the applied migrations.
+func (m *Migrator) Up(target int) ([]Migration, error) {
+	if target == 0 {
+		target = m.Latest()
+	}
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+	var pending []Migration
+	for _, migration := range m.Migrations {
+		if _, ok := applied[migration.Version]; !ok && migration.Version <= target {
+			pending = append(pending, migration)
+		}
+	}
+	return m.migrate(pending, true)
+}
+
+// Down reverts the applied migrations newer than the target version,
+// the newest first. It returns the reverted migrations.
+func (m *Migrator) Down(target int) ([]Migration, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+	var reverted []Migration
+	for i := len(m.Migrations) - 1; i >= 0; i-- {
+		migration := m.Migrations[i]
+		if _, ok := applied[migration.Version]; ok && migration.Version > target {
+			if migration.Down == nil {
+				return nil, fmt.Errorf("migration %d (%s) cannot be reverted",
+					migration.Version, migration.Name)
+			}
+			reverted = append(reverted, migration)
+		}
+	}
+	return m.migrate(reverted, false)
+}
+
+func (m *Migrator) migrate(todo []Migration, up bool) ([]Migration, error) {
+	if len(todo) == 0 {
+		return nil, nil
+	}
+	version, err := m.Version()
+	if err != nil {
+		return nil, err
+	}
+	if backup, err := m.backup(version); err != nil {
+		return nil, fmt.Errorf("failed to back up the database - %s", err.Error())
+	} else if backup != "" {
+		log.Printf("Database: Saved a copy of version %d in %s", version, backup)
+	}
+	var done []Migration
+	for _, migration := range todo {
+		direction := "Applying"
+		if !up {
+			direction = "Reverting"
+		}
+		log.Printf("Database: %s migration %d (%s)", direction, migration.Version,
+			migration.Name)
+		if err := m.run(migration, up); err != nil {
+			return done, err
+		}
+		done = append(done, migration)
+	}
+	return done, nil
+}
+
+/******************************** The migrations *********************************/
+
+// The tables as created by the first migration. Databases created by
+// earlier versions of the bot have the same tables.
+type eventV1 struct {
+	Id          int `sql:"AUTO_INCREMENT" gorm:"primary_key"`
+	Starttime   time.Time
+	Description string
+	Rrule       string
+	Uid         string `sql:"index"`
+	Location    string
+	Endtime     time.Time
+	Url         string
+	Category    string
+	Tags        string
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+func (eventV1) TableName() string { return "events" }
+
+type eventExceptionV1 struct {
+	Id        int `sql:"AUTO_INCREMENT" gorm:"primary_key"`
+	EventId   int
+	Starttime time.Time
+}
+
+func (eventExceptionV1) TableName() string { return "event_exceptions" }
+
+type eventAuditV1 struct {
+	Id        int `sql:"AUTO_INCREMENT" gorm:"primary_key"`
+	EventId   int `sql:"index"`
+	Field     string
+	OldValue  string
+	NewValue  string
+	ChangedBy string
+	CreatedAt time.Time
+}
+
+func (eventAuditV1) TableName() string { return "event_audits" }
+
+type roleGrantV1 struct {
+	Id        int `sql:"AUTO_INCREMENT" gorm:"primary_key"`
+	Role      string
+	Hostmask  string
+	Account   string
+	GrantedBy string
+	CreatedAt time.Time
+}
+
+func (roleGrantV1) TableName() string { return "role_grants" }
+
+type reminderLogV1 struct {
+	Id          int `sql:"AUTO_INCREMENT" gorm:"primary_key"`
+	EventId     int `sql:"index"`
+	Starttime   time.Time
+	LeadMinutes int
+	CreatedAt   time.Time
+}
+
+func (reminderLogV1) TableName() string { return "reminder_logs" }
+
+type attendeeV1 struct {
+	Id        int `sql:"AUTO_INCREMENT" gorm:"primary_key"`
+	EventId   int `sql:"index"`
+	Nick      string
+	Account   string
+	CreatedAt time.Time
+}
+
+func (attendeeV1) TableName() string { return "attendees" }
+
+type outboxMailV1 struct {
+	Id          int `sql:"AUTO_INCREMENT" gorm:"primary_key"`
+	Sender      string
+	Recipients  string
+	Message     string `sql:"type:text"`
+	Attempts    int
+	NextAttempt time.Time `sql:"index"`
+	LastError   string
+	Failed      bool
+	CreatedAt   time.Time
+}
+
+func (outboxMailV1) TableName() string { return "outbox_mails" }
+
+var tablesV1 = []interface{}{&eventV1{}, &eventExceptionV1{}, &eventAuditV1{},
+	&roleGrantV1{}, &reminderLogV1{}, &attendeeV1{}, &outboxMailV1{}}
+
+// All migrations, ordered by version.
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "initial schema",
+		// completes the tables of databases created before the
+		// migrations were introduced.
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(tablesV1...).Error
+		},
+		// reverting would drop all events
+		Down: nil,
+	},
+	{
+		Version: 2,
+		Name:    "index event start times",
+		Up: func(tx *gorm.DB) error {
+			return tx.Model(&eventV1{}).AddIndex("idx_events_starttime", "starttime").Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Model(&eventV1{}).RemoveIndex("idx_events_starttime").Error
+		},
+	},
+}
@@ -0,0 +1,158 @@
+package her0ldbot
+
+import (
+	"github.com/gonium/her0ld"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// mkMigrator returns a migrator for a new SQLite database.
+func mkMigrator(t *testing.T) (*Migrator, string) {
+	file := filepath.Join(os.TempDir(), "her0ld-migrations-test.db")
+	_ = os.Remove(file)
+	cfg := her0ld.EventbotConfig{Database: her0ld.DatabaseSettings{
+		Dialect: her0ld.DB_SQLITE, DSN: file}}
+	db, err := ConnectDatabase(cfg)
+	if err != nil {
+		t.Fatalf("Failed to open database: %s", err.Error())
+	}
+	return NewMigrator(db, cfg), file
+}
+
+func expectVersion(t *testing.T, m *Migrator, expected int) {
+	if version, err := m.Version(); err != nil || version != expected {
+		t.Fatalf("Expected version %d, got %d (%v)", expected, version, err)
+	}
+}
+
+// removeBackups removes the copies of the database file and returns
+// their number.
+func removeBackups(file string) int {
+	backups, _ := filepath.Glob(file + ".v*.bak")
+	for _, backup := range backups {
+		os.Remove(backup)
+	}
+	return len(backups)
+}
+
+func TestMigrateUpDown(t *testing.T) {
+	m, file := mkMigrator(t)
+	defer m.Db.Close()
+	defer removeBackups(file)
+	expectVersion(t, m, 0)
+	applied, err := m.Up(0)
+	if err != nil || len(applied) != len(migrations) {
+		t.Fatalf("Expected %d migrations, got %v (%v)", len(migrations), applied, err)
+	}
+	expectVersion(t, m, m.Latest())
+	if n := removeBackups(file); n != 0 {
+		t.Fatalf("New database should not be copied, got %d copies", n)
+	}
+	status, err := m.Status()
+	if err != nil || len(status) != len(migrations) {
+		t.Fatalf("Unexpected status %v (%v)", status, err)
+	}
+	for _, s := range status {
+		if !s.Applied {
+			t.Fatalf("Migration not applied: %s", s)
+		}
+	}
+	if applied, err := m.Up(0); err != nil || len(applied) != 0 {
+		t.Fatalf("Applied migrations twice: %v (%v)", applied, err)
+	}
+
+	m.Db.Create(&Event{Description: "Treffen"})
+	if reverted, err := m.Down(1); err != nil || len(reverted) != 1 {
+		t.Fatalf("Expected one reverted migration, got %v (%v)", reverted, err)
+	}
+	expectVersion(t, m, 1)
+	if n := removeBackups(file); n != 1 {
+		t.Fatalf("Expected a copy of the database, got %d", n)
+	}
+	// the initial schema is never dropped
+	if reverted, err := m.Down(0); err == nil || len(reverted) != 0 {
+		t.Fatalf("Initial migration should not be reverted, got %v", reverted)
+	}
+	expectVersion(t, m, 1)
+	var count int
+	if m.Db.Model(&Event{}).Count(&count); count != 1 {
+		t.Fatalf("Events lost, expected 1, got %d", count)
+	}
+	if n := removeBackups(file); n != 0 {
+		t.Fatalf("Failed revert should not copy the database, got %d copies", n)
+	}
+	if _, err := m.Up(0); err != nil {
+		t.Fatalf("Failed to apply migration: %s", err.Error())
+	}
+	expectVersion(t, m, m.Latest())
+}
+
+// Databases created before the migrations get a version.
+func TestMigrateLegacyDatabase(t *testing.T) {
+	m, file := mkMigrator(t)
+	defer m.Db.Close()
+	defer removeBackups(file)
+	legacy := []interface{}{&Event{}, &EventException{}, &EventAudit{}, &RoleGrant{},
+		&ReminderLog{}, &Attendee{}, &OutboxMail{}}
+	if err := m.Db.AutoMigrate(legacy...).Error; err != nil {
+		t.Fatalf("Failed to create tables: %s", err.Error())
+	}
+	m.Db.Create(&Event{Description: "Treffen"})
+	if _, err := m.Up(0); err != nil {
+		t.Fatalf("Failed to migrate: %s", err.Error())
+	}
+	expectVersion(t, m, m.Latest())
+	var count int
+	m.Db.Model(&Event{}).Count(&count)
+	if count != 1 {
+		t.Fatalf("Migration lost events, %d left", count)
+	}
+	if n := removeBackups(file); n != 1 {
+		t.Fatalf("Expected a copy of the database, got %d", n)
+	}
+}
+
+// The migrations create all columns of the models.
+func TestMigrationsMatchModels(t *testing.T) {
+	m, file := mkMigrator(t)
+	defer m.Db.Close()
+	defer removeBackups(file)
+	if _, err := m.Up(0); err != nil {
+		t.Fatalf("Failed to migrate: %s", err.Error())
+	}
+	for _, model := range []interface{}{&Event{}, &EventException{}, &EventAudit{},
+		&RoleGrant{}, &ReminderLog{}, &Attendee{}, &OutboxMail{}} {
+		scope := m.Db.NewScope(model)
+		table := scope.TableName()
+		for _, field := range scope.Fields() {
+			if !field.IsNormal || field.IsIgnored {
+				continue
+			}
+			if !scope.Dialect().HasColumn(table, field.DBName) {
+				t.Fatalf("Column %s.%s is missing, add a migration", table, field.DBName)
+			}
+		}
+	}
+}
+
+func TestManualMigrations(t *testing.T) {
+	m, file := mkMigrator(t)
+	m.Db.Close()
+	cfg := her0ld.EventbotConfig{Database: her0ld.DatabaseSettings{
+		Dialect: her0ld.DB_SQLITE, DSN: file, ManualMigrations: true}}
+	if _, err := OpenDatabase(cfg); err == nil {
+		t.Fatalf("Opening an outdated database should fail")
+	}
+	cfg.Database.ManualMigrations = false
+	db, err := OpenDatabase(cfg)
+	if err != nil {
+		t.Fatalf("Failed to open database: %s", err.Error())
+	}
+	db.Close()
+	cfg.Database.ManualMigrations = true
+	if db, err = OpenDatabase(cfg); err != nil {
+		t.Fatalf("Failed to open migrated database: %s", err.Error())
+	}
+	db.Close()
+}
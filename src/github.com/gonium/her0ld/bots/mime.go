@@ -0,0 +1,231 @@
+package her0ldbot
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+const (
+	MAIL_TEXT_CONTENT_TYPE = "text/plain; charset=utf-8"
+	MAIL_HTML_CONTENT_TYPE = "text/html; charset=utf-8"
+	// Line length of base64 encoded attachments, see RFC 2045.
+	MAIL_BASE64_LINE_LENGTH = 76
+)
+
+/* A Mail is a message composed by the bot. It is rendered as plain
+ * text, as multipart/alternative if it has an HTML version and as
+ * multipart/mixed if it has attachments. */
+type Mail struct {
+	// Addresses like "her0ld <bot@example.com>" or "bot@example.com".
+	From string
+	To   []string
+	// The subject may contain any UTF-8 text, it is encoded as needed.
+	Subject string
+	// The current time if zero.
+	Date time.Time
+	// Generated from the domain of From if empty.
+	MessageId   string
+	Text        string
+	HTML        string
+	Attachments []MailAttachment
+}
+
+/* A MailAttachment is a file attached to a mail. */
+type MailAttachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// Recipients returns the bare addresses of the recipients, for the
+// SMTP envelope.
+func (m *Mail) Recipients() ([]string, error) {
+	var retval []string
+	for _, to := range m.To {
+		address, err := mail.ParseAddress(to)
+		if err != nil {
+			return nil, fmt.Errorf("invalid recipient %q - %s", to, err.Error())
+		}
+		retval = append(retval, address.Address)
+	}
+	return retval, nil
+}
+
+// newMessageId returns a unique Message-ID for a mail sent from the
+// given address.
+func newMessageId(from string) string {
+	domain := "her0ld"
+	if at := strings.LastIndex(from, "@"); at >= 0 && at < len(from)-1 {
+		domain = from[at+1:]
+	}
+	random := make([]byte, 12)
+	rand.Read(random)
+	return fmt.Sprintf("<%d.%s@%s>", time.Now().Unix(), hex.EncodeToString(random),
+		domain)
+}
+
+// encodeHeader encodes a header value according to RFC 2047 if it is
+// not plain ASCII. Long values are folded between the encoded words.
+func encodeHeader(value string) string {
+	return strings.Replace(mime.QEncoding.Encode("utf-8", value), "?= =?",
+		"?=\r\n =?", -1)
+}
+
+// quotedPrintable encodes text with CRLF line endings.
+func quotedPrintable(text string) []byte {
+	var buf bytes.Buffer
+	w := quotedprintable.NewWriter(&buf)
+	w.Write([]byte(text))
+	w.Close()
+	return buf.Bytes()
+}
+
+// base64Lines encodes data in lines of MAIL_BASE64_LINE_LENGTH.
+func base64Lines(data []byte) []byte {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	var buf bytes.Buffer
+	for len(encoded) > MAIL_BASE64_LINE_LENGTH {
+		buf.WriteString(encoded[:MAIL_BASE64_LINE_LENGTH] + "\r\n")
+		encoded = encoded[MAIL_BASE64_LINE_LENGTH:]
+	}
+	buf.WriteString(encoded + "\r\n")
+	return buf.Bytes()
+}
+
+/* An entity is the header and the encoded content of a MIME part. */
+type entity struct {
+	Header  textproto.MIMEHeader
+	Content []byte
+}
+
+func textEntity(contentType, text string) entity {
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", contentType)
+	header.Set("Content-Transfer-Encoding", "quoted-printable")
+	return entity{Header: header, Content: quotedPrintable(text)}
+}
+
+// multipartEntity combines the parts to a multipart entity of the given
+// subtype, e.g. "alternative".
+func multipartEntity(subtype string, parts []entity) (entity, error) {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	for _, part := range parts {
+		pw, err := w.CreatePart(part.Header)
+		if err != nil {
+			return entity{}, err
+		}
+		if _, err := pw.Write(part.Content); err != nil {
+			return entity{}, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return entity{}, err
+	}
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", mime.FormatMediaType("multipart/"+subtype,
+		map[string]string{"boundary": w.Boundary()}))
+	return entity{Header: header, Content: buf.Bytes()}, nil
+}
+
+// body returns the top-level entity of the mail.
+func (m *Mail) body() (entity, error) {
+	body := textEntity(MAIL_TEXT_CONTENT_TYPE, m.Text)
+	if m.HTML != "" {
+		var err error
+		body, err = multipartEntity("alternative", []entity{body,
+			textEntity(MAIL_HTML_CONTENT_TYPE, m.HTML)})
+		if err != nil {
+			return body, err
+		}
+	}
+	if len(m.Attachments) == 0 {
+		return body, nil
+	}
+	parts := []entity{body}
+	for _, a := range m.Attachments {
+		header := textproto.MIMEHeader{}
+		params := map[string]string{"name": a.Filename}
+		mediatype, existing, err := mime.ParseMediaType(a.ContentType)
+		if err != nil {
+			return body, fmt.Errorf("invalid content type of %s - %s", a.Filename,
+				err.Error())
+		}
+		for k, v := range existing {
+			params[k] = v
+		}
+		header.Set("Content-Type", mime.FormatMediaType(mediatype, params))
+		header.Set("Content-Disposition", mime.FormatMediaType("attachment",
+			map[string]string{"filename": a.Filename}))
+		header.Set("Content-Transfer-Encoding", "base64")
+		parts = append(parts, entity{Header: header, Content: base64Lines(a.Data)})
+	}
+	return multipartEntity("mixed", parts)
+}
+
+// WriteTo renders the message according to RFC 5322 and MIME.
+func (m *Mail) WriteTo(out io.Writer) (int64, error) {
+	from, err := mail.ParseAddress(m.From)
+	if err != nil {
+		return 0, fmt.Errorf("invalid sender %q - %s", m.From, err.Error())
+	}
+	if len(m.To) == 0 {
+		return 0, fmt.Errorf("no recipients")
+	}
+	var to []string
+	for _, recipient := range m.To {
+		address, err := mail.ParseAddress(recipient)
+		if err != nil {
+			return 0, fmt.Errorf("invalid recipient %q - %s", recipient, err.Error())
+		}
+		to = append(to, address.String())
+	}
+	date := m.Date
+	if date.IsZero() {
+		date = time.Now()
+	}
+	if m.MessageId == "" {
+		m.MessageId = newMessageId(from.Address)
+	}
+	body, err := m.body()
+	if err != nil {
+		return 0, err
+	}
+
+	var buf bytes.Buffer
+	header := func(name, value string) {
+		buf.WriteString(name + ": " + value + "\r\n")
+	}
+	header("From", from.String())
+	header("To", strings.Join(to, ",\r\n "))
+	header("Subject", encodeHeader(m.Subject))
+	header("Date", date.Format(time.RFC1123Z))
+	header("Message-ID", m.MessageId)
+	header("MIME-Version", "1.0")
+	for _, name := range []string{"Content-Type", "Content-Transfer-Encoding"} {
+		if value := body.Header.Get(name); value != "" {
+			header(name, value)
+		}
+	}
+	buf.WriteString("\r\n")
+	buf.Write(body.Content)
+	return buf.WriteTo(out)
+}
+
+// Bytes returns the rendered message, see WriteTo.
+func (m *Mail) Bytes() ([]byte, error) {
+	var buf bytes.Buffer
+	_, err := m.WriteTo(&buf)
+	return buf.Bytes(), err
+}
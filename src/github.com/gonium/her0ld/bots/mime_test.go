@@ -0,0 +1,118 @@
+package her0ldbot
+
+import (
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMailPlainText(t *testing.T) {
+	m := &Mail{
+		From:    "her0ld <bot@example.com>",
+		To:      []string{"a@example.com", "Jörg <b@example.com>"},
+		Subject: "Grillen am Übermorgen",
+		Date:    time.Date(2026, 10, 14, 10, 0, 0, 0, time.UTC),
+		Text:    "Bratwürste für alle\n",
+	}
+	raw, err := m.Bytes()
+	if err != nil {
+		t.Fatalf("Failed to render mail: %s", err.Error())
+	}
+	if strings.Contains(string(raw), "Ü") || strings.Contains(string(raw), "\n\n") {
+		t.Fatalf("Mail not encoded:\n%s", raw)
+	}
+	msg, err := mail.ReadMessage(strings.NewReader(string(raw)))
+	if err != nil {
+		t.Fatalf("Invalid mail: %s", err.Error())
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil || subject != m.Subject {
+		t.Fatalf("Unexpected subject %q", msg.Header.Get("Subject"))
+	}
+	to, err := msg.Header.AddressList("To")
+	if err != nil || len(to) != 2 || to[1].Name != "Jörg" {
+		t.Fatalf("Unexpected recipients %q", msg.Header.Get("To"))
+	}
+	if id := msg.Header.Get("Message-ID"); !strings.HasSuffix(id, "@example.com>") {
+		t.Fatalf("Unexpected Message-ID %q", id)
+	}
+	if date, err := msg.Header.Date(); err != nil || !date.Equal(m.Date) {
+		t.Fatalf("Unexpected date %q", msg.Header.Get("Date"))
+	}
+	if msg.Header.Get("Content-Transfer-Encoding") != "quoted-printable" {
+		t.Fatalf("Unexpected encoding %q", msg.Header.Get("Content-Transfer-Encoding"))
+	}
+	body, _ := ioutil.ReadAll(msg.Body)
+	if string(body) != "Bratw=C3=BCrste f=C3=BCr alle\r\n" {
+		t.Fatalf("Unexpected body %q", body)
+	}
+	recipients, err := m.Recipients()
+	if err != nil || strings.Join(recipients, " ") != "a@example.com b@example.com" {
+		t.Fatalf("Unexpected envelope recipients %v", recipients)
+	}
+}
+
+func TestMailMultipart(t *testing.T) {
+	m := &Mail{
+		From:    "bot@example.com",
+		To:      []string{"a@example.com"},
+		Subject: "Events",
+		Text:    "text",
+		HTML:    "<p>html</p>",
+		Attachments: []MailAttachment{{Filename: "events.ics",
+			ContentType: ICAL_CONTENT_TYPE, Data: []byte("BEGIN:VCALENDAR\r\n")}},
+	}
+	raw, err := m.Bytes()
+	if err != nil {
+		t.Fatalf("Failed to render mail: %s", err.Error())
+	}
+	msg, err := mail.ReadMessage(strings.NewReader(string(raw)))
+	if err != nil {
+		t.Fatalf("Invalid mail: %s", err.Error())
+	}
+	mediatype, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediatype != "multipart/mixed" {
+		t.Fatalf("Unexpected content type %q", msg.Header.Get("Content-Type"))
+	}
+	mixed := multipart.NewReader(msg.Body, params["boundary"])
+	body, err := mixed.NextPart()
+	if err != nil {
+		t.Fatalf("Missing body: %v", err)
+	}
+	mediatype, params, _ = mime.ParseMediaType(body.Header.Get("Content-Type"))
+	if mediatype != "multipart/alternative" {
+		t.Fatalf("Unexpected body type %q", body.Header.Get("Content-Type"))
+	}
+	alternative := multipart.NewReader(body, params["boundary"])
+	for _, expected := range []struct{ contentType, content string }{
+		{MAIL_TEXT_CONTENT_TYPE, "text"},
+		{MAIL_HTML_CONTENT_TYPE, "<p>html</p>"},
+	} {
+		part, err := alternative.NextPart()
+		if err != nil || part.Header.Get("Content-Type") != expected.contentType {
+			t.Fatalf("Unexpected alternative %v %v", part, err)
+		}
+		// the reader decodes quoted-printable
+		content, _ := ioutil.ReadAll(part)
+		if string(content) != expected.content {
+			t.Fatalf("Unexpected content %q", content)
+		}
+	}
+	attachment, err := mixed.NextPart()
+	if err != nil || attachment.FileName() != "events.ics" ||
+		attachment.Header.Get("Content-Transfer-Encoding") != "base64" {
+		t.Fatalf("Unexpected attachment %v %v", attachment, err)
+	}
+	if _, err := mixed.NextPart(); err == nil {
+		t.Fatalf("Unexpected additional part")
+	}
+
+	m.To = []string{"not an address"}
+	if _, err := m.Bytes(); err == nil {
+		t.Fatalf("Invalid recipient should fail")
+	}
+}
@@ -0,0 +1,137 @@
+package her0ldbot
+
+import (
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"log"
+	"strings"
+	"time"
+)
+
+const (
+	// Timeout of the connection to the SMTP server.
+	EVENTBOT_MAIL_TIMEOUT = time.Minute
+	// Failed mails are retried after these delays, doubling with every
+	// attempt.
+	EVENTBOT_OUTBOX_INITIAL_DELAY = time.Minute
+	EVENTBOT_OUTBOX_MAX_DELAY     = 2 * time.Hour
+	EVENTBOT_OUTBOX_MAX_ATTEMPTS  = 10
+)
+
+/* An OutboxMail is a rendered mail waiting for its delivery. Delivered
+ * mails are removed, mails that failed too often are kept with Failed
+ * set. */
+type OutboxMail struct {
+	Id         int `sql:"AUTO_INCREMENT" gorm:"primary_key"`
+	Sender     string
+	Recipients string
+	Message    string `sql:"type:text"`
+	Attempts   int
+	// The time of the next attempt.
+	NextAttempt time.Time `sql:"index"`
+	LastError   string
+	Failed      bool
+	CreatedAt   time.Time
+}
+
+// RecipientList returns the recipients of the mail.
+func (o *OutboxMail) RecipientList() []string {
+	return strings.Split(o.Recipients, ",")
+}
+
+// outboxDelay returns the delay after the given number of failed
+// attempts.
+func outboxDelay(attempts int) time.Duration {
+	delay := EVENTBOT_OUTBOX_INITIAL_DELAY
+	for i := 1; i < attempts && delay < EVENTBOT_OUTBOX_MAX_DELAY; i++ {
+		delay *= 2
+	}
+	if delay > EVENTBOT_OUTBOX_MAX_DELAY {
+		delay = EVENTBOT_OUTBOX_MAX_DELAY
+	}
+	return delay
+}
+
+// QueueMail renders the mail and stores it in the outbox.
+func (b *EventBot) QueueMail(m *Mail) (*OutboxMail, error) {
+	recipients, err := m.Recipients()
+	if err != nil {
+		return nil, err
+	}
+	msg, err := m.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	entry := &OutboxMail{
+		Sender:      m.From,
+		Recipients:  strings.Join(recipients, ","),
+		Message:     string(msg),
+		NextAttempt: time.Now(),
+	}
+	if err := b.Db.Create(entry).Error; err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// deliver attempts to send a mail of the outbox. Failed mails are
+// rescheduled, until EventBot.MailMaxAttempts attempts failed. The entry
+// is read again, as another attempt may have handled it meanwhile: a
+// delivered mail is skipped, a mail that is not due returns its last
+// error.
+func (b *EventBot) deliver(entry *OutboxMail) error {
+	b.outboxMutex.Lock()
+	defer b.outboxMutex.Unlock()
+	var current OutboxMail
+	if err := b.Db.First(&current, entry.Id).Error; err == gorm.ErrRecordNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	*entry = current
+	if entry.Failed || entry.NextAttempt.After(time.Now()) {
+		return fmt.Errorf("%s", entry.LastError)
+	}
+	err := b.MailTransport.Deliver(entry.Sender, entry.RecipientList(),
+		[]byte(entry.Message))
+	if err == nil {
+		log.Printf("Eventbot: Sent mail %d to %s", entry.Id, entry.Recipients)
+		return b.Db.Delete(entry).Error
+	}
+	entry.Attempts++
+	entry.LastError = err.Error()
+	if entry.Attempts >= b.MailMaxAttempts {
+		entry.Failed = true
+		log.Printf("Eventbot: Giving up mail %d to %s after %d attempts - %s",
+			entry.Id, entry.Recipients, entry.Attempts, err.Error())
+	} else {
+		entry.NextAttempt = time.Now().Add(outboxDelay(entry.Attempts))
+		log.Printf("Eventbot: Failed to send mail %d to %s, retrying at %s - %s",
+			entry.Id, entry.Recipients,
+			entry.NextAttempt.In(b.TimeLocation).Format(EVENTBOT_TIME_FORMAT),
+			err.Error())
+	}
+	b.Db.Save(entry)
+	return err
+}
+
+// SendQueued stores the mail in the outbox and attempts to send it
+// immediately. If this fails, the mail is retried later unless the
+// returned entry is marked as failed.
+func (b *EventBot) SendQueued(m *Mail) (*OutboxMail, error) {
+	entry, err := b.QueueMail(m)
+	if err != nil {
+		return nil, fmt.Errorf("failed to queue mail - %s", err.Error())
+	}
+	return entry, b.deliver(entry)
+}
+
+// DeliverOutbox retries the mails of the outbox that are due.
+func (b *EventBot) DeliverOutbox() {
+	var due []OutboxMail
+	b.Db.Where("failed = ? and next_attempt <= ?", false, time.Now()).
+		Order("id").Find(&due)
+	for i := range due {
+		b.deliver(&due[i])
+	}
+}
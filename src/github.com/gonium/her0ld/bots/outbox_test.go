@@ -0,0 +1,287 @@
+package her0ldbot
+
+import (
+	"bufio"
+	"errors"
+	"github.com/gonium/her0ld"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+/* A testTransport records the delivered mails and fails while err is
+ * set. */
+type testTransport struct {
+	err       error
+	attempts  int
+	delivered []string
+}
+
+func (t *testTransport) Deliver(from string, to []string, msg []byte) error {
+	t.attempts++
+	if t.err != nil {
+		return t.err
+	}
+	t.delivered = append(t.delivered, strings.Join(to, ",")+"\n"+string(msg))
+	return nil
+}
+
+func testMail() *Mail {
+	return &Mail{From: "bot@example.com", To: []string{"a@example.com", "b@example.com"},
+		Subject: "Test", Text: "Hello"}
+}
+
+func countOutbox(bot *EventBot) (pending, failed int) {
+	bot.Db.Model(&OutboxMail{}).Where("failed = ?", false).Count(&pending)
+	bot.Db.Model(&OutboxMail{}).Where("failed = ?", true).Count(&failed)
+	return pending, failed
+}
+
+func TestOutboxDelay(t *testing.T) {
+	expected := []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute}
+	for i, delay := range expected {
+		if outboxDelay(i+1) != delay {
+			t.Fatalf("Attempt %d: expected %s, got %s", i+1, delay, outboxDelay(i+1))
+		}
+	}
+	if outboxDelay(100) != EVENTBOT_OUTBOX_MAX_DELAY {
+		t.Fatalf("Unexpected maximum delay %s", outboxDelay(100))
+	}
+}
+
+func TestOutbox(t *testing.T) {
+	bot := MkEventBot()
+	transport := &testTransport{}
+	bot.MailTransport = transport
+	if _, err := bot.SendQueued(testMail()); err != nil {
+		t.Fatalf("Failed to send mail: %s", err.Error())
+	}
+	if len(transport.delivered) != 1 ||
+		!strings.HasPrefix(transport.delivered[0], "a@example.com,b@example.com\n") {
+		t.Fatalf("Unexpected delivery: %v", transport.delivered)
+	}
+	if pending, failed := countOutbox(bot); pending != 0 || failed != 0 {
+		t.Fatalf("Sent mail should be removed from the outbox: %d %d", pending, failed)
+	}
+
+	// the server is down
+	transport.err = errors.New("connection refused")
+	entry, err := bot.SendQueued(testMail())
+	if err == nil || entry.Failed || entry.Attempts != 1 {
+		t.Fatalf("Failed delivery should be retried: %v %#v", err, entry)
+	}
+	if delay := entry.NextAttempt.Sub(time.Now()); delay < 50*time.Second || delay > time.Minute {
+		t.Fatalf("Unexpected retry delay %s", delay)
+	}
+	// not due yet
+	bot.DeliverOutbox()
+	if transport.attempts != 2 {
+		t.Fatalf("Mail should not be retried before it is due")
+	}
+	bot.Db.Model(entry).UpdateColumn("next_attempt", time.Now().Add(-time.Second))
+	transport.err = nil
+	bot.DeliverOutbox()
+	if len(transport.delivered) != 2 {
+		t.Fatalf("Due mail should be retried")
+	}
+	if pending, _ := countOutbox(bot); pending != 0 {
+		t.Fatalf("Retried mail should be removed from the outbox")
+	}
+
+	// give up after the maximum number of attempts
+	bot.MailMaxAttempts = 2
+	transport.err = errors.New("mailbox unavailable")
+	entry, _ = bot.SendQueued(testMail())
+	bot.Db.Model(entry).UpdateColumn("next_attempt", time.Now().Add(-time.Second))
+	bot.DeliverOutbox()
+	bot.DeliverOutbox()
+	var stored OutboxMail
+	bot.Db.First(&stored, entry.Id)
+	if !stored.Failed || stored.Attempts != 2 || stored.LastError != "mailbox unavailable" {
+		t.Fatalf("Mail should have failed: %#v", stored)
+	}
+}
+
+func TestMailreminderFailure(t *testing.T) {
+	bot := MkEventBot()
+	transport := &testTransport{err: errors.New("connection refused")}
+	bot.MailTransport = transport
+	bot.MailSender.FromAddress = "bot@example.com"
+	bot.MailJobs = []her0ld.MailJob{{Name: "today", Recipients: []string{"a@example.com"},
+		Window: her0ld.MAIL_WINDOW_TODAY, SendEmpty: true}}
+	msg := InboundMessage{
+		Target:  "#channel",
+		Nick:    testOwnerNick,
+		Source:  testOwnerNick + "!user@owner.example.com",
+		Message: EVENTBOT_PREFIX + " " + EVENTBOT_CMD_MAILREMINDER,
+	}
+	followup := collectFollowups(t, &msg)
+	// the answers follow after the delivery
+	if response, _ := bot.ProcessChannelEvent(msg); len(response) != 0 {
+		t.Fatalf("Unexpected immediate answer: %v", response)
+	}
+	response := followup()
+	expected := "Failed to send the reminder mail, will retry: connection refused"
+	if len(response) != 1 || response[0].Message != expected {
+		t.Fatalf("Unexpected answer: %v", response)
+	}
+	bot.MailMaxAttempts = 1
+	bot.ProcessChannelEvent(msg)
+	response = followup()
+	expected = "Failed to send the reminder mail: connection refused"
+	if len(response) != 1 || response[0].Message != expected {
+		t.Fatalf("Unexpected answer: %v", response)
+	}
+	transport.err = nil
+	bot.ProcessChannelEvent(msg)
+	response = followup()
+	if len(response) != 1 || response[0].Message != EVENTBOT_MAILREMINDER_REPLY {
+		t.Fatalf("Unexpected answer: %v", response)
+	}
+}
+
+// A mail of the outbox is delivered once, even if a stale copy of the
+// entry is delivered again, e.g. by DeliverOutbox during SendQueued.
+func TestDeliverStaleEntry(t *testing.T) {
+	bot := MkEventBot()
+	transport := &testTransport{}
+	bot.MailTransport = transport
+	entry, err := bot.QueueMail(testMail())
+	if err != nil {
+		t.Fatalf("Failed to queue mail: %s", err.Error())
+	}
+	stale := *entry
+	if err := bot.deliver(entry); err != nil {
+		t.Fatalf("Delivery failed: %s", err.Error())
+	}
+	if err := bot.deliver(&stale); err != nil || len(transport.delivered) != 1 {
+		t.Fatalf("Delivered mail sent again: %d deliveries (%v)",
+			len(transport.delivered), err)
+	}
+
+	// a failed attempt is not overwritten by a stale copy
+	transport.err = errors.New("connection refused")
+	entry, _ = bot.QueueMail(testMail())
+	stale = *entry
+	bot.deliver(entry)
+	if err := bot.deliver(&stale); err == nil || stale.Attempts != 1 {
+		t.Fatalf("Rescheduled mail attempted again: %d attempts (%v)", stale.Attempts, err)
+	}
+	if len(transport.delivered) != 1 {
+		t.Fatalf("Unexpected deliveries: %d", len(transport.delivered))
+	}
+}
+
+// smtpServer accepts a single SMTP session and returns the commands and
+// the message received. QUIT is answered with quitReply, if given.
+func smtpServer(t *testing.T, extensions []string, quitReply string) (port int, received chan []string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err.Error())
+	}
+	received = make(chan []string, 1)
+	go func() {
+		defer listener.Close()
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var lines []string
+		r := bufio.NewReader(conn)
+		reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+		reply("220 localhost ESMTP")
+		data := false
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				break
+			}
+			line = strings.TrimRight(line, "\r\n")
+			lines = append(lines, line)
+			switch {
+			case data && line == ".":
+				data = false
+				reply("250 queued")
+			case data:
+			case strings.HasPrefix(line, "EHLO"):
+				reply("250-localhost")
+				for _, ext := range extensions {
+					reply("250-" + ext)
+				}
+				reply("250 HELP")
+			case line == "DATA":
+				data = true
+				reply("354 go ahead")
+			case line == "QUIT":
+				if quitReply == "" {
+					quitReply = "221 bye"
+				}
+				reply(quitReply)
+				received <- lines
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+		received <- lines
+	}()
+	port = listener.Addr().(*net.TCPAddr).Port
+	return port, received
+}
+
+func TestSMTPDelivery(t *testing.T) {
+	port, received := smtpServer(t, nil, "")
+	ms := NewMailSender("her0ld <bot@example.com>", "", "", "127.0.0.1", port,
+		her0ld.MAIL_TLS_NONE)
+	if err := ms.SendMail(testMail()); err != nil {
+		t.Fatalf("Failed to send mail: %s", err.Error())
+	}
+	session := strings.Join(<-received, "\n")
+	for _, expected := range []string{"MAIL FROM:<bot@example.com>",
+		"RCPT TO:<a@example.com>", "RCPT TO:<b@example.com>", "Subject: Test", "Hello"} {
+		if !strings.Contains(session, expected) {
+			t.Fatalf("Missing %q in SMTP session:\n%s", expected, session)
+		}
+	}
+
+	// by default, STARTTLS is only used if the server offers it
+	port, received = smtpServer(t, nil, "")
+	ms = NewMailSender("bot@example.com", "", "", "127.0.0.1", port, "")
+	if err := ms.SendMail(testMail()); err != nil {
+		t.Fatalf("Failed to send mail without STARTTLS: %s", err.Error())
+	}
+	<-received
+	port, received = smtpServer(t, []string{"STARTTLS"}, "")
+	ms = NewMailSender("bot@example.com", "", "", "127.0.0.1", port, "")
+	ms.SendMail(testMail())
+	if session := strings.Join(<-received, "\n"); !strings.Contains(session, "STARTTLS") ||
+		strings.Contains(session, "MAIL FROM") {
+		t.Fatalf("Offered STARTTLS not used:\n%s", session)
+	}
+
+	// the starttls mode requires it
+	port, received = smtpServer(t, nil, "")
+	ms = NewMailSender("bot@example.com", "", "", "127.0.0.1", port,
+		her0ld.MAIL_TLS_STARTTLS)
+	if err := ms.SendMail(testMail()); err == nil ||
+		!strings.Contains(err.Error(), "STARTTLS") {
+		t.Fatalf("Delivery without STARTTLS should fail: %v", err)
+	}
+	if session := strings.Join(<-received, "\n"); strings.Contains(session, "MAIL FROM") {
+		t.Fatalf("Mail sent without STARTTLS:\n%s", session)
+	}
+}
+
+// Once the server accepted the message, a failed QUIT must not make the
+// outbox send the mail again.
+func TestSMTPQuitFailure(t *testing.T) {
+	port, received := smtpServer(t, nil, "421 closing connection")
+	ms := NewMailSender("bot@example.com", "", "", "127.0.0.1", port,
+		her0ld.MAIL_TLS_NONE)
+	if err := ms.SendMail(testMail()); err != nil {
+		t.Fatalf("Accepted mail reported as failed: %s", err.Error())
+	}
+	<-received
+}
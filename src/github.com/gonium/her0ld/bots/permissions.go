@@ -0,0 +1,197 @@
+package her0ldbot
+
+import (
+	"fmt"
+	"github.com/gonium/her0ld"
+	"github.com/jinzhu/gorm"
+	"strings"
+	"time"
+)
+
+/* Roles are ordered: every role includes the permissions of the roles
+ * before it. */
+type Role int
+
+const (
+	RoleUser Role = iota
+	RoleEventEditor
+	RoleAdmin
+	RoleOwner
+)
+
+var roleNames = []string{"user", "event-editor", "admin", "owner"}
+
+func (r Role) String() string {
+	if r < RoleUser || r > RoleOwner {
+		return fmt.Sprintf("role(%d)", int(r))
+	}
+	return roleNames[r]
+}
+
+func ParseRole(name string) (Role, error) {
+	for idx, rolename := range roleNames {
+		if strings.EqualFold(rolename, name) {
+			return Role(idx), nil
+		}
+	}
+	return RoleUser, fmt.Errorf("unknown role %q, use one of %s", name,
+		strings.Join(roleNames, ", "))
+}
+
+/* A RoleGrant binds a role to a hostmask (nick!user@host, may contain
+ * the wildcards * and ?) or to a services account. Grants from the
+ * configuration file are kept in memory, grants made at runtime are
+ * stored in the database. */
+type RoleGrant struct {
+	Id        int `sql:"AUTO_INCREMENT" gorm:"primary_key"`
+	Role      string
+	Hostmask  string
+	Account   string
+	GrantedBy string
+	CreatedAt time.Time
+}
+
+func (g RoleGrant) String() string {
+	if g.Account != "" {
+		return fmt.Sprintf("%s: account %s", g.Role, g.Account)
+	}
+	return fmt.Sprintf("%s: %s", g.Role, g.Hostmask)
+}
+
+// matches reports whether the grant applies to the sender of msg.
+func (g RoleGrant) matches(msg InboundMessage) bool {
+	if g.Account != "" {
+		return msg.Account != "" && strings.EqualFold(g.Account, msg.Account)
+	}
+	return g.Hostmask != "" && MatchMask(g.Hostmask, msg.Source)
+}
+
+/* Permissions determine the role of a message sender. */
+type Permissions struct {
+	DefaultRole Role
+	Db          *gorm.DB
+	static      []RoleGrant
+}
+
+// NewPermissions creates the permissions from the role bindings of the
+// configuration. Runtime grants are stored in db, which may be nil.
+func NewPermissions(cfg her0ld.GeneralConfig, db *gorm.DB) (*Permissions, error) {
+	p := &Permissions{DefaultRole: RoleUser, Db: db}
+	if cfg.DefaultRole != "" {
+		role, err := ParseRole(cfg.DefaultRole)
+		if err != nil {
+			return nil, err
+		}
+		p.DefaultRole = role
+	}
+	for _, binding := range cfg.Roles {
+		role, err := ParseRole(binding.Role)
+		if err != nil {
+			return nil, err
+		}
+		if binding.Hostmask == "" && binding.Account == "" {
+			return nil, fmt.Errorf("role binding for %s needs a hostmask or an account",
+				role)
+		}
+		p.static = append(p.static, RoleGrant{
+			Role:     role.String(),
+			Hostmask: binding.Hostmask,
+			Account:  binding.Account,
+		})
+	}
+	return p, nil
+}
+
+// Grants returns the grants from the configuration followed by the
+// grants stored in the database.
+func (p *Permissions) Grants() []RoleGrant {
+	grants := append([]RoleGrant{}, p.static...)
+	if p.Db != nil {
+		var stored []RoleGrant
+		p.Db.Order("id").Find(&stored)
+		grants = append(grants, stored...)
+	}
+	return grants
+}
+
+// RoleOf returns the highest role granted to the sender of msg.
+func (p *Permissions) RoleOf(msg InboundMessage) Role {
+	if p == nil {
+		return RoleUser
+	}
+	role := p.DefaultRole
+	for _, grant := range p.Grants() {
+		if !grant.matches(msg) {
+			continue
+		}
+		if granted, err := ParseRole(grant.Role); err == nil && granted > role {
+			role = granted
+		}
+	}
+	return role
+}
+
+// Grant stores a new role binding for a hostmask or an account.
+func (p *Permissions) Grant(role Role, hostmask, account, grantedBy string) error {
+	if p.Db == nil {
+		return fmt.Errorf("no database configured")
+	}
+	grant := RoleGrant{
+		Role:      role.String(),
+		Hostmask:  hostmask,
+		Account:   account,
+		GrantedBy: grantedBy,
+	}
+	return p.Db.Create(&grant).Error
+}
+
+// Revoke removes all stored grants for the given hostmask or account
+// and returns them. Grants from the configuration cannot be revoked.
+func (p *Permissions) Revoke(hostmask, account string) ([]RoleGrant, error) {
+	if p.Db == nil {
+		return nil, fmt.Errorf("no database configured")
+	}
+	var grants []RoleGrant
+	if account != "" {
+		p.Db.Where("lower(account) = ?", strings.ToLower(account)).Find(&grants)
+	} else {
+		p.Db.Where("hostmask = ?", hostmask).Find(&grants)
+	}
+	for _, grant := range grants {
+		if err := p.Db.Delete(&grant).Error; err != nil {
+			return nil, err
+		}
+	}
+	return grants, nil
+}
+
+// MatchMask matches an IRC hostmask against a pattern. The pattern may
+// contain * (any number of characters) and ? (one character), the
+// comparison ignores case.
+func MatchMask(pattern, s string) bool {
+	return matchMask([]rune(strings.ToLower(pattern)), []rune(strings.ToLower(s)))
+}
+
+func matchMask(pattern, s []rune) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := 0; i <= len(s); i++ {
+				if matchMask(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+		default:
+			if len(s) == 0 || pattern[0] != s[0] {
+				return false
+			}
+		}
+		pattern, s = pattern[1:], s[1:]
+	}
+	return len(s) == 0
+}
@@ -0,0 +1,117 @@
+package her0ldbot
+
+import (
+	"github.com/gonium/her0ld"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func mkTestPermissions(cfg her0ld.GeneralConfig) *Permissions {
+	file := filepath.Join(os.TempDir(), "her0ld-permissions-test.db")
+	_ = os.Remove(file)
+	db, err := OpenDatabase(her0ld.EventbotConfig{DBFile: file})
+	if err != nil {
+		panic(err)
+	}
+	p, err := NewPermissions(cfg, db)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+func TestMatchMask(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		expected   bool
+	}{
+		{"nick!user@host", "nick!user@host", true},
+		{"Nick!User@Host", "nick!user@host", true},
+		{"nick!*@host", "nick!anybody@host", true},
+		{"*!*@*.example.com", "foo!bar@a.example.com", true},
+		{"*!*@*.example.com", "foo!bar@example.com", false},
+		{"nick?!*@*", "nick1!u@h", true},
+		{"nick?!*@*", "nick!u@h", false},
+		{"nick!*@host", "nick!user@host.evil", false},
+		{"*", "", true},
+	}
+	for _, test := range tests {
+		if MatchMask(test.pattern, test.s) != test.expected {
+			t.Fatalf("MatchMask(%q, %q) should be %t", test.pattern, test.s,
+				test.expected)
+		}
+	}
+}
+
+func TestParseRole(t *testing.T) {
+	for _, role := range []Role{RoleUser, RoleEventEditor, RoleAdmin, RoleOwner} {
+		parsed, err := ParseRole(role.String())
+		if err != nil || parsed != role {
+			t.Fatalf("Role %s did not survive parsing: %v, %v", role, parsed, err)
+		}
+	}
+	if _, err := ParseRole("superuser"); err == nil {
+		t.Fatalf("Unknown role should be rejected")
+	}
+}
+
+func TestRoleOf(t *testing.T) {
+	p := mkTestPermissions(her0ld.GeneralConfig{
+		Roles: []her0ld.RoleBinding{
+			{Role: "owner", Account: "boss"},
+			{Role: "admin", Hostmask: "admin!*@trusted.example.com"},
+		},
+	})
+	tests := []struct {
+		msg      InboundMessage
+		expected Role
+	}{
+		{InboundMessage{Source: "someone!u@h"}, RoleUser},
+		{InboundMessage{Source: "admin!u@trusted.example.com"}, RoleAdmin},
+		{InboundMessage{Source: "admin!u@spoofed.example.com"}, RoleUser},
+		{InboundMessage{Source: "x!u@h", Account: "Boss"}, RoleOwner},
+		// the account wins over a lower hostmask binding
+		{InboundMessage{Source: "admin!u@trusted.example.com", Account: "boss"}, RoleOwner},
+	}
+	for _, test := range tests {
+		if role := p.RoleOf(test.msg); role != test.expected {
+			t.Fatalf("%s (account %q) should have role %s, got %s",
+				test.msg.Source, test.msg.Account, test.expected, role)
+		}
+	}
+	var nilPermissions *Permissions
+	if nilPermissions.RoleOf(tests[1].msg) != RoleUser {
+		t.Fatalf("Without permissions, everybody should be a user")
+	}
+}
+
+func TestGrantRevoke(t *testing.T) {
+	p := mkTestPermissions(her0ld.GeneralConfig{})
+	msg := InboundMessage{Source: "editor!u@h.example.com", Account: "ed"}
+	if err := p.Grant(RoleEventEditor, "editor!*@*.example.com", "", "owner"); err != nil {
+		t.Fatalf("Failed to grant role: %s", err.Error())
+	}
+	if err := p.Grant(RoleAdmin, "", "ed", "owner"); err != nil {
+		t.Fatalf("Failed to grant role: %s", err.Error())
+	}
+	if role := p.RoleOf(msg); role != RoleAdmin {
+		t.Fatalf("Expected role admin, got %s", role)
+	}
+	// grants are stored in the database
+	reloaded, _ := NewPermissions(her0ld.GeneralConfig{}, p.Db)
+	if len(reloaded.Grants()) != 2 {
+		t.Fatalf("Expected 2 stored grants, got %d", len(reloaded.Grants()))
+	}
+	revoked, err := p.Revoke("", "ED")
+	if err != nil || len(revoked) != 1 {
+		t.Fatalf("Expected to revoke one grant, got %d (%v)", len(revoked), err)
+	}
+	if role := p.RoleOf(msg); role != RoleEventEditor {
+		t.Fatalf("Expected role event-editor after revoke, got %s", role)
+	}
+	revoked, _ = p.Revoke("nobody!*@*", "")
+	if len(revoked) != 0 {
+		t.Fatalf("Revoking an unknown hostmask should not remove anything")
+	}
+}
@@ -1,41 +1,44 @@
 package her0ldbot
 
-import (
-	"fmt"
-	"strings"
-)
-
 /* The Pingbot replies with "PONG" when it receives a "!ping" message.
  * */
 type PingBot struct {
 	BotName            string
 	NumMessagesHandled int
+	Commands           *CommandRouter
 }
 
 func NewPingBot(name string) *PingBot {
-	return &PingBot{BotName: name, NumMessagesHandled: 0}
+	b := &PingBot{
+		BotName:            name,
+		NumMessagesHandled: 0,
+		Commands:           NewCommandRouter(),
+	}
+	b.Commands.Register(&Command{
+		Name:    "ping",
+		Help:    "You say ping, I say PONG.",
+		Handler: b.ping,
+	})
+	return b
+}
+
+func (b *PingBot) ping(msg InboundMessage, args []string) ([]string, error) {
+	b.NumMessagesHandled += 1
+	return []string{"PONG"}, nil
 }
 
 func (b *PingBot) ProcessChannelEvent(msg InboundMessage) ([]OutboundMessage, error) {
-	if strings.ToLower(msg.Message) == "!ping" {
-		b.NumMessagesHandled += 1
-		answer := fmt.Sprintf("PONG")
-		reply := make([]OutboundMessage, 1)
-		reply[0] = OutboundMessage{Destination: msg.Channel, Message: answer}
-		return reply, nil
-	} else {
-		return nil, nil
-	}
+	return b.Commands.Dispatch(msg)
 }
 
 func (b *PingBot) ProcessQueryEvent(msg InboundMessage) ([]OutboundMessage, error) {
-	return nil, fmt.Errorf("PingBot does not implement query event handling")
+	return b.ProcessChannelEvent(msg)
 }
 
 func (b *PingBot) GetName() string {
 	return b.BotName
 }
 
-func (b *PingBot) GetHelpLines() []string {
-	return []string{"You say !ping, I say PONG."}
+func (b *PingBot) GetCommands() *CommandRouter {
+	return b.Commands
 }
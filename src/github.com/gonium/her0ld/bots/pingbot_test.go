@@ -10,7 +10,7 @@ func TestIgnoreNoCommandMsg(t *testing.T) {
 	texts := [4]string{"djewoijdowe", "foobar", "!pingoooo", "ping"}
 	for _, text := range texts {
 		msg := InboundMessage{
-			Channel: "test",
+			Target:  "#test",
 			Nick:    "testnick",
 			Message: text,
 		}
@@ -33,7 +33,7 @@ func TestValidCommandMsg(t *testing.T) {
 	texts := [2]string{"!ping", "!PING"}
 	for _, text := range texts {
 		msg := InboundMessage{
-			Channel: "test",
+			Target:  "#test",
 			Nick:    "testnick",
 			Message: text,
 		}
@@ -58,3 +58,24 @@ func TestValidCommandMsg(t *testing.T) {
 			bot.NumMessagesHandled, len(texts))
 	}
 }
+
+func TestQueryCommandMsg(t *testing.T) {
+	bot := NewPingBot("Pingbot")
+	msg := InboundMessage{
+		Target:  "mybotnick",
+		Nick:    "testnick",
+		Message: "!ping",
+	}
+	answerlines, err := bot.ProcessQueryEvent(msg)
+	if err != nil {
+		t.Fatalf("Bot should process query messages without error: %s",
+			err.Error())
+	}
+	if len(answerlines) != 1 {
+		t.Fatalf("Bot should respond with exactly one answer line")
+	}
+	if answerlines[0].Destination != msg.Nick {
+		t.Fatalf("Bot should answer to %s, not %s", msg.Nick,
+			answerlines[0].Destination)
+	}
+}
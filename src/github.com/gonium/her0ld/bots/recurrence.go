@@ -0,0 +1,510 @@
+package her0ldbot
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	FREQ_DAILY   = "DAILY"
+	FREQ_WEEKLY  = "WEEKLY"
+	FREQ_MONTHLY = "MONTHLY"
+	// Format of the UNTIL value of a recurrence rule.
+	RRULE_TIME_FORMAT = "20060102T150405Z"
+	// Expansion gives up after this many periods, e.g. for a monthly rule
+	// on the 5th Monday that never matches before UNTIL.
+	RECURRENCE_MAX_PERIODS = 100000
+)
+
+// The weekday codes of RFC 5545, indexed by time.Weekday.
+var rruleWeekdays = []string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+
+var ordinalNames = map[string]int{
+	"first": 1, "1st": 1,
+	"second": 2, "2nd": 2,
+	"third": 3, "3rd": 3,
+	"fourth": 4, "4th": 4,
+	"fifth": 5, "5th": 5,
+	"last": -1,
+}
+
+/* A weekday of a recurrence rule. For monthly rules, N selects the n-th
+ * weekday of the month, negative values count from the end of the
+ * month. N == 0 means every such weekday. */
+type WeekdayNum struct {
+	N   int
+	Day time.Weekday
+}
+
+func (w WeekdayNum) String() string {
+	if w.N == 0 {
+		return rruleWeekdays[w.Day]
+	}
+	return strconv.Itoa(w.N) + rruleWeekdays[w.Day]
+}
+
+/* A Recurrence describes how an event repeats. It implements the subset
+ * of the RFC 5545 RRULE needed for meetups: daily, weekly on given
+ * weekdays and monthly by day of month or weekday (e.g. the first
+ * Tuesday), optionally limited by an end date or a number of
+ * occurrences. */
+type Recurrence struct {
+	Freq       string
+	Interval   int
+	ByDay      []WeekdayNum
+	ByMonthDay []int
+	// The last possible occurrence (inclusive). Zero if unlimited.
+	Until time.Time
+	// The number of occurrences including the first one. Zero if
+	// unlimited.
+	Count int
+}
+
+// ParseRRule parses a recurrence rule like "FREQ=WEEKLY;BYDAY=TU,TH".
+// An "RRULE:" prefix is ignored.
+func ParseRRule(rule string) (*Recurrence, error) {
+	rule = strings.TrimPrefix(strings.TrimSpace(rule), "RRULE:")
+	r := &Recurrence{Interval: 1}
+	for _, part := range strings.Split(rule, ";") {
+		if part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid rule part %q", part)
+		}
+		key, value := strings.ToUpper(kv[0]), strings.ToUpper(kv[1])
+		var err error
+		switch key {
+		case "FREQ":
+			switch value {
+			case FREQ_DAILY, FREQ_WEEKLY, FREQ_MONTHLY:
+				r.Freq = value
+			default:
+				return nil, fmt.Errorf("unsupported frequency %s", value)
+			}
+		case "INTERVAL":
+			r.Interval, err = strconv.Atoi(value)
+			if err != nil || r.Interval < 1 {
+				return nil, fmt.Errorf("invalid interval %q", value)
+			}
+		case "COUNT":
+			r.Count, err = strconv.Atoi(value)
+			if err != nil || r.Count < 1 {
+				return nil, fmt.Errorf("invalid count %q", value)
+			}
+		case "UNTIL":
+			if r.Until, err = parseRRuleTime(value); err != nil {
+				return nil, err
+			}
+		case "BYDAY":
+			for _, day := range strings.Split(value, ",") {
+				wd, err := parseRRuleWeekday(day)
+				if err != nil {
+					return nil, err
+				}
+				r.ByDay = append(r.ByDay, wd)
+			}
+		case "BYMONTHDAY":
+			for _, day := range strings.Split(value, ",") {
+				d, err := strconv.Atoi(day)
+				if err != nil || d == 0 || d < -31 || d > 31 {
+					return nil, fmt.Errorf("invalid day of month %q", day)
+				}
+				r.ByMonthDay = append(r.ByMonthDay, d)
+			}
+		case "WKST":
+			// weeks always start on Monday
+		default:
+			return nil, fmt.Errorf("unsupported rule part %s", key)
+		}
+	}
+	return r, r.validate()
+}
+
+func (r *Recurrence) validate() error {
+	if r.Freq == "" {
+		return fmt.Errorf("missing frequency")
+	}
+	if r.Count > 0 && !r.Until.IsZero() {
+		return fmt.Errorf("a rule cannot have both an end date and a count")
+	}
+	if r.Freq != FREQ_MONTHLY {
+		if len(r.ByMonthDay) > 0 {
+			return fmt.Errorf("days of month are only supported for monthly rules")
+		}
+		for _, wd := range r.ByDay {
+			if wd.N != 0 {
+				return fmt.Errorf("numbered weekdays are only supported for monthly rules")
+			}
+		}
+	}
+	return nil
+}
+
+func parseRRuleTime(value string) (time.Time, error) {
+	for _, format := range []string{RRULE_TIME_FORMAT, "20060102T150405"} {
+		if t, err := time.Parse(format, value); err == nil {
+			return t, nil
+		}
+	}
+	// a date includes the whole day
+	if t, err := time.Parse("20060102", value); err == nil {
+		return t.Add(24*time.Hour - time.Second), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid end date %q", value)
+}
+
+func parseRRuleWeekday(value string) (WeekdayNum, error) {
+	value = strings.TrimSpace(value)
+	if len(value) < 2 {
+		return WeekdayNum{}, fmt.Errorf("invalid weekday %q", value)
+	}
+	code := value[len(value)-2:]
+	for idx, name := range rruleWeekdays {
+		if code != name {
+			continue
+		}
+		wd := WeekdayNum{Day: time.Weekday(idx)}
+		if num := value[:len(value)-2]; num != "" {
+			n, err := strconv.Atoi(num)
+			if err != nil || n == 0 || n < -5 || n > 5 {
+				return WeekdayNum{}, fmt.Errorf("invalid weekday %q", value)
+			}
+			wd.N = n
+		}
+		return wd, nil
+	}
+	return WeekdayNum{}, fmt.Errorf("invalid weekday %q", value)
+}
+
+// String returns the rule in RFC 5545 syntax, without "RRULE:".
+func (r *Recurrence) String() string {
+	parts := []string{"FREQ=" + r.Freq}
+	if r.Interval > 1 {
+		parts = append(parts, fmt.Sprintf("INTERVAL=%d", r.Interval))
+	}
+	if len(r.ByDay) > 0 {
+		var days []string
+		for _, wd := range r.ByDay {
+			days = append(days, wd.String())
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+	if len(r.ByMonthDay) > 0 {
+		var days []string
+		for _, d := range r.ByMonthDay {
+			days = append(days, strconv.Itoa(d))
+		}
+		parts = append(parts, "BYMONTHDAY="+strings.Join(days, ","))
+	}
+	if !r.Until.IsZero() {
+		parts = append(parts, "UNTIL="+r.Until.UTC().Format(RRULE_TIME_FORMAT))
+	}
+	if r.Count > 0 {
+		parts = append(parts, fmt.Sprintf("COUNT=%d", r.Count))
+	}
+	return strings.Join(parts, ";")
+}
+
+// Describe returns a short human readable form of the rule, e.g.
+// "weekly on Tue, Thu until 31.12.2016". Dates are shown in loc.
+func (r *Recurrence) Describe(loc *time.Location) string {
+	units := map[string]string{
+		FREQ_DAILY: "days", FREQ_WEEKLY: "weeks", FREQ_MONTHLY: "months",
+	}
+	var desc string
+	if r.Interval > 1 {
+		desc = fmt.Sprintf("every %d %s", r.Interval, units[r.Freq])
+	} else {
+		desc = strings.ToLower(r.Freq)
+	}
+	var on []string
+	for _, wd := range r.ByDay {
+		day := wd.Day.String()[:3]
+		switch {
+		case wd.N == -1:
+			day = "the last " + day
+		case wd.N < 0:
+			day = fmt.Sprintf("the %d. last %s", -wd.N, day)
+		case wd.N > 0:
+			for name, n := range ordinalNames {
+				if n == wd.N && len(name) > 3 {
+					day = "the " + name + " " + day
+				}
+			}
+		}
+		on = append(on, day)
+	}
+	for _, d := range r.ByMonthDay {
+		if d < 0 {
+			on = append(on, fmt.Sprintf("day %d from the end", -d))
+		} else {
+			on = append(on, fmt.Sprintf("day %d", d))
+		}
+	}
+	if len(on) > 0 {
+		desc += " on " + strings.Join(on, ", ")
+	}
+	if !r.Until.IsZero() {
+		desc += " until " + r.Until.In(loc).Format("2.1.2006")
+	}
+	if r.Count > 0 {
+		desc += fmt.Sprintf(", %d times", r.Count)
+	}
+	return desc
+}
+
+// daysIn returns the number of days of the month of t.
+func daysIn(t time.Time) int {
+	return time.Date(t.Year(), t.Month()+1, 0, 0, 0, 0, 0, t.Location()).Day()
+}
+
+// candidates returns the days of the n-th period after start that match
+// the rule, in ascending order. Days before start are not filtered.
+func (r *Recurrence) candidates(start time.Time, n int) []time.Time {
+	at := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, start.Hour(), start.Minute(),
+			start.Second(), start.Nanosecond(), start.Location())
+	}
+	var days []time.Time
+	switch r.Freq {
+	case FREQ_DAILY:
+		day := start.AddDate(0, 0, n*r.Interval)
+		if len(r.ByDay) == 0 || r.hasWeekday(day.Weekday()) {
+			days = append(days, day)
+		}
+	case FREQ_WEEKLY:
+		// weeks start on Monday
+		offset := (int(start.Weekday()) + 6) % 7
+		monday := start.AddDate(0, 0, n*7*r.Interval-offset)
+		if len(r.ByDay) == 0 {
+			return []time.Time{monday.AddDate(0, 0, offset)}
+		}
+		for i := 0; i < 7; i++ {
+			day := monday.AddDate(0, 0, i)
+			if r.hasWeekday(day.Weekday()) {
+				days = append(days, day)
+			}
+		}
+	case FREQ_MONTHLY:
+		first := time.Date(start.Year(), start.Month()+time.Month(n*r.Interval), 1,
+			0, 0, 0, 0, start.Location())
+		numDays := daysIn(first)
+		matches := make(map[int]bool)
+		for _, d := range r.ByMonthDay {
+			if d < 0 {
+				d = numDays + d + 1
+			}
+			if d >= 1 && d <= numDays {
+				matches[d] = true
+			}
+		}
+		for _, wd := range r.ByDay {
+			var found []int
+			for d := 1; d <= numDays; d++ {
+				if first.AddDate(0, 0, d-1).Weekday() == wd.Day {
+					found = append(found, d)
+				}
+			}
+			switch {
+			case wd.N == 0:
+				for _, d := range found {
+					matches[d] = true
+				}
+			case wd.N > 0 && wd.N <= len(found):
+				matches[found[wd.N-1]] = true
+			case wd.N < 0 && -wd.N <= len(found):
+				matches[found[len(found)+wd.N]] = true
+			}
+		}
+		if len(r.ByMonthDay) == 0 && len(r.ByDay) == 0 && start.Day() <= numDays {
+			// months without this day are skipped, as in RFC 5545
+			matches[start.Day()] = true
+		}
+		var sorted []int
+		for d := range matches {
+			sorted = append(sorted, d)
+		}
+		sort.Ints(sorted)
+		for _, d := range sorted {
+			days = append(days, at(first.Year(), first.Month(), d))
+		}
+	}
+	return days
+}
+
+func (r *Recurrence) hasWeekday(day time.Weekday) bool {
+	for _, wd := range r.ByDay {
+		if wd.Day == day {
+			return true
+		}
+	}
+	return false
+}
+
+// Occurrences returns the start times of all occurrences in [from, to).
+// The first occurrence is start itself, the wall clock time of start is
+// kept in its location, also across daylight saving time changes.
+func (r *Recurrence) Occurrences(start, from, to time.Time) []time.Time {
+	var retval []time.Time
+	count := 0
+	for n := 0; n < RECURRENCE_MAX_PERIODS; n++ {
+		days := r.candidates(start, n)
+		for _, day := range days {
+			if day.Before(start) {
+				continue
+			}
+			count++
+			if r.Count > 0 && count > r.Count {
+				return retval
+			}
+			if !r.Until.IsZero() && day.After(r.Until) {
+				return retval
+			}
+			if !day.Before(to) {
+				return retval
+			}
+			if !day.Before(from) {
+				retval = append(retval, day)
+			}
+		}
+	}
+	return retval
+}
+
+// Ended reports whether the recurrence has no occurrences at or after t.
+func (r *Recurrence) Ended(start, t time.Time) bool {
+	if !r.Until.IsZero() && r.Until.Before(t) {
+		return true
+	}
+	if r.Count > 0 {
+		// the count limits the expansion, the window does not matter
+		return len(r.Occurrences(start, t, t.AddDate(100, 0, 0))) == 0
+	}
+	return false
+}
+
+// ParseRecurrence parses the human readable form of a recurrence rule
+// as used in chat, e.g. "weekly on tue,thu until 31.12.2016", "monthly
+// on the first tuesday", "every 2 weeks, 10 times" or "monthly 15". A
+// rule in RFC 5545 syntax is accepted as well. Dates are interpreted
+// in loc.
+func ParseRecurrence(words []string, loc *time.Location) (*Recurrence, error) {
+	if len(words) == 1 && strings.Contains(strings.ToUpper(words[0]), "FREQ=") {
+		return ParseRRule(words[0])
+	}
+	// commas separate weekdays as well as words
+	var tokens []string
+	for _, word := range words {
+		for _, token := range strings.Split(strings.ToLower(word), ",") {
+			if token != "" {
+				tokens = append(tokens, token)
+			}
+		}
+	}
+	r := &Recurrence{Interval: 1}
+	units := map[string]string{
+		"day": FREQ_DAILY, "days": FREQ_DAILY,
+		"week": FREQ_WEEKLY, "weeks": FREQ_WEEKLY,
+		"month": FREQ_MONTHLY, "months": FREQ_MONTHLY,
+	}
+	// ordinals apply to the following weekday, e.g. "first and third tue"
+	var ordinals []int
+	for i := 0; i < len(tokens); i++ {
+		token := tokens[i]
+		next := func() (string, error) {
+			if i+1 >= len(tokens) {
+				return "", fmt.Errorf("missing value after %q", token)
+			}
+			i++
+			return tokens[i], nil
+		}
+		switch {
+		case token == "on" || token == "the" || token == "and" || token == "of":
+		case token == "daily":
+			r.Freq = FREQ_DAILY
+		case token == "weekly":
+			r.Freq = FREQ_WEEKLY
+		case token == "monthly":
+			r.Freq = FREQ_MONTHLY
+		case token == "every":
+			value, err := next()
+			if err != nil {
+				return nil, err
+			}
+			if n, err := strconv.Atoi(value); err == nil && n > 0 {
+				r.Interval = n
+				if value, err = next(); err != nil {
+					return nil, err
+				}
+			}
+			freq, ok := units[value]
+			if !ok {
+				return nil, fmt.Errorf("unknown interval %q, use days, weeks or months",
+					value)
+			}
+			r.Freq = freq
+		case token == "until":
+			value, err := next()
+			if err != nil {
+				return nil, err
+			}
+			date, err := time.ParseInLocation("02.01.2006", value, loc)
+			if err != nil {
+				return nil, fmt.Errorf("invalid end date %q, use e.g. 31.12.2016", value)
+			}
+			// the end date is inclusive
+			r.Until = date.AddDate(0, 0, 1).Add(-time.Second)
+		case token == "count" || token == "times":
+			if token == "times" {
+				continue
+			}
+			value, err := next()
+			if err != nil {
+				return nil, err
+			}
+			if r.Count, err = strconv.Atoi(value); err != nil || r.Count < 1 {
+				return nil, fmt.Errorf("invalid count %q", value)
+			}
+		case ordinalNames[token] != 0:
+			ordinals = append(ordinals, ordinalNames[token])
+		default:
+			if day, ok := ParseWeekday(token); ok {
+				if len(ordinals) == 0 {
+					r.ByDay = append(r.ByDay, WeekdayNum{Day: day})
+				}
+				for _, n := range ordinals {
+					r.ByDay = append(r.ByDay, WeekdayNum{N: n, Day: day})
+				}
+				ordinals = nil
+				continue
+			}
+			n, err := strconv.Atoi(strings.TrimSuffix(token, "."))
+			if err != nil {
+				return nil, fmt.Errorf("cannot understand %q", token)
+			}
+			if i+1 < len(tokens) && tokens[i+1] == "times" {
+				if n < 1 {
+					return nil, fmt.Errorf("invalid count %d", n)
+				}
+				r.Count = n
+			} else if n != 0 && n >= -31 && n <= 31 {
+				r.ByMonthDay = append(r.ByMonthDay, n)
+			} else {
+				return nil, fmt.Errorf("invalid day of month %d", n)
+			}
+		}
+	}
+	if len(ordinals) > 0 {
+		return nil, fmt.Errorf("missing weekday after ordinal")
+	}
+	if r.Freq == "" && len(r.ByDay) > 0 {
+		// "on tue, thu" repeats weekly
+		r.Freq = FREQ_WEEKLY
+	}
+	return r, r.validate()
+}
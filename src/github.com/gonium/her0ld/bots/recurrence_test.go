@@ -0,0 +1,130 @@
+package her0ldbot
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func mustLoadLocation(name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		panic(err)
+	}
+	return loc
+}
+
+func formatTimes(times []time.Time) string {
+	var formatted []string
+	for _, t := range times {
+		formatted = append(formatted, t.Format("Mon 02.01.2006 15:04"))
+	}
+	return strings.Join(formatted, ", ")
+}
+
+func TestRRuleRoundTrip(t *testing.T) {
+	rules := []string{
+		"FREQ=DAILY",
+		"FREQ=WEEKLY;INTERVAL=2;BYDAY=TU,TH",
+		"FREQ=MONTHLY;BYDAY=1TU",
+		"FREQ=MONTHLY;BYDAY=-1FR;COUNT=5",
+		"FREQ=MONTHLY;BYMONTHDAY=15,-1;UNTIL=20161231T225959Z",
+	}
+	for _, rule := range rules {
+		rec, err := ParseRRule(rule)
+		if err != nil {
+			t.Fatalf("Failed to parse %s: %s", rule, err.Error())
+		}
+		if rec.String() != rule {
+			t.Fatalf("Rule %s was serialized as %s", rule, rec.String())
+		}
+	}
+	invalid := []string{
+		"", "FREQ=YEARLY", "FREQ=DAILY;INTERVAL=0", "FREQ=WEEKLY;BYDAY=XX",
+		"FREQ=WEEKLY;BYDAY=1TU", "FREQ=DAILY;COUNT=2;UNTIL=20160101",
+		"FREQ=DAILY;BYHOUR=3",
+	}
+	for _, rule := range invalid {
+		if _, err := ParseRRule(rule); err == nil {
+			t.Fatalf("Invalid rule %q was accepted", rule)
+		}
+	}
+}
+
+func TestParseRecurrence(t *testing.T) {
+	loc := mustLoadLocation("Europe/Berlin")
+	tests := map[string]string{
+		"daily":                                "FREQ=DAILY",
+		"weekly":                               "FREQ=WEEKLY",
+		"weekly on tue,thu":                    "FREQ=WEEKLY;BYDAY=TU,TH",
+		"on Monday and Friday":                 "FREQ=WEEKLY;BYDAY=MO,FR",
+		"every 2 weeks on wed 10 times":        "FREQ=WEEKLY;INTERVAL=2;BYDAY=WE;COUNT=10",
+		"monthly on the first tuesday":         "FREQ=MONTHLY;BYDAY=1TU",
+		"monthly on the last fri count 3":      "FREQ=MONTHLY;BYDAY=-1FR;COUNT=3",
+		"monthly 15":                           "FREQ=MONTHLY;BYMONTHDAY=15",
+		"monthly until 31.12.2016":             "FREQ=MONTHLY;UNTIL=20161231T225959Z",
+		"FREQ=MONTHLY;BYDAY=2WE":               "FREQ=MONTHLY;BYDAY=2WE",
+		"every 3 days until 01.07.2016":        "FREQ=DAILY;INTERVAL=3;UNTIL=20160701T215959Z",
+		"monthly on the second and fourth thu": "FREQ=MONTHLY;BYDAY=2TH,4TH",
+	}
+	for input, expected := range tests {
+		rec, err := ParseRecurrence(strings.Fields(input), loc)
+		if err != nil {
+			t.Fatalf("Failed to parse %q: %s", input, err.Error())
+		}
+		if rec.String() != expected {
+			t.Fatalf("%q should be %s, got %s", input, expected, rec.String())
+		}
+	}
+	for _, input := range []string{"", "sometimes", "weekly on the first", "every 2 years",
+		"monthly 42", "weekly until tomorrow", "every week 0 times", "weekly -3 times",
+		"weekly count 0"} {
+		if _, err := ParseRecurrence(strings.Fields(input), loc); err == nil {
+			t.Fatalf("Invalid recurrence %q was accepted", input)
+		}
+	}
+}
+
+func TestOccurrences(t *testing.T) {
+	loc := mustLoadLocation("Europe/Berlin")
+	// Tuesday, 1 March 2016, 19:00 - DST starts on 27 March.
+	start := time.Date(2016, 3, 1, 19, 0, 0, 0, loc)
+	from := time.Date(2016, 3, 1, 0, 0, 0, 0, loc)
+	to := time.Date(2016, 7, 1, 0, 0, 0, 0, loc)
+	tests := []struct {
+		rule     string
+		expected string
+	}{
+		{"FREQ=WEEKLY;COUNT=5",
+			"Tue 01.03.2016 19:00, Tue 08.03.2016 19:00, Tue 15.03.2016 19:00, " +
+				"Tue 22.03.2016 19:00, Tue 29.03.2016 19:00"},
+		{"FREQ=WEEKLY;BYDAY=MO,TH;UNTIL=20160310T235959Z",
+			"Thu 03.03.2016 19:00, Mon 07.03.2016 19:00, Thu 10.03.2016 19:00"},
+		{"FREQ=MONTHLY;BYDAY=1TU",
+			"Tue 01.03.2016 19:00, Tue 05.04.2016 19:00, Tue 03.05.2016 19:00, " +
+				"Tue 07.06.2016 19:00"},
+		{"FREQ=MONTHLY;BYDAY=-1FR;COUNT=2",
+			"Fri 25.03.2016 19:00, Fri 29.04.2016 19:00"},
+		{"FREQ=MONTHLY;BYMONTHDAY=31",
+			"Thu 31.03.2016 19:00, Tue 31.05.2016 19:00"},
+		{"FREQ=DAILY;INTERVAL=10;COUNT=3",
+			"Tue 01.03.2016 19:00, Fri 11.03.2016 19:00, Mon 21.03.2016 19:00"},
+	}
+	for _, test := range tests {
+		rec, err := ParseRRule(test.rule)
+		if err != nil {
+			t.Fatalf("Failed to parse %s: %s", test.rule, err.Error())
+		}
+		got := formatTimes(rec.Occurrences(start, from, to))
+		if got != test.expected {
+			t.Fatalf("Occurrences of %s:\nexpected %s\ngot      %s", test.rule,
+				test.expected, got)
+		}
+	}
+	// the window does not change the counting of occurrences
+	rec, _ := ParseRRule("FREQ=WEEKLY;COUNT=3")
+	got := formatTimes(rec.Occurrences(start, time.Date(2016, 3, 10, 0, 0, 0, 0, loc), to))
+	if got != "Tue 15.03.2016 19:00" {
+		t.Fatalf("Expected only the third occurrence, got %s", got)
+	}
+}
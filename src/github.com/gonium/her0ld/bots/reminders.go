@@ -0,0 +1,160 @@
+package her0ldbot
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	EVENTBOT_REMINDER = "Reminder: %s starts in %s (%s)."
+	// Announced reminders are remembered this long after the start of
+	// their event.
+	EVENTBOT_REMINDER_RETENTION = 7 * 24 * time.Hour
+)
+
+/* A ReminderLog records an announced reminder, so that it is not
+ * repeated after a restart. The reminders of an event that was moved
+ * do not match its new start time and are announced again. */
+type ReminderLog struct {
+	Id          int `sql:"AUTO_INCREMENT" gorm:"primary_key"`
+	EventId     int `sql:"index"`
+	Starttime   time.Time
+	LeadMinutes int
+	CreatedAt   time.Time
+}
+
+/* An Announcer delivers the messages a bot sends on its own, e.g.
+ * reminders, to the channels in which the bot is active. */
+type Announcer interface {
+	Announce(bot Bot, lines []string)
+}
+
+// ParseLeadTimes parses the configured reminder lead times, e.g. "24h"
+// and "30m", and returns them in ascending order.
+func ParseLeadTimes(leadtimes []string) ([]time.Duration, error) {
+	var retval []time.Duration
+	for _, s := range leadtimes {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return nil, err
+		}
+		if d < time.Minute {
+			return nil, fmt.Errorf("lead time %s is shorter than a minute", s)
+		}
+		retval = append(retval, d)
+	}
+	sort.Slice(retval, func(i, j int) bool { return retval[i] < retval[j] })
+	return retval, nil
+}
+
+// formatLeadTime formats the time until an event starts, e.g. "1 day
+// 2 hours" or "30 minutes".
+func formatLeadTime(d time.Duration) string {
+	d = d.Round(time.Minute)
+	if d < time.Minute {
+		return "less than a minute"
+	}
+	var parts []string
+	for _, unit := range []struct {
+		name string
+		d    time.Duration
+	}{
+		{"day", 24 * time.Hour},
+		{"hour", time.Hour},
+		{"minute", time.Minute},
+	} {
+		n := int(d / unit.d)
+		d -= time.Duration(n) * unit.d
+		switch {
+		case n == 1:
+			parts = append(parts, "1 "+unit.name)
+		case n > 1:
+			parts = append(parts, fmt.Sprintf("%d %ss", n, unit.name))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// AddAnnouncer registers a receiver of the reminders.
+func (b *EventBot) AddAnnouncer(a Announcer) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.announcers = append(b.announcers, a)
+}
+
+// DueReminders returns the reminders of all occurrences that start
+// within a lead time after now and records them as announced. If
+// several lead times of an occurrence are due, e.g. after a downtime,
+// only one reminder is returned.
+func (b *EventBot) DueReminders(now time.Time) []string {
+	if len(b.LeadTimes) == 0 {
+		return nil
+	}
+	maxLead := b.LeadTimes[len(b.LeadTimes)-1]
+	occurrences := b.eventsBetween(now, now.Add(maxLead+time.Second))
+	if len(occurrences) == 0 {
+		return nil
+	}
+	var ids []int
+	for _, event := range occurrences {
+		ids = append(ids, event.Id)
+	}
+	var logs []ReminderLog
+	b.Db.Where("event_id in (?)", ids).Find(&logs)
+	announced := func(event Event, lead time.Duration) bool {
+		for _, l := range logs {
+			if l.EventId == event.Id && l.Starttime.Equal(event.Starttime) &&
+				l.LeadMinutes == int(lead/time.Minute) {
+				return true
+			}
+		}
+		return false
+	}
+	var retval []string
+	for _, event := range occurrences {
+		due := false
+		for _, lead := range b.LeadTimes {
+			if event.Starttime.Sub(now) > lead || announced(event, lead) {
+				continue
+			}
+			b.Db.Create(&ReminderLog{
+				EventId:     event.Id,
+				Starttime:   event.Starttime,
+				LeadMinutes: int(lead / time.Minute),
+			})
+			due = true
+		}
+		if due {
+			summary := event.Description
+			if event.Location != "" {
+				summary += " @ " + event.Location
+			}
+			retval = append(retval, fmt.Sprintf(EVENTBOT_REMINDER, summary,
+				formatLeadTime(event.Starttime.Sub(now)),
+				event.Starttime.Format(EVENTBOT_DISPLAY_TIME_FORMAT)))
+		}
+	}
+	return retval
+}
+
+// SendReminders announces the due reminders and forgets the reminders
+// of past events.
+func (b *EventBot) SendReminders() {
+	now := time.Now()
+	lines := b.DueReminders(now)
+	b.Db.Where("starttime < ?", now.Add(-EVENTBOT_REMINDER_RETENTION)).
+		Delete(ReminderLog{})
+	if len(lines) == 0 {
+		return
+	}
+	log.Printf("Eventbot: Announcing %d reminders", len(lines))
+	b.mutex.Lock()
+	announcers := b.announcers
+	b.mutex.Unlock()
+	for _, a := range announcers {
+		a.Announce(b, lines)
+	}
+}
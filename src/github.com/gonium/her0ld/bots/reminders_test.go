@@ -0,0 +1,115 @@
+package her0ldbot
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+type recordingAnnouncer struct {
+	lines []string
+}
+
+func (a *recordingAnnouncer) Announce(bot Bot, lines []string) {
+	a.lines = append(a.lines, lines...)
+}
+
+func TestParseLeadTimes(t *testing.T) {
+	leadtimes, err := ParseLeadTimes([]string{"30m", "24h", "1h30m"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	expected := []time.Duration{30 * time.Minute, 90 * time.Minute, 24 * time.Hour}
+	if fmt.Sprint(leadtimes) != fmt.Sprint(expected) {
+		t.Fatalf("Expected %v, got %v", expected, leadtimes)
+	}
+	for _, invalid := range []string{"soon", "30s", "-1h"} {
+		if _, err := ParseLeadTimes([]string{invalid}); err == nil {
+			t.Fatalf("Lead time %q should be invalid", invalid)
+		}
+	}
+}
+
+func TestFormatLeadTime(t *testing.T) {
+	for d, expected := range map[time.Duration]string{
+		30*time.Minute - time.Second: "30 minutes",
+		24 * time.Hour:               "1 day",
+		26*time.Hour + time.Minute:   "1 day 2 hours 1 minute",
+		10 * time.Second:             "less than a minute",
+	} {
+		if got := formatLeadTime(d); got != expected {
+			t.Fatalf("%s: expected %q, got %q", d, expected, got)
+		}
+	}
+}
+
+func TestDueReminders(t *testing.T) {
+	bot := MkEventBot()
+	bot.LeadTimes = []time.Duration{30 * time.Minute, 24 * time.Hour}
+	now := time.Date(2016, 5, 4, 18, 0, 0, 0, bot.TimeLocation)
+	start := time.Date(2016, 5, 5, 19, 0, 0, 0, bot.TimeLocation)
+	event := Event{Starttime: start, Description: "Meetup", Location: "Lab"}
+	bot.Db.Create(&event)
+
+	if lines := bot.DueReminders(now); len(lines) != 0 {
+		t.Fatalf("No reminder expected 25h before the event, got %#v", lines)
+	}
+	lines := bot.DueReminders(now.Add(time.Hour))
+	expected := fmt.Sprintf(EVENTBOT_REMINDER, "Meetup @ Lab", "1 day",
+		"Thu 5 May 2016, 19:00")
+	if len(lines) != 1 || lines[0] != expected {
+		t.Fatalf("Expected >%s<, got %#v", expected, lines)
+	}
+	if lines := bot.DueReminders(now.Add(2 * time.Hour)); len(lines) != 0 {
+		t.Fatalf("Reminder repeated: %#v", lines)
+	}
+
+	// moving the event schedules its reminders again
+	response := eventCommand(t, bot, "edit 1 date 05.05.2016-18:45")
+	if len(response) != 1 {
+		t.Fatalf("Unexpected response %#v", response)
+	}
+	lines = bot.DueReminders(time.Date(2016, 5, 5, 18, 15, 0, 0, bot.TimeLocation))
+	expected = fmt.Sprintf(EVENTBOT_REMINDER, "Meetup @ Lab", "30 minutes",
+		"Thu 5 May 2016, 18:45")
+	if len(lines) != 1 || lines[0] != expected {
+		t.Fatalf("Expected >%s<, got %#v", expected, lines)
+	}
+
+	// deleted events are not announced
+	later := Event{Starttime: start.AddDate(0, 0, 7), Description: "Later"}
+	bot.Db.Create(&later)
+	eventCommand(t, bot, fmt.Sprintf("del %d", later.Id))
+	if lines := bot.DueReminders(later.Starttime.Add(-time.Hour)); len(lines) != 0 {
+		t.Fatalf("Reminder of a deleted event: %#v", lines)
+	}
+}
+
+func TestRecurringEventReminders(t *testing.T) {
+	bot := MkEventBot()
+	bot.LeadTimes = []time.Duration{30 * time.Minute}
+	start := time.Date(2016, 5, 5, 19, 0, 0, 0, bot.TimeLocation)
+	bot.Db.Create(&Event{Starttime: start, Description: "Weekly",
+		Rrule: "FREQ=WEEKLY"})
+	for week := 0; week < 3; week++ {
+		due := start.AddDate(0, 0, 7*week).Add(-20 * time.Minute)
+		lines := bot.DueReminders(due)
+		if len(lines) != 1 {
+			t.Fatalf("Expected one reminder in week %d, got %#v", week, lines)
+		}
+	}
+}
+
+func TestSendReminders(t *testing.T) {
+	bot := MkEventBot()
+	bot.LeadTimes = []time.Duration{30 * time.Minute}
+	announcer := &recordingAnnouncer{}
+	bot.AddAnnouncer(announcer)
+	bot.Db.Create(&Event{Starttime: time.Now().Add(10 * time.Minute),
+		Description: "Soon"})
+	bot.SendReminders()
+	bot.SendReminders()
+	if len(announcer.lines) != 1 {
+		t.Fatalf("Expected one announced reminder, got %#v", announcer.lines)
+	}
+}
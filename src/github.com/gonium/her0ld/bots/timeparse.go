@@ -0,0 +1,242 @@
+package her0ldbot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Formats of a date and time in a single word.
+var dateTimeLayouts = []string{
+	EVENTBOT_TIME_FORMAT,
+	"2.1.2006-15:04",
+	"2006-01-02T15:04",
+	"2006-01-02T15:04:05",
+}
+
+// Formats of a date that is followed by a time.
+var dateLayouts = []string{
+	EVENTBOT_DATE_FORMAT,
+	"2.1.2006",
+	"2006-01-02",
+}
+
+// Weekday names in English and German, indexed by time.Weekday. Names
+// may be abbreviated.
+var weekdayNames = [][]string{
+	{"sunday", "sonntag"},
+	{"monday", "montag"},
+	{"tuesday", "dienstag"},
+	{"wednesday", "mittwoch"},
+	{"thursday", "donnerstag"},
+	{"friday", "freitag"},
+	{"saturday", "samstag", "sonnabend"},
+}
+
+// Words that select the next weekday after today, e.g. "next friday".
+var nextWords = []string{"next", "nächste", "nächsten", "nächster",
+	"nächstes", "kommende", "kommenden", "kommender"}
+
+// Units of relative dates, e.g. "in 3 days".
+var relativeUnits = map[string]time.Duration{}
+
+func init() {
+	units := []struct {
+		names []string
+		unit  time.Duration
+	}{
+		{[]string{"min", "minute", "minutes", "minuten"}, time.Minute},
+		{[]string{"hour", "hours", "stunde", "stunden"}, time.Hour},
+		{[]string{"day", "days", "tag", "tage", "tagen"}, 24 * time.Hour},
+		{[]string{"week", "weeks", "woche", "wochen"}, 7 * 24 * time.Hour},
+	}
+	for _, u := range units {
+		for _, name := range u.names {
+			relativeUnits[name] = u.unit
+		}
+	}
+}
+
+// ParseWeekday parses an English or German weekday name or abbreviation
+// of at least two letters, e.g. "tue", "Tuesday", "di", "Dienstag" or
+// the RFC 5545 code "TU".
+func ParseWeekday(name string) (time.Weekday, bool) {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	if len(name) < 2 {
+		return time.Sunday, false
+	}
+	for day, names := range weekdayNames {
+		for _, full := range names {
+			if strings.HasPrefix(full, name) {
+				return time.Weekday(day), true
+			}
+		}
+	}
+	return time.Sunday, false
+}
+
+func isOneOf(word string, words ...string) bool {
+	for _, w := range words {
+		if word == w {
+			return true
+		}
+	}
+	return false
+}
+
+// parseClock parses a time of day at the beginning of words, e.g.
+// "19:00", "19h", "19 Uhr", "7pm" or "7:30pm". It returns the hour, the
+// minute and the number of words used.
+func parseClock(words []string) (int, int, int, bool) {
+	if len(words) == 0 {
+		return 0, 0, 0, false
+	}
+	word := strings.ToLower(words[0])
+	used := 1
+	offset := 0
+	switch {
+	case strings.HasSuffix(word, "uhr"):
+		word = strings.TrimSuffix(word, "uhr")
+	case strings.HasSuffix(word, "h"):
+		word = strings.TrimSuffix(word, "h")
+	case strings.HasSuffix(word, "am"):
+		word = strings.TrimSuffix(word, "am")
+		offset = -1
+	case strings.HasSuffix(word, "pm"):
+		word = strings.TrimSuffix(word, "pm")
+		offset = 12
+	case !strings.Contains(word, ":"):
+		// a plain number needs a unit: "19 Uhr"
+		if len(words) < 2 || strings.ToLower(words[1]) != "uhr" {
+			return 0, 0, 0, false
+		}
+		used = 2
+	}
+	minute := 0
+	parts := strings.SplitN(word, ":", 2)
+	hour, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, 0, false
+	}
+	if len(parts) == 2 {
+		if len(parts[1]) != 2 {
+			return 0, 0, 0, false
+		}
+		if minute, err = strconv.Atoi(parts[1]); err != nil || minute > 59 {
+			return 0, 0, 0, false
+		}
+	}
+	if offset != 0 {
+		// 12am is midnight, 12pm is noon
+		if hour < 1 || hour > 12 {
+			return 0, 0, 0, false
+		}
+		hour = hour % 12
+		if offset > 0 {
+			hour += 12
+		}
+	}
+	if hour < 0 || hour > 23 {
+		return 0, 0, 0, false
+	}
+	return hour, minute, used, true
+}
+
+// ParseTime parses the date and time at the beginning of words and
+// returns it together with the number of words used. Besides
+// EVENTBOT_TIME_FORMAT and ISO 8601 it understands relative dates like
+// "tomorrow 19:00", "next friday 20:00", "in 3 days 19:00", "in 2
+// hours" and their German equivalents. Relative dates are resolved
+// from now, in the location of now.
+func ParseTime(words []string, now time.Time) (time.Time, int, error) {
+	if len(words) == 0 {
+		return time.Time{}, 0, fmt.Errorf("missing date")
+	}
+	loc := now.Location()
+	for _, layout := range dateTimeLayouts {
+		if t, err := time.ParseInLocation(layout, words[0], loc); err == nil {
+			return t, 1, nil
+		}
+	}
+	lower := make([]string, len(words))
+	for idx, word := range words {
+		lower[idx] = strings.ToLower(word)
+	}
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	var day time.Time
+	used := 0
+	// a bare weekday may be today, if the time has not passed yet
+	weekdayToday := false
+	for _, layout := range dateLayouts {
+		if t, err := time.ParseInLocation(layout, words[0], loc); err == nil {
+			day, used = t, 1
+			break
+		}
+	}
+	if used == 0 {
+		switch {
+		case isOneOf(lower[0], "today", "heute"):
+			day, used = today, 1
+		case isOneOf(lower[0], "tomorrow", "morgen"):
+			day, used = today.AddDate(0, 0, 1), 1
+		case lower[0] == "übermorgen":
+			day, used = today.AddDate(0, 0, 2), 1
+		case lower[0] == "in" || lower[0] == "+":
+			if len(words) < 3 {
+				return time.Time{}, 0, fmt.Errorf("use e.g. \"in 3 days 19:00\"")
+			}
+			n, err := strconv.Atoi(words[1])
+			unit, ok := relativeUnits[lower[2]]
+			if err != nil || n < 0 || !ok {
+				return time.Time{}, 0, fmt.Errorf("cannot understand %q",
+					strings.Join(words[:3], " "))
+			}
+			if unit < 24*time.Hour {
+				// "in 2 hours" is complete without a time
+				return now.Add(time.Duration(n) * unit).Truncate(time.Minute), 3, nil
+			}
+			day, used = today.AddDate(0, 0, n*int(unit/(24*time.Hour))), 3
+			_, _, _, ok = parseClock(words[used:])
+			if !ok && (used == len(words) || !isOneOf(lower[used], "at", "um")) {
+				// "in 3 days" without a time keeps the current time
+				return time.Date(day.Year(), day.Month(), day.Day(), now.Hour(),
+					now.Minute(), 0, 0, loc), used, nil
+			}
+		case isOneOf(lower[0], nextWords...) && len(words) > 1:
+			wd, ok := ParseWeekday(lower[1])
+			if !ok {
+				return time.Time{}, 0, fmt.Errorf("unknown weekday %q", words[1])
+			}
+			offset := (int(wd) - int(today.Weekday()) + 7) % 7
+			if offset == 0 {
+				offset = 7
+			}
+			day, used = today.AddDate(0, 0, offset), 2
+		default:
+			wd, ok := ParseWeekday(lower[0])
+			if !ok {
+				return time.Time{}, 0, fmt.Errorf("cannot understand %q", words[0])
+			}
+			offset := (int(wd) - int(today.Weekday()) + 7) % 7
+			day, used = today.AddDate(0, 0, offset), 1
+			weekdayToday = offset == 0
+		}
+	}
+	if used < len(words) && isOneOf(lower[used], "at", "um") {
+		used++
+	}
+	hour, minute, n, ok := parseClock(words[used:])
+	if !ok {
+		if used < len(words) {
+			return time.Time{}, 0, fmt.Errorf("invalid time %q, use e.g. 19:00",
+				words[used])
+		}
+		return time.Time{}, 0, fmt.Errorf("missing time, use e.g. 19:00")
+	}
+	t := time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, loc)
+	if weekdayToday && t.Before(now) {
+		t = t.AddDate(0, 0, 7)
+	}
+	return t, used + n, nil
+}
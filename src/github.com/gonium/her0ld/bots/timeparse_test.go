@@ -0,0 +1,85 @@
+package her0ldbot
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTime(t *testing.T) {
+	loc := mustLoadLocation("Europe/Berlin")
+	// a Wednesday
+	now := time.Date(2016, 5, 4, 18, 30, 0, 0, loc)
+	tests := []struct {
+		input    string
+		expected string
+		used     int
+	}{
+		{"03.05.2016-19:00 Meetup", "03.05.2016-19:00", 1},
+		{"3.5.2016-19:00", "03.05.2016-19:00", 1},
+		{"2016-05-03T19:00 Meetup", "03.05.2016-19:00", 1},
+		{"2016-05-03 19:00 Meetup", "03.05.2016-19:00", 2},
+		{"10.05.2016 at 7pm Meetup", "10.05.2016-19:00", 3},
+		{"today 20:00", "04.05.2016-20:00", 2},
+		{"tomorrow 19:00 Meetup", "05.05.2016-19:00", 2},
+		{"morgen um 19 Uhr Treffen", "05.05.2016-19:00", 4},
+		{"übermorgen 19h", "06.05.2016-19:00", 2},
+		{"next friday 20:00 Meetup", "06.05.2016-20:00", 3},
+		{"friday 20:00", "06.05.2016-20:00", 2},
+		{"nächsten Freitag 20:00", "06.05.2016-20:00", 3},
+		{"Sonnabend 12:00", "07.05.2016-12:00", 2},
+		{"Mittwoch 20:00", "04.05.2016-20:00", 2},
+		{"Mittwoch 18:00", "11.05.2016-18:00", 2},
+		{"next wednesday 20:00", "11.05.2016-20:00", 3},
+		{"in 3 days 19:00 Meetup", "07.05.2016-19:00", 4},
+		{"in 2 Wochen 19:00", "18.05.2016-19:00", 4},
+		{"in 2 hours Meetup", "04.05.2016-20:30", 3},
+		{"in 3 days Meetup", "07.05.2016-18:30", 3},
+		{"in 2 Wochen", "18.05.2016-18:30", 3},
+		{"in 3 days at Meetup", "", 0},
+		{"12am", "", 0},
+		{"tomorrow", "", 0},
+		{"tomorrow 25:00", "", 0},
+		{"next month 19:00", "", 0},
+		{"in a few days", "", 0},
+		{"invalid date event", "", 0},
+	}
+	for _, test := range tests {
+		result, used, err := ParseTime(strings.Fields(test.input), now)
+		if test.expected == "" {
+			if err == nil {
+				t.Fatalf("%q: expected an error, got %s", test.input, result)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%q: unexpected error %s", test.input, err.Error())
+		}
+		if got := result.Format(EVENTBOT_TIME_FORMAT); got != test.expected {
+			t.Fatalf("%q: expected %s, got %s", test.input, test.expected, got)
+		}
+		if result.Location() != loc {
+			t.Fatalf("%q: expected location %s, got %s", test.input, loc,
+				result.Location())
+		}
+		if used != test.used {
+			t.Fatalf("%q: expected %d words used, got %d", test.input,
+				test.used, used)
+		}
+	}
+}
+
+func TestParseWeekday(t *testing.T) {
+	for input, expected := range map[string]time.Weekday{
+		"tue": time.Tuesday, "TU": time.Tuesday, "Dienstag": time.Tuesday,
+		"do": time.Thursday, "So.": time.Sunday, "sonnabend": time.Saturday,
+		"fr": time.Friday,
+	} {
+		if day, ok := ParseWeekday(input); !ok || day != expected {
+			t.Fatalf("%q: expected %s, got %s", input, expected, day)
+		}
+	}
+	if _, ok := ParseWeekday("x"); ok {
+		t.Fatalf("Single letters must not be parsed as weekdays")
+	}
+}
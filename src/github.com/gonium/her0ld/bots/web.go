@@ -0,0 +1,375 @@
+package her0ldbot
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/gonium/her0ld"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	WEB_MONTH_FORMAT = "2006-01"
+	// Number of upcoming dates on the page of a recurring event.
+	WEB_NEXT_OCCURRENCES = 5
+	WEB_CONTENT_TYPE     = "text/html; charset=utf-8"
+)
+
+/* An event as shown on the web pages. */
+type webEvent struct {
+	Event
+	Attendees  []string
+	Recurrence string
+}
+
+/* The data common to all pages. */
+type webPage struct {
+	BotName string
+	Title   string
+}
+
+/* A day of the month calendar. */
+type calendarDay struct {
+	Date    time.Time
+	InMonth bool
+	Today   bool
+	Events  EventList
+}
+
+/* The values of the add and edit form. */
+type formValues struct {
+	Description string
+	Start       string
+	End         string
+	Location    string
+	Url         string
+	Category    string
+	Tags        string
+	Rrule       string
+}
+
+// The functions of the web and mail templates.
+var templateFuncs = map[string]interface{}{
+	"date":  func(t time.Time) string { return t.Format(EVENTBOT_DISPLAY_TIME_FORMAT) },
+	"day":   func(t time.Time) string { return t.Format("Mon 2 Jan") },
+	"clock": func(t time.Time) string { return t.Format("15:04") },
+	// format formats a time with a Go layout, e.g. "02.01.2006"
+	"format": func(layout string, t time.Time) string { return t.Format(layout) },
+	"join":   strings.Join,
+	"sameDay": func(a, b time.Time) bool {
+		b = b.In(a.Location())
+		return a.Year() == b.Year() && a.YearDay() == b.YearDay()
+	},
+}
+
+// loadTemplates parses the built-in templates of the web frontend and
+// replaces those for which dir contains a file <name>.html.
+func loadTemplates(dir string) (*template.Template, error) {
+	root := template.New("").Funcs(templateFuncs)
+	for name, text := range webTemplates {
+		if dir != "" {
+			file := filepath.Join(dir, name+".html")
+			custom, err := ioutil.ReadFile(file)
+			if err == nil {
+				text = string(custom)
+			} else if !os.IsNotExist(err) {
+				return nil, err
+			}
+		}
+		if _, err := root.New(name).Parse(text); err != nil {
+			return nil, err
+		}
+	}
+	return root, nil
+}
+
+// render executes a template and answers with the page. The page is
+// rendered completely first, so that errors result in a clean 500.
+func (b *EventBot) render(w http.ResponseWriter, status int, name string,
+	data interface{}) {
+	var doc bytes.Buffer
+	if err := b.templates.ExecuteTemplate(&doc, name, data); err != nil {
+		log.Printf("Eventbot: Failed to render %s - %s", name, err.Error())
+		http.Error(w, "Failed to render page", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", WEB_CONTENT_TYPE)
+	w.WriteHeader(status)
+	doc.WriteTo(w)
+}
+
+// webEvents adds the attendees and descriptions of the recurrences.
+func (b *EventBot) webEvents(events EventList) []*webEvent {
+	attendees := b.attendees(events)
+	var retval []*webEvent
+	for _, event := range events {
+		e := &webEvent{
+			Event:     event,
+			Attendees: attendeeNames(attendees[event.Id]),
+		}
+		if rec, err := event.Recurrence(); err == nil && rec != nil {
+			e.Recurrence = rec.Describe(b.TimeLocation)
+		}
+		retval = append(retval, e)
+	}
+	return retval
+}
+
+// csrfToken returns the value of the hidden form field that proves that
+// a form was loaded by the holder of the API token.
+func csrfToken(token string) string {
+	mac := hmac.New(sha256.New, []byte(token))
+	mac.Write([]byte("her0ld form"))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// authorizeForm checks the credentials of the form pages: HTTP basic
+// authentication with the name and value of an API token. It returns
+// the token, or answers with a request for credentials.
+func (b *EventBot) authorizeForm(w http.ResponseWriter, r *http.Request) (her0ld.APIToken, bool) {
+	name, password, ok := r.BasicAuth()
+	if ok {
+		for _, t := range b.APITokens {
+			if hmac.Equal([]byte(t.Name), []byte(name)) &&
+				hmac.Equal([]byte(t.Token), []byte(password)) {
+				return t, true
+			}
+		}
+	}
+	if len(b.APITokens) == 0 {
+		http.Error(w, "Editing events on the web is disabled", http.StatusForbidden)
+	} else {
+		w.Header().Set("WWW-Authenticate", `Basic realm="her0ld"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	}
+	return her0ld.APIToken{}, false
+}
+
+func (b *EventBot) serveIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	category := r.URL.Query().Get("category")
+	var events EventList
+	for _, event := range b.upcomingEvents(time.Now()) {
+		if category == "" || event.InCategory(category) {
+			events = append(events, event)
+		}
+	}
+	b.render(w, http.StatusOK, "list", struct {
+		webPage
+		Category string
+		Events   []*webEvent
+	}{
+		webPage:  webPage{BotName: b.BotName, Title: "Upcoming events"},
+		Category: category,
+		Events:   b.webEvents(events),
+	})
+}
+
+func (b *EventBot) serveCalendar(w http.ResponseWriter, r *http.Request) {
+	now := time.Now().In(b.TimeLocation)
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, b.TimeLocation)
+	if value := r.URL.Query().Get("month"); value != "" {
+		t, err := time.ParseInLocation(WEB_MONTH_FORMAT, value, b.TimeLocation)
+		if err != nil {
+			http.Error(w, "Invalid month, use e.g. 2016-05", http.StatusBadRequest)
+			return
+		}
+		month = t
+	}
+	// the weeks start on Monday
+	first := month.AddDate(0, 0, -((int(month.Weekday()) + 6) % 7))
+	next := month.AddDate(0, 1, 0)
+	last := next
+	if offset := (int(next.Weekday()) + 6) % 7; offset != 0 {
+		last = next.AddDate(0, 0, 7-offset)
+	}
+	events := b.eventsBetween(first, last)
+	today, _ := b.dayBounds(now)
+	var weeks [][]calendarDay
+	for day := first; day.Before(last); day = day.AddDate(0, 0, 7) {
+		var week []calendarDay
+		for i := 0; i < 7; i++ {
+			date := day.AddDate(0, 0, i)
+			cd := calendarDay{
+				Date:    date,
+				InMonth: date.Month() == month.Month(),
+				Today:   date.Equal(today),
+			}
+			for _, event := range events {
+				if !event.Starttime.Before(date) && event.Starttime.Before(date.AddDate(0, 0, 1)) {
+					cd.Events = append(cd.Events, event)
+				}
+			}
+			week = append(week, cd)
+		}
+		weeks = append(weeks, week)
+	}
+	b.render(w, http.StatusOK, "calendar", struct {
+		webPage
+		Prev, Next string
+		Weekdays   []string
+		Weeks      [][]calendarDay
+	}{
+		webPage:  webPage{BotName: b.BotName, Title: month.Format("January 2006")},
+		Prev:     month.AddDate(0, -1, 0).Format(WEB_MONTH_FORMAT),
+		Next:     next.Format(WEB_MONTH_FORMAT),
+		Weekdays: []string{"Mon", "Tue", "Wed", "Thu", "Fri", "Sat", "Sun"},
+		Weeks:    weeks,
+	})
+}
+
+// serveEvents serves /events/<id>, /events/<id>/edit and /events/new.
+func (b *EventBot) serveEvents(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/events/"), "/"), "/")
+	switch {
+	case len(parts) == 1 && parts[0] == "new":
+		b.serveForm(w, r, nil)
+	case len(parts) == 1:
+		b.serveEvent(w, r, parts[0])
+	case len(parts) == 2 && parts[1] == "edit":
+		event, ok := b.findEvent(parts[0])
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		b.serveForm(w, r, &event)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (b *EventBot) serveEvent(w http.ResponseWriter, r *http.Request, id string) {
+	event, ok := b.findEvent(id)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	event.Starttime = event.Starttime.In(b.TimeLocation)
+	var occurrences EventList
+	if event.Rrule != "" {
+		exceptions := b.exceptions([]Event{event})
+		from := time.Now()
+		for to := from.Add(EVENTBOT_RECURRENCE_HORIZON); len(occurrences) < WEB_NEXT_OCCURRENCES &&
+			to.Before(from.AddDate(2, 0, 0)); to = to.Add(EVENTBOT_RECURRENCE_HORIZON) {
+			occurrences = event.Occurrences(from, to, b.TimeLocation,
+				exceptions[event.Id])
+		}
+		if len(occurrences) > WEB_NEXT_OCCURRENCES {
+			occurrences = occurrences[:WEB_NEXT_OCCURRENCES]
+		}
+	}
+	b.render(w, http.StatusOK, "event", struct {
+		webPage
+		Event       *webEvent
+		Occurrences EventList
+	}{
+		webPage:     webPage{BotName: b.BotName, Title: event.Description},
+		Event:       b.webEvents(EventList{event})[0],
+		Occurrences: occurrences,
+	})
+}
+
+// serveForm shows and processes the form for adding an event, or
+// editing the given event.
+func (b *EventBot) serveForm(w http.ResponseWriter, r *http.Request, event *Event) {
+	token, ok := b.authorizeForm(w, r)
+	if !ok {
+		return
+	}
+	title, action := "Add event", "/events/new"
+	var values formValues
+	if event != nil {
+		title = "Edit event"
+		action = "/events/" + strconv.Itoa(event.Id) + "/edit"
+		values = formValues{
+			Description: event.Description,
+			Start:       event.Starttime.In(b.TimeLocation).Format(EVENTBOT_TIME_FORMAT),
+			End:         b.formatEnd(event),
+			Location:    event.Location,
+			Url:         event.Url,
+			Category:    event.Category,
+			Tags:        strings.Join(event.TagList(), ", "),
+			Rrule:       event.Rrule,
+		}
+	}
+	page := struct {
+		webPage
+		Action string
+		CSRF   string
+		Error  string
+		Values formValues
+	}{
+		webPage: webPage{BotName: b.BotName, Title: title},
+		Action:  action,
+		CSRF:    csrfToken(token.Token),
+		Values:  values,
+	}
+	if r.Method == "GET" {
+		b.render(w, http.StatusOK, "form", page)
+		return
+	}
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !hmac.Equal([]byte(r.PostFormValue("csrf")), []byte(page.CSRF)) {
+		http.Error(w, "Invalid form, please reload the page", http.StatusForbidden)
+		return
+	}
+	page.Values = formValues{
+		Description: r.PostFormValue("description"),
+		Start:       r.PostFormValue("start"),
+		End:         r.PostFormValue("end"),
+		Location:    r.PostFormValue("location"),
+		Url:         r.PostFormValue("url"),
+		Category:    r.PostFormValue("category"),
+		Tags:        r.PostFormValue("tags"),
+		Rrule:       r.PostFormValue("rrule"),
+	}
+	tags := []string{page.Values.Tags}
+	input := apiEventInput{
+		Description: &page.Values.Description,
+		Start:       &page.Values.Start,
+		End:         &page.Values.End,
+		Location:    &page.Values.Location,
+		Url:         &page.Values.Url,
+		Category:    &page.Values.Category,
+		Tags:        &tags,
+		Rrule:       &page.Values.Rrule,
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	changedBy := "web:" + token.Name
+	var edited Event
+	if event != nil {
+		edited = *event
+	}
+	changes, err := b.applyInput(&edited, input)
+	if err != nil {
+		page.Error = err.Message
+		b.render(w, http.StatusBadRequest, "form", page)
+		return
+	}
+	if event == nil {
+		b.Db.Create(&edited)
+		log.Printf("Eventbot: %s added event %d on %s", changedBy, edited.Id,
+			edited.Starttime.Format(EVENTBOT_TIME_FORMAT))
+	} else if len(changes) > 0 {
+		b.updateEvent(edited, event.Starttime, changes, changedBy)
+	}
+	http.Redirect(w, r, "/events/"+strconv.Itoa(edited.Id), http.StatusSeeOther)
+}
@@ -0,0 +1,147 @@
+package her0ldbot
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// webRequest sends a request to the web frontend, with the credentials
+// of the test API token if auth is set.
+func webRequest(bot *EventBot, method, path string, form url.Values,
+	auth bool) *httptest.ResponseRecorder {
+	var req *http.Request
+	if form != nil {
+		req = httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		req = httptest.NewRequest(method, path, nil)
+	}
+	if auth {
+		req.SetBasicAuth("website", testAPIToken)
+	}
+	recorder := httptest.NewRecorder()
+	bot.Handler().ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestWebPages(t *testing.T) {
+	bot := mkAPIBot()
+	start := time.Now().In(bot.TimeLocation).AddDate(0, 0, 1).Truncate(time.Hour)
+	bot.Db.Create(&Event{Starttime: start, Description: "Meetup <b>now</b>",
+		Category: "meetup", Tags: "python", Rrule: "FREQ=WEEKLY"})
+	bot.Db.Create(&Event{Starttime: start.Add(time.Hour), Description: "Talk",
+		Category: "talk"})
+
+	for _, test := range []struct {
+		path       string
+		status     int
+		expected   []string
+		unexpected []string
+	}{
+		{"/", 200, []string{"Meetup &lt;b&gt;now&lt;/b&gt;", "Talk", "weekly"},
+			[]string{"<b>now</b>"}},
+		{"/?category=python", 200, []string{"Meetup"}, []string{"Talk"}},
+		{"/calendar?month=" + start.Format(WEB_MONTH_FORMAT), 200,
+			[]string{start.Format("January 2006"), "Talk"}, nil},
+		{"/calendar?month=May", 400, nil, nil},
+		{"/events/1", 200, []string{"Meetup", "Next dates", "python", "/events/1/edit"}, nil},
+		{"/events/3", 404, nil, nil},
+		{"/nothing", 404, nil, nil},
+	} {
+		recorder := webRequest(bot, "GET", test.path, nil, false)
+		if recorder.Code != test.status {
+			t.Fatalf("%s: expected status %d, got %d", test.path, test.status,
+				recorder.Code)
+		}
+		body := recorder.Body.String()
+		for _, expected := range test.expected {
+			if !strings.Contains(body, expected) {
+				t.Fatalf("%s misses %q:\n%s", test.path, expected, body)
+			}
+		}
+		for _, unexpected := range test.unexpected {
+			if strings.Contains(body, unexpected) {
+				t.Fatalf("%s contains %q:\n%s", test.path, unexpected, body)
+			}
+		}
+	}
+}
+
+func TestWebForm(t *testing.T) {
+	bot := mkAPIBot()
+	if recorder := webRequest(bot, "GET", "/events/new", nil, false); recorder.Code != 401 {
+		t.Fatalf("The form needs authentication, got status %d", recorder.Code)
+	}
+	recorder := webRequest(bot, "GET", "/events/new", nil, true)
+	csrf := csrfToken(testAPIToken)
+	if recorder.Code != 200 || !strings.Contains(recorder.Body.String(), csrf) {
+		t.Fatalf("Unexpected form %d:\n%s", recorder.Code, recorder.Body.String())
+	}
+
+	form := url.Values{
+		"csrf":        {csrf},
+		"description": {"Meetup"},
+		"start":       {"05.05.2016-19:00"},
+		"end":         {"22:00"},
+		"tags":        {"python, go"},
+	}
+	if recorder := webRequest(bot, "POST", "/events/new", form, true); recorder.Code != 303 ||
+		recorder.Header().Get("Location") != "/events/1" {
+		t.Fatalf("Expected a redirect to the new event, got %d %s", recorder.Code,
+			recorder.Header().Get("Location"))
+	}
+	var event Event
+	bot.Db.First(&event)
+	if event.Description != "Meetup" || event.Tags != "python,go" || event.Endtime.IsZero() {
+		t.Fatalf("Unexpected event %#v", event)
+	}
+
+	form.Set("start", "someday")
+	recorder = webRequest(bot, "POST", "/events/1/edit", form, true)
+	if recorder.Code != 400 || !strings.Contains(recorder.Body.String(), "someday") {
+		t.Fatalf("Expected the form with an error, got %d:\n%s", recorder.Code,
+			recorder.Body.String())
+	}
+	form.Set("start", "06.05.2016-19:00")
+	webRequest(bot, "POST", "/events/1/edit", form, true)
+	bot.Db.First(&event)
+	if !event.Starttime.Equal(time.Date(2016, 5, 6, 19, 0, 0, 0, bot.TimeLocation)) {
+		t.Fatalf("Event not moved: %#v", event)
+	}
+	var audits []EventAudit
+	bot.Db.Find(&audits)
+	if len(audits) != 1 || audits[0].ChangedBy != "web:website" {
+		t.Fatalf("Unexpected audit records %#v", audits)
+	}
+
+	form.Set("csrf", "forged")
+	if recorder := webRequest(bot, "POST", "/events/1/edit", form, true); recorder.Code != 403 {
+		t.Fatalf("Forged form accepted with status %d", recorder.Code)
+	}
+}
+
+func TestWebTemplateOverride(t *testing.T) {
+	dir, err := ioutil.TempDir("", "her0ld-templates")
+	if err != nil {
+		t.Fatalf("Failed to create template directory: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	custom := `{{template "header" .}}<p>Our events: {{len .Events}}</p>{{template "footer" .}}`
+	if err := ioutil.WriteFile(filepath.Join(dir, "list.html"), []byte(custom), 0644); err != nil {
+		t.Fatalf("Failed to write template: %s", err.Error())
+	}
+	bot := MkEventBot()
+	bot.TemplateDir = dir
+	recorder := webRequest(bot, "GET", "/", nil, false)
+	if !strings.Contains(recorder.Body.String(), "<p>Our events: 0</p>") ||
+		!strings.Contains(recorder.Body.String(), "<nav>") {
+		t.Fatalf("Custom template not used:\n%s", recorder.Body.String())
+	}
+}
@@ -0,0 +1,120 @@
+package her0ldbot
+
+// The built-in templates of the web frontend. Each of them can be
+// replaced by a file <name>.html in the template directory, see
+// HttpSettings.TemplateDir. The pages use the blocks "header" and
+// "footer" of the layout template.
+var webTemplates = map[string]string{
+	"layout": `{{define "header"}}<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="UTF-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1">
+	<title>{{.Title}} - {{.BotName}}</title>
+	<link rel="alternate" type="text/calendar" href="/events.ics" title="iCalendar">
+	<link rel="alternate" type="application/atom+xml" href="/events.atom" title="Atom">
+	<link rel="alternate" type="application/rss+xml" href="/events.rss" title="RSS">
+	<style>
+		body { font-family: sans-serif; max-width: 60em; margin: auto; padding: 0 1em; }
+		nav a { margin-right: 1em; }
+		table.calendar { border-collapse: collapse; width: 100%; table-layout: fixed; }
+		table.calendar td { border: 1px solid #ccc; vertical-align: top; height: 6em; padding: 0.2em; }
+		table.calendar td.other { color: #aaa; }
+		table.calendar td.today { background: #ffd; }
+		.error { color: #c00; }
+		.meta { color: #666; }
+		form label { display: block; margin-top: 0.5em; }
+	</style>
+</head>
+<body>
+<nav>
+	<a href="/">Upcoming events</a>
+	<a href="/calendar">Calendar</a>
+	<a href="/events/new">Add event</a>
+	<a href="/events.ics">iCalendar</a>
+	<a href="/events.atom">Atom</a>
+</nav>
+<h1>{{.Title}}</h1>
+{{end}}
+{{define "footer"}}
+</body>
+</html>
+{{end}}
+{{define "when"}}{{date .Starttime}}{{if not .Endtime.IsZero}} - {{if sameDay .Starttime .Endtime}}{{clock .Endtime}}{{else}}{{date .Endtime}}{{end}}{{end}}{{end}}`,
+
+	"list": `{{template "header" .}}
+{{if .Category}}<p>Category: {{.Category}} (<a href="/">show all</a>)</p>{{end}}
+{{if .Events}}
+<ul>
+{{range .Events}}
+	<li>
+		{{template "when" .Event}}:
+		<a href="/events/{{.Id}}">{{.Description}}</a>
+		{{if .Location}}@ {{.Location}}{{end}}
+		{{if .Category}}<a class="meta" href="/?category={{.Category}}">[{{.Category}}]</a>{{end}}
+		{{if .Recurrence}}<span class="meta">({{.Recurrence}})</span>{{end}}
+		{{if .Attendees}}<span class="meta">{{len .Attendees}} attending</span>{{end}}
+	</li>
+{{end}}
+</ul>
+{{else}}
+<p>No upcoming events.</p>
+{{end}}
+{{template "footer" .}}`,
+
+	"calendar": `{{template "header" .}}
+<p>
+	<a href="/calendar?month={{.Prev}}">&laquo; previous month</a> |
+	<a href="/calendar?month={{.Next}}">next month &raquo;</a>
+</p>
+<table class="calendar">
+	<tr>{{range .Weekdays}}<th>{{.}}</th>{{end}}</tr>
+{{range .Weeks}}
+	<tr>
+	{{range .}}
+		<td class="{{if not .InMonth}}other{{end}}{{if .Today}} today{{end}}">
+			{{.Date.Day}}
+			{{range .Events}}
+			<div><a href="/events/{{.Id}}">{{clock .Starttime}} {{.Description}}</a></div>
+			{{end}}
+		</td>
+	{{end}}
+	</tr>
+{{end}}
+</table>
+{{template "footer" .}}`,
+
+	"event": `{{template "header" .}}
+{{with .Event}}
+<p>{{template "when" .Event}}{{if .Recurrence}}, {{.Recurrence}}{{end}}</p>
+{{if .Location}}<p>Location: {{.Location}}</p>{{end}}
+{{if .Url}}<p><a href="{{.Url}}">{{.Url}}</a></p>{{end}}
+{{if .Category}}<p>Category: <a href="/?category={{.Category}}">{{.Category}}</a></p>{{end}}
+{{if .TagList}}<p>Tags: {{range $i, $tag := .TagList}}{{if $i}}, {{end}}<a href="/?category={{$tag}}">{{$tag}}</a>{{end}}</p>{{end}}
+<p>{{len .Attendees}} attending{{if .Attendees}}: {{join .Attendees ", "}}{{end}}</p>
+{{end}}
+{{if .Occurrences}}
+<h2>Next dates</h2>
+<ul>
+{{range .Occurrences}}<li>{{template "when" .}}</li>{{end}}
+</ul>
+{{end}}
+<p><a href="/events/{{.Event.Id}}/edit">Edit</a></p>
+{{template "footer" .}}`,
+
+	"form": `{{template "header" .}}
+{{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+<form method="post" action="{{.Action}}">
+	<input type="hidden" name="csrf" value="{{.CSRF}}">
+	<label>Description <input name="description" value="{{.Values.Description}}" required></label>
+	<label>Start <input name="start" value="{{.Values.Start}}" placeholder="01.01.2016-16:00 or next friday 20:00" required></label>
+	<label>End <input name="end" value="{{.Values.End}}" placeholder="22:00"></label>
+	<label>Location <input name="location" value="{{.Values.Location}}"></label>
+	<label>URL <input name="url" type="url" value="{{.Values.Url}}"></label>
+	<label>Category <input name="category" value="{{.Values.Category}}"></label>
+	<label>Tags <input name="tags" value="{{.Values.Tags}}" placeholder="python, beginners"></label>
+	<label>Repeat <input name="rrule" value="{{.Values.Rrule}}" placeholder="weekly on tue"></label>
+	<p><input type="submit" value="Save"></p>
+</form>
+{{template "footer" .}}`,
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	irc "github.com/thoj/go-ircevent"
+	"log"
+	"strings"
+	"sync"
+)
+
+// Capabilities needed to know the services accounts of channel members,
+// see https://ircv3.net/specs/extensions/account-notify and
+// https://ircv3.net/specs/extensions/extended-join
+var accountCapabilities = []string{"account-notify", "extended-join"}
+
+// WHOX query for channel, nick and account of all channel members. The
+// server answers with 354 <me> <channel> <nick> <account>.
+const ACCOUNT_WHO_FIELDS = "%cna"
+
+/* The accountTracker remembers the services accounts of the users that
+ * share a channel with the bot. Accounts are only known while a user is
+ * visible to us - once the user leaves all our channels, the account is
+ * forgotten because we would not learn about changes anymore. */
+type accountTracker struct {
+	mutex sync.Mutex
+	users map[string]*trackedUser
+}
+
+type trackedUser struct {
+	account  string
+	channels map[string]bool
+}
+
+func newAccountTracker() *accountTracker {
+	return &accountTracker{users: make(map[string]*trackedUser)}
+}
+
+func accountKey(nick string) string {
+	return strings.ToLower(nick)
+}
+
+// normalizeAccount maps the "not logged in" markers of the different
+// messages to the empty string.
+func normalizeAccount(account string) string {
+	if account == "*" || account == "0" {
+		return ""
+	}
+	return account
+}
+
+// reset forgets all users, e.g. after the connection was lost.
+func (t *accountTracker) reset() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.users = make(map[string]*trackedUser)
+}
+
+// Account returns the account of the given nick or the empty string if
+// the user is not logged in or unknown.
+func (t *accountTracker) Account(nick string) string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if user, ok := t.users[accountKey(nick)]; ok {
+		return user.account
+	}
+	return ""
+}
+
+func (t *accountTracker) join(nick, channel, account string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	user, ok := t.users[accountKey(nick)]
+	if !ok {
+		user = &trackedUser{channels: make(map[string]bool)}
+		t.users[accountKey(nick)] = user
+	}
+	user.channels[strings.ToLower(channel)] = true
+	user.account = normalizeAccount(account)
+}
+
+func (t *accountTracker) setAccount(nick, account string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if user, ok := t.users[accountKey(nick)]; ok {
+		user.account = normalizeAccount(account)
+	}
+}
+
+func (t *accountTracker) part(nick, channel string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	user, ok := t.users[accountKey(nick)]
+	if !ok {
+		return
+	}
+	delete(user.channels, strings.ToLower(channel))
+	if len(user.channels) == 0 {
+		delete(t.users, accountKey(nick))
+	}
+}
+
+// partAll forgets the members of a channel the bot has left.
+func (t *accountTracker) partAll(channel string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	channel = strings.ToLower(channel)
+	for key, user := range t.users {
+		delete(user.channels, channel)
+		if len(user.channels) == 0 {
+			delete(t.users, key)
+		}
+	}
+}
+
+func (t *accountTracker) quit(nick string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	delete(t.users, accountKey(nick))
+}
+
+func (t *accountTracker) rename(oldnick, newnick string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if user, ok := t.users[accountKey(oldnick)]; ok {
+		delete(t.users, accountKey(oldnick))
+		t.users[accountKey(newnick)] = user
+	}
+}
+
+// setupAccountTracking requests the account capabilities and registers
+// the callbacks that keep the account tracker up to date.
+func (c *connection) setupAccountTracking(ircconn *irc.Connection,
+	caps *capNegotiation) {
+	accounts := c.accounts
+	ircconn.AddCallback("CAP", func(e *irc.Event) {
+		subcommand, capabilities := capReply(e)
+		if !hasCapability(capabilities, accountCapabilities[0]) {
+			return
+		}
+		if subcommand == "NAK" {
+			log.Printf("%s: Server does not support %s, roles bound to accounts will not work.",
+				c, strings.Join(accountCapabilities, " and "))
+		}
+		caps.done()
+	})
+	// extended-join: JOIN <channel> <account> :<realname>
+	ircconn.AddCallback("JOIN", func(e *irc.Event) {
+		if len(e.Arguments) < 1 {
+			return
+		}
+		channel := e.Arguments[0]
+		if e.Nick == ircconn.GetNick() {
+			// learn the accounts of the users already in the channel.
+			ircconn.SendRawf("WHO %s %s", channel, ACCOUNT_WHO_FIELDS)
+		}
+		account := ""
+		if len(e.Arguments) >= 2 {
+			account = e.Arguments[1]
+		}
+		accounts.join(e.Nick, channel, account)
+	})
+	// RPL_WHOSPCRPL: 354 <me> <channel> <nick> <account>
+	ircconn.AddCallback("354", func(e *irc.Event) {
+		if len(e.Arguments) < 4 {
+			return
+		}
+		accounts.join(e.Arguments[2], e.Arguments[1], e.Arguments[3])
+	})
+	ircconn.AddCallback("ACCOUNT", func(e *irc.Event) {
+		if len(e.Arguments) < 1 {
+			return
+		}
+		accounts.setAccount(e.Nick, e.Arguments[0])
+	})
+	ircconn.AddCallback("NICK", func(e *irc.Event) {
+		if len(e.Arguments) < 1 {
+			return
+		}
+		accounts.rename(e.Nick, e.Message())
+	})
+	ircconn.AddCallback("PART", func(e *irc.Event) {
+		if len(e.Arguments) < 1 {
+			return
+		}
+		if e.Nick == ircconn.GetNick() {
+			accounts.partAll(e.Arguments[0])
+		} else {
+			accounts.part(e.Nick, e.Arguments[0])
+		}
+	})
+	// KICK <channel> <nick> :<reason>
+	ircconn.AddCallback("KICK", func(e *irc.Event) {
+		if len(e.Arguments) < 2 {
+			return
+		}
+		if e.Arguments[1] == ircconn.GetNick() {
+			accounts.partAll(e.Arguments[0])
+		} else {
+			accounts.part(e.Arguments[1], e.Arguments[0])
+		}
+	})
+	ircconn.AddCallback("QUIT", func(e *irc.Event) {
+		accounts.quit(e.Nick)
+	})
+}
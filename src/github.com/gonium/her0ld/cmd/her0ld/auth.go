@@ -0,0 +1,183 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"github.com/gonium/her0ld"
+	irc "github.com/thoj/go-ircevent"
+	"log"
+	"strings"
+	"time"
+)
+
+const (
+	// SASL messages are sent in chunks of at most 400 bytes, see
+	// https://ircv3.net/specs/extensions/sasl-3.1
+	SASL_CHUNK_SIZE = 400
+	// Channels are joined this long after the registration if NickServ
+	// does not confirm the identification.
+	NICKSERV_JOIN_TIMEOUT = 10 * time.Second
+)
+
+// loadClientCertificate adds the configured client certificate to the
+// TLS configuration of the connection.
+func (c *connection) loadClientCertificate(tlscfg *tls.Config) error {
+	if c.cfg.TLSCertFile == "" {
+		return nil
+	}
+	keyfile := c.cfg.TLSKeyFile
+	if keyfile == "" {
+		keyfile = c.cfg.TLSCertFile
+	}
+	cert, err := tls.LoadX509KeyPair(c.cfg.TLSCertFile, keyfile)
+	if err != nil {
+		return fmt.Errorf("cannot load client certificate: %s", err.Error())
+	}
+	tlscfg.Certificates = []tls.Certificate{cert}
+	return nil
+}
+
+func (c *connection) saslMechanism() string {
+	switch c.cfg.Auth.Method {
+	case her0ld.AUTH_SASL_PLAIN:
+		return "PLAIN"
+	case her0ld.AUTH_SASL_EXTERNAL:
+		return "EXTERNAL"
+	}
+	return ""
+}
+
+// setupAuthentication registers the callbacks for SASL and NickServ
+// authentication. The returned function reports whether the connection
+// identifies with NickServ after the registration, in which case
+// joinChannels is called once NickServ confirms the identification.
+func (c *connection) setupAuthentication(ircconn *irc.Connection,
+	caps *capNegotiation, joinChannels func() bool) func() bool {
+	auth := c.cfg.Auth
+	if auth.Method == her0ld.AUTH_NONE {
+		return func() bool { return false }
+	}
+	identify := auth.Method == her0ld.AUTH_NICKSERV
+
+	saslFailed := func(reason string) {
+		// let the server finish the registration in any case
+		caps.done()
+		if auth.NickServFallback {
+			log.Printf("%s: SASL authentication failed (%s), falling back to NickServ.",
+				c, reason)
+			identify = true
+			return
+		}
+		log.Printf("%s: SASL authentication as %s failed: %s. Check the Auth settings of this connection.",
+			c, auth.Account, reason)
+		c.mutex.Lock()
+		c.authFailed = true
+		c.mutex.Unlock()
+		ircconn.Quit()
+	}
+
+	ircconn.AddCallback("CAP", func(e *irc.Event) {
+		subcommand, capabilities := capReply(e)
+		if !hasCapability(capabilities, "sasl") {
+			return
+		}
+		switch subcommand {
+		case "ACK":
+			// the SASL exchange replaces the capability request
+			ircconn.SendRawf("AUTHENTICATE %s", c.saslMechanism())
+		case "NAK":
+			saslFailed("server does not support SASL")
+		}
+	})
+	ircconn.AddCallback("AUTHENTICATE", func(e *irc.Event) {
+		if e.Message() != "+" {
+			return
+		}
+		if auth.Method == her0ld.AUTH_SASL_PLAIN {
+			payload := auth.Account + "\x00" + auth.Account + "\x00" + auth.Password
+			for _, line := range saslPayload(payload) {
+				ircconn.SendRawf("AUTHENTICATE %s", line)
+			}
+		} else {
+			// EXTERNAL uses the client certificate of the TLS connection
+			ircconn.SendRaw("AUTHENTICATE +")
+		}
+	})
+	// RPL_LOGGEDIN, also sent by many servers after a NickServ
+	// identification
+	ircconn.AddCallback("900", func(e *irc.Event) {
+		log.Printf("%s: %s", c, e.Message())
+		joinChannels()
+	})
+	// RPL_SASLSUCCESS
+	ircconn.AddCallback("903", func(e *irc.Event) {
+		caps.done()
+	})
+	// ERR_NICKLOCKED, ERR_SASLFAIL, ERR_SASLTOOLONG, ERR_SASLABORTED,
+	// ERR_SASLMECHS
+	for _, code := range []string{"902", "904", "905", "906", "908"} {
+		ircconn.AddCallback(code, func(e *irc.Event) {
+			saslFailed(e.Message())
+		})
+	}
+
+	ircconn.AddCallback("001", func(e *irc.Event) {
+		if !identify {
+			return
+		}
+		if auth.Account != "" {
+			ircconn.Privmsgf("NickServ", "IDENTIFY %s %s", auth.Account,
+				auth.Password)
+		} else {
+			ircconn.Privmsgf("NickServ", "IDENTIFY %s", auth.Password)
+		}
+	})
+	ircconn.AddCallback("NOTICE", func(e *irc.Event) {
+		if !identify || !strings.EqualFold(e.Nick, "NickServ") {
+			return
+		}
+		reply := strings.ToLower(e.Message())
+		if strings.Contains(reply, "invalid") ||
+			strings.Contains(reply, "incorrect") {
+			log.Printf("%s: NickServ identification failed: %s", c, e.Message())
+			// waiting longer does not help
+			joinChannels()
+			return
+		}
+		if c.verbose {
+			log.Printf("%s: NickServ: %s", c, e.Message())
+		}
+		if strings.Contains(reply, "you are now identified") ||
+			strings.Contains(reply, "password accepted") {
+			joinChannels()
+		}
+	})
+	return func() bool { return identify }
+}
+
+// startCapNegotiation prepares the capability requests, which are sent
+// before NICK and USER, see dialWithPreamble. A CAP REQ during the
+// registration makes the server suspend the registration until CAP END.
+func (c *connection) startCapNegotiation(caps *capNegotiation) {
+	caps.request(accountCapabilities...)
+	if c.saslMechanism() != "" {
+		caps.request("sasl")
+	}
+}
+
+// saslPayload encodes a SASL response and splits it into
+// AUTHENTICATE arguments.
+func saslPayload(payload string) []string {
+	encoded := base64.StdEncoding.EncodeToString([]byte(payload))
+	var lines []string
+	for len(encoded) >= SASL_CHUNK_SIZE {
+		lines = append(lines, encoded[:SASL_CHUNK_SIZE])
+		encoded = encoded[SASL_CHUNK_SIZE:]
+	}
+	if encoded == "" {
+		// an empty line terminates a payload of n*400 bytes
+		encoded = "+"
+	}
+	return append(lines, encoded)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	irc "github.com/thoj/go-ircevent"
+	"strings"
+	"sync"
+)
+
+/* capNegotiation keeps track of the outstanding capability requests of
+ * a connection attempt. The server suspends the registration until CAP
+ * END, which is sent once all requests and the SASL exchange have been
+ * answered. */
+type capNegotiation struct {
+	mutex   sync.Mutex
+	ircconn *irc.Connection
+	lines   []string
+	pending int
+	ended   bool
+}
+
+func newCapNegotiation(ircconn *irc.Connection) *capNegotiation {
+	return &capNegotiation{ircconn: ircconn}
+}
+
+// request asks the server for the given capabilities. The requests are
+// sent before the registration, see requests. The answer must be
+// acknowledged with done.
+func (n *capNegotiation) request(caps ...string) {
+	n.begin()
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.lines = append(n.lines, "CAP REQ :"+strings.Join(caps, " "))
+}
+
+// requests returns the lines of the capability requests.
+func (n *capNegotiation) requests() []string {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	return n.lines
+}
+
+// begin delays the end of the negotiation until done is called, e.g.
+// for the SASL exchange.
+func (n *capNegotiation) begin() {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.pending++
+}
+
+func (n *capNegotiation) done() {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if n.pending > 0 {
+		n.pending--
+	}
+	if n.pending == 0 && !n.ended {
+		n.ended = true
+		n.ircconn.SendRaw("CAP END")
+	}
+}
+
+// capReply returns the subcommand (ACK or NAK) and the capabilities of a
+// CAP reply: CAP <nick> ACK|NAK :<capabilities>
+func capReply(e *irc.Event) (string, string) {
+	if len(e.Arguments) < 3 {
+		return "", ""
+	}
+	return e.Arguments[1], e.Message()
+}
+
+func hasCapability(caps string, name string) bool {
+	for _, capability := range strings.Fields(caps) {
+		if strings.EqualFold(capability, name) {
+			return true
+		}
+	}
+	return false
+}
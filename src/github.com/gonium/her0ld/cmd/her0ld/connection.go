@@ -0,0 +1,376 @@
+package main
+
+import (
+	"crypto/tls"
+	"expvar"
+	"fmt"
+	"github.com/gonium/her0ld"
+	"github.com/gonium/her0ld/bots"
+	irc "github.com/thoj/go-ircevent"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	// Statistics of the connection supervisor, published via expvar.
+	// They are served at /debug/vars by the web server of the eventbot,
+	// to holders of an API token only: without an eventbot with
+	// HttpSettings.ListenAddress and APITokens, they cannot be read.
+	connectStats    = expvar.NewMap("her0ld_connects")
+	disconnectStats = expvar.NewMap("her0ld_disconnects")
+)
+
+/* A connection bundles the IRC connection to one server with the bots
+ * that answer the messages received on it. Run supervises the
+ * connection and reconnects whenever it is lost. */
+type connection struct {
+	cfg     her0ld.BotConnection
+	bots    []her0ldbot.Bot
+	verbose bool
+	ircconn *irc.Connection
+	// shared by all connection attempts, so that the callbacks can read
+	// it without locking the connection.
+	accounts *accountTracker
+
+	// state of the current connection attempt, written by the callbacks.
+	mutex       sync.Mutex
+	registered  bool
+	serverError string
+	authFailed  bool
+}
+
+func newConnection(cfg her0ld.BotConnection, bots []her0ldbot.Bot,
+	verbose bool) *connection {
+	return &connection{
+		cfg:      cfg,
+		bots:     bots,
+		verbose:  verbose,
+		accounts: newAccountTracker(),
+	}
+}
+
+func (c *connection) String() string {
+	return fmt.Sprintf("%s@%s", c.cfg.Nick, c.cfg.Server)
+}
+
+// Start connects to the server. Callbacks join the configured channels
+// and forward all messages to the bots.
+func (c *connection) Start() error {
+	ircconn := irc.IRC(c.cfg.Nick, c.cfg.Fullname)
+	if ircconn == nil {
+		return fmt.Errorf("nick and fullname must not be empty")
+	}
+	if c.verbose {
+		ircconn.Debug = true
+		//ircconn.VerboseCallbackHandler = true
+	}
+	// TLS is handled by the relay, see dialWithPreamble
+	var tlscfg *tls.Config
+	if c.cfg.EnableTLS {
+		tlscfg = &tls.Config{
+			InsecureSkipVerify: !c.cfg.StrictCertCheck,
+		}
+		if err := c.loadClientCertificate(tlscfg); err != nil {
+			return err
+		}
+	}
+	ircconn.PingFreq = 1 * time.Minute
+	ircconn.QuitMessage = c.cfg.Quitmsg
+
+	c.mutex.Lock()
+	c.registered = false
+	c.serverError = ""
+	c.authFailed = false
+	c.mutex.Unlock()
+	c.accounts.reset()
+
+	caps := newCapNegotiation(ircconn)
+	joinChannels := c.channelJoiner(ircconn)
+	identifying := c.setupAuthentication(ircconn, caps, joinChannels)
+	c.setupAccountTracking(ircconn, caps)
+
+	// Join channels upon welcome message, or after the identification
+	// with NickServ, as channels may be restricted to registered users.
+	ircconn.AddCallback("001", func(e *irc.Event) {
+		c.mutex.Lock()
+		c.registered = true
+		c.mutex.Unlock()
+		log.Printf("%s: Registered as %s", c, e.Arguments[0])
+		if !identifying() {
+			joinChannels()
+			return
+		}
+		time.AfterFunc(NICKSERV_JOIN_TIMEOUT, func() {
+			if joinChannels() {
+				log.Printf("%s: NickServ did not confirm the identification, joined the channels anyway.", c)
+			}
+		})
+	})
+	// When end of nick list of channel received: send hello message
+	// to channel
+	ircconn.AddCallback("366", func(e *irc.Event) {
+		//ircconn.Privmsg(channel, "bot is active")
+	})
+	// The library disconnects on ERROR, which also removes all callbacks.
+	// We only remember the reason - the server closes the connection
+	// anyway and Run takes care of the rest.
+	ircconn.ClearCallback("ERROR")
+	ircconn.AddCallback("ERROR", func(e *irc.Event) {
+		c.mutex.Lock()
+		c.serverError = e.Message()
+		c.mutex.Unlock()
+	})
+	// Try the alternative nicks if our nick is taken.
+	nicks := append([]string{c.cfg.Nick}, c.cfg.AltNicks...)
+	nextNick := 1
+	nickInUse := func(e *irc.Event) {
+		c.mutex.Lock()
+		registered := c.registered
+		c.mutex.Unlock()
+		if registered || len(e.Arguments) < 2 {
+			// we already have a nick, keep it.
+			return
+		}
+		var nick string
+		if nextNick < len(nicks) {
+			nick = nicks[nextNick]
+			nextNick++
+		} else {
+			nick = e.Arguments[1] + "_"
+		}
+		log.Printf("%s: Nick %s is not available, trying %s", c,
+			e.Arguments[1], nick)
+		ircconn.SendRawf("NICK %s", nick)
+	}
+	ircconn.ClearCallback("433")
+	ircconn.AddCallback("433", nickInUse)
+	ircconn.ClearCallback("437")
+	ircconn.AddCallback("437", nickInUse)
+	// forward PRIVMSG to bots
+	ircconn.AddCallback("PRIVMSG", c.handlePrivmsg)
+
+	c.startCapNegotiation(caps)
+	relay, err := dialWithPreamble(c.cfg.Server, tlscfg, ircconn.Timeout,
+		caps.requests())
+	if err != nil {
+		return err
+	}
+	if c.verbose {
+		log.Printf("%s: Connected, requested capabilities: %v", c, caps.requests())
+	}
+
+	c.mutex.Lock()
+	c.ircconn = ircconn
+	c.mutex.Unlock()
+	return ircconn.Connect(relay)
+}
+
+// channelJoiner returns a function that joins the configured channels
+// of the connection attempt, once it is registered. Only the first
+// successful call joins, it reports whether it did.
+func (c *connection) channelJoiner(ircconn *irc.Connection) func() bool {
+	joined := false
+	return func() bool {
+		c.mutex.Lock()
+		if joined || !c.registered || c.ircconn != ircconn {
+			c.mutex.Unlock()
+			return false
+		}
+		joined = true
+		c.mutex.Unlock()
+		for _, channel := range c.cfg.Channels {
+			if channel.Key != "" {
+				ircconn.Join(channel.Name + " " + channel.Key)
+			} else {
+				ircconn.Join(channel.Name)
+			}
+		}
+		return true
+	}
+}
+
+// Run connects to the server and blocks until quit is closed. Lost
+// connections are reestablished with an exponential backoff.
+func (c *connection) Run(quit <-chan struct{}) {
+	attempt := 0
+	for {
+		var reason string
+		connectStats.Add(c.String(), 1)
+		if err := c.Start(); err != nil {
+			reason = "connect"
+			log.Printf("%s: Failed to connect: %s", c, err.Error())
+		} else {
+			select {
+			case err := <-c.ircconn.ErrorChan():
+				var description string
+				reason, description = c.disconnectReason(err)
+				log.Printf("%s: Disconnected (%s): %s", c, reason, description)
+			case <-quit:
+				log.Printf("%s: Terminating connection.", c)
+				c.ircconn.Quit()
+				// give the QUIT message some time to reach the server.
+				time.Sleep(1 * time.Second)
+				return
+			}
+			c.mutex.Lock()
+			if c.registered {
+				attempt = 0
+			}
+			// announcements wait for the next connection
+			c.registered = false
+			authFailed := c.authFailed
+			c.mutex.Unlock()
+			// stop the goroutines of the dead connection, after nothing
+			// is sent anymore
+			go c.ircconn.Disconnect()
+			if authFailed {
+				// reconnecting would fail the same way.
+				log.Printf("%s: Authentication failed, not reconnecting.", c)
+				return
+			}
+		}
+		disconnectStats.Add(c.String()+" "+reason, 1)
+
+		if c.cfg.Reconnect.MaxAttempts > 0 && attempt >= c.cfg.Reconnect.MaxAttempts {
+			log.Printf("%s: Giving up after %d failed attempts.", c, attempt)
+			return
+		}
+		delay := c.cfg.Reconnect.Delay(attempt)
+		attempt++
+		log.Printf("%s: Reconnecting in %s", c, delay)
+		select {
+		case <-time.After(delay):
+		case <-quit:
+			return
+		}
+	}
+}
+
+// disconnectReason classifies the error that terminated the connection.
+// It returns a short reason used for the statistics and a description
+// for the log.
+func (c *connection) disconnectReason(err error) (string, string) {
+	c.mutex.Lock()
+	serverError := c.serverError
+	c.mutex.Unlock()
+	if serverError != "" {
+		return "server-error", serverError
+	}
+	if neterr, ok := err.(net.Error); ok && neterr.Timeout() {
+		return "timeout", err.Error()
+	}
+	if err == io.EOF {
+		return "closed", "connection closed by server"
+	}
+	return "error", err.Error()
+}
+
+func (c *connection) handlePrivmsg(event *irc.Event) {
+	//event.Message() contains the message
+	//event.Nick and event.Source contain the sender
+	//event.Arguments[0] contains the channel or, for queries, our nick
+	msg := her0ldbot.InboundMessage{
+		Target:   event.Arguments[0],
+		Nick:     event.Nick,
+		Source:   event.Source,
+		Account:  c.accounts.Account(event.Nick),
+		Message:  event.Message(),
+		BotNick:  event.Connection.GetNick(),
+		Followup: c.followup,
+	}
+	if msg.IsChannelEvent() {
+		// channel message
+		if c.verbose {
+			log.Printf("%s: Inbound channel message: %s", c, msg)
+		}
+		for _, bot := range c.channelBots(msg.Target) {
+			answerlines, err := bot.ProcessChannelEvent(msg)
+			if err != nil {
+				log.Printf("Bot %s failed to process inbound channel message \"%s\": %s",
+					bot.GetName(), event.Message(), err.Error())
+			} else {
+				c.send(event.Connection, answerlines)
+			}
+		}
+	} else {
+		// query message - the bots answer to the sender.
+		if c.verbose {
+			log.Printf("%s: Inbound query message: %s", c, msg)
+		}
+		for _, bot := range c.bots {
+			answerlines, err := bot.ProcessQueryEvent(msg)
+			if err != nil {
+				log.Printf("Bot %s failed to process inbound query message \"%s\": %s",
+					bot.GetName(), event.Message(), err.Error())
+			} else {
+				c.send(event.Connection, answerlines)
+			}
+		}
+	}
+}
+
+// channelBots returns the bots that are active in the given channel.
+// Channels without configuration, e.g. after an invite, get all bots.
+func (c *connection) channelBots(channel string) []her0ldbot.Bot {
+	cc, ok := c.cfg.FindChannel(channel)
+	if !ok {
+		return c.bots
+	}
+	var retval []her0ldbot.Bot
+	for _, bot := range c.bots {
+		if cc.BotEnabled(bot.GetName()) {
+			retval = append(retval, bot)
+		}
+	}
+	return retval
+}
+
+// Announce sends lines of bot to all configured channels in which the
+// bot is active. Lines are dropped while the connection is down.
+func (c *connection) Announce(bot her0ldbot.Bot, lines []string) {
+	c.mutex.Lock()
+	registered := c.registered
+	ircconn := c.ircconn
+	c.mutex.Unlock()
+	if !registered {
+		log.Printf("%s: Not connected, dropping %d lines of %s", c, len(lines),
+			bot.GetName())
+		return
+	}
+	for _, channel := range c.cfg.Channels {
+		if !channel.BotEnabled(bot.GetName()) {
+			continue
+		}
+		for _, line := range lines {
+			ircconn.Privmsg(channel.Name, line)
+		}
+	}
+}
+
+// followup sends answers that the bots send after processing a
+// message. Answers are dropped while the connection is down.
+func (c *connection) followup(lines []her0ldbot.OutboundMessage) {
+	c.mutex.Lock()
+	registered := c.registered
+	ircconn := c.ircconn
+	c.mutex.Unlock()
+	if !registered {
+		log.Printf("%s: Not connected, dropping %d answers", c, len(lines))
+		return
+	}
+	c.send(ircconn, lines)
+}
+
+func (c *connection) send(ircconn *irc.Connection, lines []her0ldbot.OutboundMessage) {
+	for _, line := range lines {
+		currentnick := ircconn.GetNick()
+		if line.Destination != currentnick {
+			ircconn.Privmsg(line.Destination, line.Message)
+		} else {
+			log.Printf("Line destination is %s, would send to myself (%s). Dropping line.", line.Destination, currentnick)
+		}
+	}
+}
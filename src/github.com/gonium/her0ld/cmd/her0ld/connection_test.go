@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"github.com/gonium/her0ld"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+/* ircServer is a fake IRC server for a single client. */
+type ircServer struct {
+	t        *testing.T
+	listener net.Listener
+	conn     net.Conn
+	lines    chan string
+}
+
+func newIRCServer(t *testing.T) *ircServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err.Error())
+	}
+	return &ircServer{t: t, listener: listener, lines: make(chan string, 100)}
+}
+
+// accept waits for the client and reads its lines in the background.
+func (s *ircServer) accept() {
+	conn, err := s.listener.Accept()
+	if err != nil {
+		s.t.Fatalf("Failed to accept: %s", err.Error())
+	}
+	s.conn = conn
+	go func() {
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			s.lines <- scanner.Text()
+		}
+		close(s.lines)
+	}()
+}
+
+func (s *ircServer) send(line string) {
+	s.conn.Write([]byte(line + "\r\n"))
+}
+
+// expect reads the next line of the client, ignoring PINGs and
+// MODEs, and checks its prefix.
+func (s *ircServer) expect(prefix string) string {
+	for {
+		select {
+		case line, ok := <-s.lines:
+			if !ok {
+				s.t.Fatalf("Connection closed, expected %q", prefix)
+			}
+			if strings.HasPrefix(line, "PING") || strings.HasPrefix(line, "MODE") {
+				continue
+			}
+			if !strings.HasPrefix(line, prefix) {
+				s.t.Fatalf("Expected %q, got %q", prefix, line)
+			}
+			return line
+		case <-time.After(5 * time.Second):
+			s.t.Fatalf("Timeout, expected %q", prefix)
+		}
+	}
+}
+
+// expectNothing checks that the client sends no line for a while.
+func (s *ircServer) expectNothing() {
+	select {
+	case line := <-s.lines:
+		s.t.Fatalf("Unexpected line %q", line)
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+
+func (s *ircServer) close() {
+	if s.conn != nil {
+		s.conn.Close()
+	}
+	s.listener.Close()
+}
+
+func mkTestConnection(server string, auth her0ld.AuthSettings) *connection {
+	return newConnection(her0ld.BotConnection{
+		Server:   server,
+		Nick:     "her0ld",
+		Fullname: "her0ld",
+		Channels: []her0ld.ChannelConfig{{Name: "#test"}},
+		Auth:     auth,
+	}, nil, false)
+}
+
+// The capability requests must reach the server before the
+// registration, otherwise it may complete the registration without
+// SASL.
+func TestCapRequestsBeforeRegistration(t *testing.T) {
+	server := newIRCServer(t)
+	defer server.close()
+	c := mkTestConnection(server.listener.Addr().String(), her0ld.AuthSettings{
+		Method: her0ld.AUTH_SASL_PLAIN, Account: "her0ld", Password: "secret"})
+	if err := c.Start(); err != nil {
+		t.Fatalf("Failed to connect: %s", err.Error())
+	}
+	server.accept()
+	server.expect("CAP REQ :account-notify extended-join")
+	server.expect("CAP REQ :sasl")
+	server.expect("NICK her0ld")
+	server.expect("USER her0ld")
+
+	server.send(":server CAP * ACK :account-notify extended-join")
+	server.send(":server CAP * ACK :sasl")
+	server.expect("AUTHENTICATE PLAIN")
+	server.send("AUTHENTICATE +")
+	server.expect("AUTHENTICATE ")
+	server.send(":server 900 her0ld her0ld!her0ld@host her0ld :You are now logged in as her0ld")
+	server.send(":server 903 her0ld :SASL authentication successful")
+	server.expect("CAP END")
+	server.send(":server 001 her0ld :Welcome")
+	server.expect("JOIN #test")
+}
+
+// With NickServ, channels are joined after the identification.
+func TestJoinAfterNickServ(t *testing.T) {
+	server := newIRCServer(t)
+	defer server.close()
+	c := mkTestConnection(server.listener.Addr().String(), her0ld.AuthSettings{
+		Method: her0ld.AUTH_NICKSERV, Password: "secret"})
+	if err := c.Start(); err != nil {
+		t.Fatalf("Failed to connect: %s", err.Error())
+	}
+	server.accept()
+	server.expect("CAP REQ :account-notify extended-join")
+	server.expect("NICK her0ld")
+	server.expect("USER her0ld")
+	server.send(":server CAP * NAK :account-notify extended-join")
+	server.expect("CAP END")
+	server.send(":server 001 her0ld :Welcome")
+	server.expect("PRIVMSG NickServ :IDENTIFY secret")
+	server.expectNothing()
+	server.send(":NickServ!NickServ@services NOTICE her0ld :You are now identified for her0ld.")
+	server.expect("JOIN #test")
+}
+
+// The relay passes on that the server closed the connection.
+func TestServerClosesConnection(t *testing.T) {
+	server := newIRCServer(t)
+	defer server.close()
+	c := mkTestConnection(server.listener.Addr().String(), her0ld.AuthSettings{})
+	if err := c.Start(); err != nil {
+		t.Fatalf("Failed to connect: %s", err.Error())
+	}
+	server.accept()
+	server.expect("CAP REQ :account-notify extended-join")
+	server.expect("NICK her0ld")
+	server.conn.Close()
+	select {
+	case err := <-c.ircconn.ErrorChan():
+		if reason, _ := c.disconnectReason(err); reason != "closed" {
+			t.Fatalf("Expected a closed connection, got %s (%v)", reason, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Closed connection not detected")
+	}
+}
@@ -1,14 +1,15 @@
 package main
 
 import (
-	"crypto/tls"
+	"fmt"
 	"github.com/codegangsta/cli"
 	"github.com/gonium/her0ld"
 	"github.com/gonium/her0ld/bots"
-	irc "github.com/thoj/go-ircevent"
+	"github.com/jinzhu/gorm"
 	"log"
 	"os"
 	"os/signal"
+	"sync"
 	"time"
 )
 
@@ -23,11 +24,7 @@ func main() {
 			Name:  "genconfig",
 			Usage: "Generates a sample configuration file",
 			Flags: []cli.Flag{
-				cli.StringFlag{
-					Name:  "config",
-					Value: "her0ld.cfg",
-					Usage: "the configuration file to use",
-				},
+				configFlag,
 			},
 			Action: func(c *cli.Context) error {
 				cfgfile := c.String("config")
@@ -41,14 +38,240 @@ func main() {
 			},
 		},
 		{
-			Name:  "run",
-			Usage: "Run the bot",
+			Name:  "events",
+			Usage: "Exports and imports the events of the database",
+			Subcommands: []cli.Command{
+				{
+					Name:  "export",
+					Usage: "Writes all events as JSON, CSV or iCalendar",
+					Flags: []cli.Flag{
+						configFlag,
+						cli.StringFlag{
+							Name:  "output",
+							Usage: "the file to write, standard output if not given",
+						},
+						cli.StringFlag{
+							Name:  "format",
+							Usage: "json, csv or ics, by default from the output file or json",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						eventbot := openEventBot(c)
+						defer eventbot.Db.Close()
+						output := c.String("output")
+						format := c.String("format")
+						if format == "" {
+							format = her0ldbot.ExportFormat(output)
+						}
+						if format == "" {
+							format = her0ldbot.EXPORT_FORMAT_JSON
+						}
+						out := os.Stdout
+						if output != "" {
+							var err error
+							if out, err = os.Create(output); err != nil {
+								return fail("Failed to create %s: %s", output, err.Error())
+							}
+						}
+						err := eventbot.ExportEvents(out, format)
+						if closeErr := out.Close(); err == nil {
+							err = closeErr
+						}
+						if err != nil {
+							return fail("Failed to export events: %s", err.Error())
+						}
+						return nil
+					},
+				},
+				{
+					Name:      "import",
+					Usage:     "Reads events from JSON, CSV or iCalendar files",
+					ArgsUsage: "<file>...",
+					Flags: []cli.Flag{
+						configFlag,
+						cli.StringFlag{
+							Name:  "format",
+							Usage: "json, csv or ics, by default from the file names",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						if c.NArg() == 0 {
+							return fail("No file given")
+						}
+						eventbot := openEventBot(c)
+						defer eventbot.Db.Close()
+						for _, file := range c.Args() {
+							format := c.String("format")
+							if format == "" {
+								format = her0ldbot.ExportFormat(file)
+							}
+							if format == "" {
+								return fail("Unknown format of %s, use --format", file)
+							}
+							in, err := os.Open(file)
+							if err != nil {
+								return fail("Failed to open %s: %s", file, err.Error())
+							}
+							result, err := eventbot.ImportEvents(in, format)
+							in.Close()
+							if err != nil {
+								return fail("Failed to import %s: %s", file, err.Error())
+							}
+							log.Printf("%s: %s", file, result)
+						}
+						return nil
+					},
+				},
+			},
+		},
+		{
+			Name:      "import-ics",
+			Usage:     "Imports the events of iCalendar files or URLs",
+			ArgsUsage: "<file or URL>...",
 			Flags: []cli.Flag{
+				configFlag,
+			},
+			Action: func(c *cli.Context) error {
+				if c.NArg() == 0 {
+					log.Fatalf("No iCalendar file or URL given")
+				}
+				eventbot := openEventBot(c)
+				defer eventbot.Db.Close()
+				for _, source := range c.Args() {
+					body, err := her0ldbot.OpenICS(source)
+					if err != nil {
+						return fail("Failed to open %s: %s", source, err.Error())
+					}
+					result, err := eventbot.ImportICS(body)
+					body.Close()
+					if err != nil {
+						return fail("Failed to import %s: %s", source, err.Error())
+					}
+					log.Printf("%s: %s", source, result)
+				}
+				return nil
+			},
+		},
+		{
+			Name:  "migrate",
+			Usage: "Shows and changes the version of the database schema",
+			Subcommands: []cli.Command{
+				{
+					Name:  "status",
+					Usage: "Lists the migrations and whether they are applied",
+					Flags: []cli.Flag{configFlag},
+					Action: func(c *cli.Context) error {
+						migrator := openMigrator(c)
+						defer migrator.Db.Close()
+						return printMigrationStatus(migrator)
+					},
+				},
+				{
+					Name:  "up",
+					Usage: "Applies the pending migrations, only SQLite databases are backed up before",
+					Flags: []cli.Flag{
+						configFlag,
+						cli.IntFlag{
+							Name:  "to",
+							Usage: "the version to migrate to, the latest if not given",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						migrator := openMigrator(c)
+						defer migrator.Db.Close()
+						if _, err := migrator.Up(c.Int("to")); err != nil {
+							return fail("Failed to migrate database: %s", err.Error())
+						}
+						return printMigrationStatus(migrator)
+					},
+				},
+				{
+					Name:  "down",
+					Usage: "Reverts the newest migration, only SQLite databases are backed up before",
+					Flags: []cli.Flag{
+						configFlag,
+						cli.IntFlag{
+							Name:  "to",
+							Value: -1,
+							Usage: "the version to revert to, the initial schema cannot be reverted",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						migrator := openMigrator(c)
+						defer migrator.Db.Close()
+						target := c.Int("to")
+						if target < 0 {
+							version, err := migrator.Version()
+							if err != nil {
+								return fail("Failed to read database version: %s", err.Error())
+							}
+							if version == 0 {
+								return fail("No migrations applied")
+							}
+							target = version - 1
+						}
+						if _, err := migrator.Down(target); err != nil {
+							return fail("Failed to migrate database: %s", err.Error())
+						}
+						return printMigrationStatus(migrator)
+					},
+				},
+			},
+		},
+		{
+			Name:      "render-mail",
+			Usage:     "Prints the mail of a mail job for the events in the database",
+			ArgsUsage: "<job>",
+			Flags: []cli.Flag{
+				configFlag,
 				cli.StringFlag{
-					Name:  "config",
-					Value: "her0ld.cfg",
-					Usage: "the configuration file to use",
+					Name:  "date",
+					Usage: "render the mail as sent on this day, e.g. 2016-05-02",
 				},
+				cli.StringFlag{
+					Name:  "format",
+					Value: "text",
+					Usage: "text, html or mime for the complete message",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				if c.NArg() != 1 {
+					log.Fatalf("Give the name of one mail job")
+				}
+				eventbot := openEventBot(c)
+				defer eventbot.Db.Close()
+				now := time.Now()
+				if date := c.String("date"); date != "" {
+					var err error
+					if now, err = time.ParseInLocation("2006-01-02", date,
+						eventbot.TimeLocation); err != nil {
+						return fail("Invalid date %s, use e.g. 2016-05-02", date)
+					}
+				}
+				mail, err := eventbot.RenderEventList(c.Args().First(), now)
+				if err != nil {
+					return fail("Failed to render mail: %s", err.Error())
+				}
+				switch c.String("format") {
+				case "text":
+					fmt.Printf("Subject: %s\n\n%s", mail.Subject, mail.Text)
+				case "html":
+					fmt.Print(mail.HTML)
+				case "mime":
+					if _, err := mail.WriteTo(os.Stdout); err != nil {
+						return fail("Failed to render mail: %s", err.Error())
+					}
+				default:
+					return fail("Unknown format %s, use text, html or mime", c.String("format"))
+				}
+				return nil
+			},
+		},
+		{
+			Name:  "run",
+			Usage: "Run the bot",
+			Flags: []cli.Flag{
+				configFlag,
 				cli.BoolFlag{
 					Name: "verbose",
 					//Aliases: []string{"v"},
@@ -66,14 +289,31 @@ func main() {
 					log.Fatalf("Failed to load config: %s", err.Error())
 				}
 
-				// TODO: Deal with several channel/nick configurations
-				channel := cfg.Bots[0].Channel
-				nick := cfg.Bots[0].Nick
-				fullname := cfg.Bots[0].Fullname
-				server := cfg.Bots[0].Server
-				quitmsg := cfg.Bots[0].Quitmsg
-				enable_tls := cfg.Bots[0].EnableTLS
-				strictcertcheck := cfg.Bots[0].StrictCertCheck
+				connections := cfg.EnabledBots()
+				if len(connections) == 0 {
+					log.Fatalf("No enabled bot connection found in %s", cfgfile)
+				}
+				for _, bc := range connections {
+					if err := bc.Auth.Validate(bc); err != nil {
+						log.Fatalf("Invalid authentication settings for %s@%s: %s",
+							bc.Nick, bc.Server, err.Error())
+					}
+				}
+
+				// The database holds the events and the roles granted at
+				// runtime. It is shared between all connections.
+				var db *gorm.DB
+				if cfg.EventbotCfg.HasDatabase() {
+					db, err = her0ldbot.OpenDatabase(cfg.EventbotCfg)
+					if err != nil {
+						log.Fatalf("Failed to open database: %s", err.Error())
+					}
+					defer db.Close()
+				}
+				permissions, err := her0ldbot.NewPermissions(cfg.General, db)
+				if err != nil {
+					return fail("Invalid role configuration: %s", err.Error())
+				}
 
 				// whenever the bot should be terminated, send a 'true' to this
 				// channel.
@@ -91,119 +331,72 @@ func main() {
 					}
 				}()
 
-				ircconn := irc.IRC(nick, fullname)
-				if c.Bool("verbose") {
-					ircconn.Debug = true
-					//ircconn.VerboseCallbackHandler = true
-				}
-				ircconn.UseTLS = enable_tls
-				ircconn.TLSConfig = &tls.Config{
-					InsecureSkipVerify: !strictcertcheck,
-				}
-				ircconn.PingFreq = 1 * time.Minute
-				ircconn.QuitMessage = quitmsg
-
-				var allBots []her0ldbot.Bot
-				if cfg.Functions.Echobot_enable {
-					// the echobot is only helpful during development...
-					allBots = append(allBots, her0ldbot.NewEchoBot("Echobot"))
-				}
-				if cfg.Functions.Pingbot_enable {
-					allBots = append(allBots, her0ldbot.NewPingBot("Pingbot"))
-				}
-				if cfg.Functions.Eventbot_enable {
-					allBots = append(allBots, her0ldbot.NewEventBot("Eventbot",
-						cfg.EventbotCfg, cfg.General))
-				}
-				// Always add the help bot - handles !help.
-				allBots = append(allBots, her0ldbot.NewHelpBot("Helpbot",
-					allBots))
-
-				// Join channel upon welcome message
-				ircconn.AddCallback("001", func(e *irc.Event) {
-					ircconn.Join(channel)
-				})
-				// When end of nick list of channel received: send hello message
-				// to channel
-				ircconn.AddCallback("366", func(e *irc.Event) {
-					//ircconn.Privmsg(channel, "bot is active")
-				})
-
-				// forward PRIVMSG to bots
-				ircconn.AddCallback("PRIVMSG", func(event *irc.Event) {
-					//event.Message() contains the message
-					//event.Nick Contains the sender
-					//event.Arguments[0] Contains the channel
-					//for idx, arg := range event.Arguments {
-					//	log.Println("%d - %s", idx, arg)
-					//}
-					source := event.Arguments[0]
-					msg := her0ldbot.InboundMessage{
-						Channel: source,
-						Nick:    event.Nick,
-						Message: event.Message(),
+				// The eventbot is shared between all connections so that they
+				// use the same database.
+				var eventbot *her0ldbot.EventBot
+				mkBots := func(functions her0ld.BotEnable) []her0ldbot.Bot {
+					var allBots []her0ldbot.Bot
+					if functions.Echobot_enable {
+						// the echobot is only helpful during development...
+						allBots = append(allBots, her0ldbot.NewEchoBot("Echobot"))
 					}
-					if msg.IsChannelEvent() {
-						// channel message
-						if c.Bool("verbose") {
-							log.Printf("Inbound channel message: %s", msg)
-						}
-						for _, bot := range allBots {
-							answerlines, err := bot.ProcessChannelEvent(msg)
-							if err != nil {
-								log.Printf("Bot %s failed to process inbound channel message \"%s\": %s",
-									bot.GetName(), event.Message(), err.Error())
-							} else {
-								for _, line := range answerlines {
-									currentnick := ircconn.GetNick()
-									if line.Destination != currentnick {
-										ircconn.Privmsg(line.Destination, line.Message)
-									} else {
-										log.Printf("Line destination is %s, would send to myself (%s). Dropping line.", line.Destination, currentnick)
-									}
-
-								}
-							}
+					if functions.Pingbot_enable {
+						allBots = append(allBots, her0ldbot.NewPingBot("Pingbot"))
+					}
+					if functions.Eventbot_enable {
+						if db == nil {
+							log.Fatalf("The eventbot needs a database, configure it in %s", cfgfile)
 						}
-					} else {
-						// query message
-						// TODO: This is broken. event.Nick does not contain the sender,
-						// but the bot itself.
-						if c.Bool("verbose") {
-							log.Printf("Inbound query message: %s", msg)
+						if eventbot == nil {
+							eventbot = her0ldbot.NewEventBot("Eventbot", db,
+								cfg.EventbotCfg, cfg.General)
+							eventbot.Start()
 						}
-						for _, bot := range allBots {
-							answerlines, err := bot.ProcessQueryEvent(msg)
-							if err != nil {
-								log.Printf("Bot %s failed to process inbound query message \"%s\": %s",
-									bot.GetName(), event.Message(), err.Error())
-							} else {
-								for _, line := range answerlines {
-									currentnick := ircconn.GetNick()
-									if line.Destination != currentnick {
-										ircconn.Privmsg(line.Destination, line.Message)
-									} else {
-										log.Printf("Line destination is %s, would send to myself (%s). Dropping line.", line.Destination, currentnick)
-									}
-								}
-							}
+						allBots = append(allBots, eventbot)
+					}
+					// Always add the admin bot - handles !role and !whoami.
+					allBots = append(allBots, her0ldbot.NewAdminBot("Adminbot",
+						permissions))
+					// Always add the help bot - handles !help.
+					allBots = append(allBots, her0ldbot.NewHelpBot("Helpbot",
+						allBots))
+					for _, bot := range allBots {
+						router := bot.GetCommands()
+						router.Permissions = permissions
+						if cfg.General.CommandPrefix != "" {
+							router.Prefix = cfg.General.CommandPrefix
 						}
-
 					}
-				})
+					return allBots
+				}
 
-				// now, run the server
-				err = ircconn.Connect(server)
-				if err != nil {
-					log.Fatalf("Failed to connect to %s: %s", server, err.Error())
+				// run every connection in its own goroutine
+				done := make(chan struct{})
+				var wg sync.WaitGroup
+				for _, bc := range connections {
+					functions := cfg.FunctionsFor(bc)
+					conn := newConnection(bc, mkBots(functions),
+						c.Bool("verbose"))
+					if functions.Eventbot_enable {
+						// reminders go to the channels of the eventbot
+						eventbot.AddAnnouncer(conn)
+					}
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						conn.Run(done)
+					}()
 				}
 
 				// wait for termination signal
 				<-quit
 				// cleanup tasks
 				log.Printf("Terminating bot.")
-				ircconn.Quit()
-				time.Sleep(1 * time.Second)
+				close(done)
+				wg.Wait()
+				if eventbot != nil {
+					eventbot.Stop()
+				}
 
 				return nil
 			},
@@ -211,3 +404,60 @@ func main() {
 	}
 	app.Run(os.Args)
 }
+
+var configFlag = cli.StringFlag{
+	Name:  "config",
+	Value: "her0ld.cfg",
+	Usage: "the configuration file to use",
+}
+
+// openEventBot opens the database of the configuration given on the
+// command line and returns an eventbot without starting it.
+func openEventBot(c *cli.Context) *her0ldbot.EventBot {
+	cfg, err := her0ld.LoadConfig(c.String("config"))
+	if err != nil {
+		log.Fatalf("Failed to load config: %s", err.Error())
+	}
+	db, err := her0ldbot.OpenDatabase(cfg.EventbotCfg)
+	if err != nil {
+		log.Fatalf("Failed to open database: %s", err.Error())
+	}
+	return her0ldbot.NewEventBot("Eventbot", db, cfg.EventbotCfg, cfg.General)
+}
+
+// openMigrator connects to the database of the configuration given on
+// the command line, without applying migrations.
+func openMigrator(c *cli.Context) *her0ldbot.Migrator {
+	cfg, err := her0ld.LoadConfig(c.String("config"))
+	if err != nil {
+		log.Fatalf("Failed to load config: %s", err.Error())
+	}
+	db, err := her0ldbot.ConnectDatabase(cfg.EventbotCfg)
+	if err != nil {
+		log.Fatalf("Failed to open database: %s", err.Error())
+	}
+	return her0ldbot.NewMigrator(db, cfg.EventbotCfg)
+}
+
+func printMigrationStatus(migrator *her0ldbot.Migrator) error {
+	status, err := migrator.Status()
+	if err != nil {
+		return fail("Failed to read migrations: %s", err.Error())
+	}
+	for _, s := range status {
+		fmt.Println(s)
+	}
+	version, err := migrator.Version()
+	if err != nil {
+		return fail("Failed to read database version: %s", err.Error())
+	}
+	fmt.Printf("Database version %d, latest version %d\n", version, migrator.Latest())
+	return nil
+}
+
+// fail reports the error of a command and exits. Unlike log.Fatalf, it
+// runs the deferred calls of the command first, e.g. to close the
+// database.
+func fail(format string, args ...interface{}) error {
+	return cli.NewExitError(fmt.Sprintf(format, args...), 1)
+}
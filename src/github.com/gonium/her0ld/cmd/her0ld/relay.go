@@ -0,0 +1,64 @@
+package main
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"time"
+)
+
+// dialWithPreamble connects to the IRC server and sends the given lines
+// before anything else. go-ircevent sends NICK and USER as soon as it is
+// connected, so the capability requests could only follow the
+// registration. Instead, it talks to the server through a relay on the
+// loopback interface: the returned address is passed to Connect in
+// place of the server. The relay accepts a single connection within the
+// timeout and closes both sides once one of them is closed.
+func dialWithPreamble(server string, tlscfg *tls.Config, timeout time.Duration,
+	preamble []string) (string, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	var upstream net.Conn
+	var err error
+	if tlscfg != nil {
+		upstream, err = tls.DialWithDialer(dialer, "tcp", server, tlscfg)
+	} else {
+		upstream, err = dialer.Dial("tcp", server)
+	}
+	if err != nil {
+		return "", err
+	}
+	upstream.SetWriteDeadline(time.Now().Add(timeout))
+	for _, line := range preamble {
+		if _, err := io.WriteString(upstream, line+"\r\n"); err != nil {
+			upstream.Close()
+			return "", err
+		}
+	}
+	upstream.SetWriteDeadline(time.Time{})
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		upstream.Close()
+		return "", err
+	}
+	listener.SetDeadline(time.Now().Add(timeout))
+	go func() {
+		defer upstream.Close()
+		client, err := listener.Accept()
+		listener.Close()
+		if err != nil {
+			return
+		}
+		defer client.Close()
+		done := make(chan struct{}, 2)
+		go func() {
+			io.Copy(upstream, client)
+			done <- struct{}{}
+		}()
+		go func() {
+			io.Copy(client, upstream)
+			done <- struct{}{}
+		}()
+		<-done
+	}()
+	return listener.Addr().String(), nil
+}
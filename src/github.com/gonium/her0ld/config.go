@@ -1,46 +1,289 @@
 package her0ld
 
 import (
+	"fmt"
 	"github.com/BurntSushi/toml"
+	"log"
 	"os"
+	"strings"
+	"time"
 )
 
+const (
+	DEFAULT_RECONNECT_INITIAL_DELAY = 5   // seconds
+	DEFAULT_RECONNECT_MAX_DELAY     = 300 // seconds
+	AUTH_NONE                       = ""
+	AUTH_SASL_PLAIN                 = "sasl-plain"
+	AUTH_SASL_EXTERNAL              = "sasl-external"
+	AUTH_NICKSERV                   = "nickserv"
+	MAIL_WINDOW_TODAY               = "today"
+	MAIL_WINDOW_WEEK                = "week"
+	MAIL_WINDOW_NEXT7DAYS           = "next7days"
+	MAIL_TLS_NONE                   = "none"
+	MAIL_TLS_STARTTLS               = "starttls"
+	MAIL_TLS_IMPLICIT               = "implicit"
+	DB_SQLITE                       = "sqlite3"
+	DB_POSTGRES                     = "postgres"
+	DB_MYSQL                        = "mysql"
+)
+
+type ChannelConfig struct {
+	Name string
+	Key  string
+	// Names of the bots active in this channel, e.g. "Eventbot". If empty,
+	// all bots of the connection are active.
+	Bots []string
+}
+
+// BotEnabled reports whether the bot with the given name may answer in
+// this channel.
+func (cc ChannelConfig) BotEnabled(name string) bool {
+	if len(cc.Bots) == 0 {
+		return true
+	}
+	for _, b := range cc.Bots {
+		if strings.EqualFold(b, name) {
+			return true
+		}
+	}
+	return false
+}
+
+type ReconnectSettings struct {
+	// Delay before the first reconnection attempt, in seconds.
+	InitialDelay int
+	// Upper limit of the delay between two attempts, in seconds.
+	MaxDelay int
+	// Give up after this many failed attempts in a row. 0 retries forever.
+	MaxAttempts int
+}
+
+// Delay returns the time to wait before the given (zero-based)
+// reconnection attempt. The delay doubles with every attempt until it
+// reaches MaxDelay.
+func (rs ReconnectSettings) Delay(attempt int) time.Duration {
+	initial := rs.InitialDelay
+	if initial <= 0 {
+		initial = DEFAULT_RECONNECT_INITIAL_DELAY
+	}
+	max := rs.MaxDelay
+	if max <= 0 {
+		max = DEFAULT_RECONNECT_MAX_DELAY
+	}
+	delay := initial
+	for i := 0; i < attempt && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return time.Duration(delay) * time.Second
+}
+
+type AuthSettings struct {
+	// One of "sasl-plain", "sasl-external", "nickserv" or empty for no
+	// authentication.
+	Method   string
+	Account  string
+	Password string
+	// Identify with NickServ if SASL authentication fails.
+	NickServFallback bool
+}
+
+// Validate checks whether the authentication settings are complete.
+func (as AuthSettings) Validate(bc BotConnection) error {
+	switch as.Method {
+	case AUTH_NONE:
+		return nil
+	case AUTH_SASL_PLAIN:
+		if as.Account == "" || as.Password == "" {
+			return fmt.Errorf("%s needs an account and a password", as.Method)
+		}
+	case AUTH_SASL_EXTERNAL:
+		if !bc.EnableTLS || bc.TLSCertFile == "" {
+			return fmt.Errorf("%s needs TLS and a client certificate", as.Method)
+		}
+	case AUTH_NICKSERV:
+		if as.Password == "" {
+			return fmt.Errorf("%s needs a password", as.Method)
+		}
+	default:
+		return fmt.Errorf("unknown authentication method %q", as.Method)
+	}
+	if as.NickServFallback && as.Password == "" {
+		return fmt.Errorf("NickServ fallback needs a password")
+	}
+	return nil
+}
+
 type BotConnection struct {
-	Enabled         bool
-	Channel         string
-	Server          string
-	Nick            string
+	Enabled bool
+	// Deprecated: a single channel, LoadConfig adds it to Channels.
+	Channel  string `toml:",omitempty"`
+	Channels []ChannelConfig
+	Server   string
+	Nick     string
+	// Nicks to try in this order if Nick is already in use.
+	AltNicks        []string
 	Fullname        string
 	Quitmsg         string
 	EnableTLS       bool
 	StrictCertCheck bool
+	// Optional client certificate, e.g. for SASL EXTERNAL. The key file
+	// may be omitted if the certificate file contains the key.
+	TLSCertFile string
+	TLSKeyFile  string
+	Auth        AuthSettings
+	Reconnect   ReconnectSettings
+	// Optional per-connection bot selection. If not set, the global
+	// Functions section is used.
+	Functions *BotEnable
+}
+
+/* Binds a role (owner, admin, event-editor or user) to a hostmask like
+ * "*!*@example.com" or to a services account. */
+type RoleBinding struct {
+	Role     string
+	Hostmask string
+	Account  string
 }
 
 type GeneralConfig struct {
-	OwnerNick         string
+	// Deprecated: ignored, as anybody can use a nick. Bind the owner
+	// role to a hostmask or an account in Roles instead.
+	OwnerNick         string `toml:",omitempty"`
 	OwnerEmailAddress string
+	// Role of everybody without a role binding. Defaults to "user".
+	DefaultRole string
+	Roles       []RoleBinding
+	// Prefix of all bot commands, e.g. "!" or "{nick}:" to address the
+	// bot by its nick. Defaults to "!".
+	CommandPrefix string
 }
 
 type EmailSettings struct {
-	Enabled               bool
-	SMTPUsername          string
-	SMTPPassword          string
-	SMTPServer            string
-	SMTPPort              int
-	FromAddress           string
-	RecipientAddress      string
+	Enabled      bool
+	SMTPUsername string
+	SMTPPassword string
+	SMTPServer   string
+	SMTPPort     int
+	// One of "starttls" to require STARTTLS, "implicit" for SMTPS,
+	// usually on port 465, or "none" for unencrypted connections. If
+	// empty, STARTTLS is used if the server offers it.
+	TLSMode     string
+	FromAddress string
+	// Failed mails are retried with increasing delays until this many
+	// attempts failed. Defaults to 10.
+	MaxAttempts int
+	// Deprecated: used for a daily mail of today's events if no
+	// MailJobs are configured.
+	RecipientAddress string
+	// Default body of the event list mails, a Go text/template.
 	EventListMailTemplate string
+	// Files with the default text and HTML bodies of the event list
+	// mails. They replace EventListMailTemplate and the built-in HTML
+	// version and are read again for every mail.
+	EventListMailTemplateFile string
+	EventListMailHTMLFile     string
+}
+
+// Validate checks the TLS mode and the number of attempts.
+func (es EmailSettings) Validate() error {
+	switch es.TLSMode {
+	case "", MAIL_TLS_NONE, MAIL_TLS_STARTTLS, MAIL_TLS_IMPLICIT:
+	default:
+		return fmt.Errorf("unknown TLS mode %q, use %s, %s or %s", es.TLSMode,
+			MAIL_TLS_STARTTLS, MAIL_TLS_IMPLICIT, MAIL_TLS_NONE)
+	}
+	if es.MaxAttempts < 0 {
+		return fmt.Errorf("negative number of attempts")
+	}
+	return nil
+}
+
+/* A MailJob sends the events of a window to its recipients on a
+ * schedule, e.g. a daily mail of today's events or a weekly digest on
+ * Monday mornings. */
+type MailJob struct {
+	Name string
+	// Cron spec with a seconds field, e.g. "0 0 8 * * MON".
+	Schedule   string
+	Recipients []string
+	// One of "today", "week" (until Sunday) or "next7days".
+	Window string
+	// Template of the subject, e.g. "Events on {{day .Start}}".
+	Subject string
+	// Body of the mail, EmailSettings.EventListMailTemplate if empty.
+	Template string
+	// Files with the text and HTML bodies of this job, see
+	// EmailSettings.EventListMailTemplateFile.
+	TemplateFile     string
+	HTMLTemplateFile string
+	// Send the mail even if there are no events in the window.
+	SendEmpty bool
+}
+
+/* An APIToken allows its holder to change events via the JSON API.
+ * Changes are recorded with the name of the token. */
+type APIToken struct {
+	Name  string
+	Token string
 }
 
 type HttpSettings struct {
 	ListenAddress string
+	// Also needed to read the statistics at /debug/vars, e.g. of the
+	// connects and disconnects of the bot connections.
+	APITokens []APIToken
+	// Files <name>.html in this directory replace the built-in
+	// templates of the web pages: layout, list, calendar, event, form.
+	TemplateDir string
+}
+
+/* The eventbot announces events in its channels these lead times
+ * before they start, e.g. "24h" and "30m". */
+type ReminderSettings struct {
+	LeadTimes []string
+}
+
+/* The database of the bots. */
+type DatabaseSettings struct {
+	// One of "sqlite3" (the default), "postgres" or "mysql".
+	Dialect string
+	// The data source name: the file of an SQLite database, e.g.
+	// "host=localhost dbname=her0ld user=her0ld sslmode=disable" for
+	// PostgreSQL or "her0ld:secret@tcp(localhost:3306)/her0ld" for MySQL.
+	DSN string
+	// Do not update the schema at startup, but refuse to start until
+	// "her0ld migrate up" was run.
+	ManualMigrations bool
+}
+
+/* Backups of the database are written on a schedule into a directory,
+ * where the newest Keep backups are kept. */
+type BackupSettings struct {
+	// Cron spec with a seconds field, e.g. "0 0 3 * * *". No backups
+	// are written if empty.
+	Schedule  string
+	Directory string
+	// Number of backups to keep, defaults to 7.
+	Keep int
+	// One of "json", "csv" or "ics" for an export of the events. By
+	// default SQLite databases are copied and other databases are
+	// exported as JSON.
+	Format string
 }
 
 type EventbotConfig struct {
-	Timezone      string
+	Timezone string
+	// Deprecated: the file of an SQLite database, see Database.
 	DBFile        string
+	Database      DatabaseSettings
 	EmailSettings EmailSettings
 	HttpSettings  HttpSettings
+	Reminders     ReminderSettings
+	MailJobs      []MailJob
+	Backup        BackupSettings
 }
 
 type BotEnable struct {
@@ -59,25 +302,59 @@ type Config struct {
 func MkExampleConfig() Config {
 	return Config{
 		General: GeneralConfig{
-			OwnerNick:         "myowner",
 			OwnerEmailAddress: "owner@example.com",
+			DefaultRole:       "user",
+			Roles: []RoleBinding{
+				{Role: "owner", Hostmask: "myowner!*@owner.example.com"},
+				{Role: "owner", Account: "myowner"},
+				{Role: "event-editor", Hostmask: "*!*@hackerspace.example.com"},
+			},
+			CommandPrefix: "!",
 		},
 		Bots: []BotConnection{
 			{
-				Enabled:  true,
-				Channel:  "channel0",
+				Enabled: true,
+				Channels: []ChannelConfig{
+					{
+						Name: "#channel0",
+						Bots: []string{"Eventbot", "Pingbot", "Helpbot"},
+					},
+					{
+						Name: "#channel0-offtopic",
+						Key:  "secret",
+						Bots: []string{"Pingbot", "Helpbot"},
+					},
+				},
 				Server:   "server0",
 				Nick:     "nick0",
+				AltNicks: []string{"nick0_", "nick0-bot"},
 				Fullname: "fullname0",
 				Quitmsg:  "quitmsg0",
+				Auth: AuthSettings{
+					Method:           AUTH_SASL_PLAIN,
+					Account:          "account0",
+					Password:         "password0",
+					NickServFallback: true,
+				},
+				Reconnect: ReconnectSettings{
+					InitialDelay: DEFAULT_RECONNECT_INITIAL_DELAY,
+					MaxDelay:     DEFAULT_RECONNECT_MAX_DELAY,
+				},
 			},
 			{
-				Enabled:  false,
-				Channel:  "channel1",
+				Enabled: false,
+				Channels: []ChannelConfig{
+					{Name: "#channel1"},
+				},
 				Server:   "server1",
 				Nick:     "nick1",
 				Fullname: "fullname1",
 				Quitmsg:  "quitmsg1",
+				Functions: &BotEnable{
+					Echobot_enable:  false,
+					Pingbot_enable:  true,
+					Eventbot_enable: false,
+				},
 			},
 		},
 		Functions: BotEnable{
@@ -87,15 +364,148 @@ func MkExampleConfig() Config {
 		},
 		EventbotCfg: EventbotConfig{
 			Timezone: "Europe/Berlin",
-			DBFile:   "/tmp/her0ld-events.db",
+			Database: DatabaseSettings{
+				Dialect: DB_SQLITE,
+				DSN:     "/tmp/her0ld-events.db",
+			},
+			EmailSettings: EmailSettings{
+				SMTPServer:  "mail.example.com",
+				SMTPPort:    587,
+				TLSMode:     MAIL_TLS_STARTTLS,
+				FromAddress: "her0ld <her0ld@example.com>",
+				MaxAttempts: 10,
+			},
+			Reminders: ReminderSettings{
+				LeadTimes: []string{"24h", "30m"},
+			},
+			Backup: BackupSettings{
+				Schedule:  "0 0 3 * * *",
+				Directory: "/tmp/her0ld-backups",
+				Keep:      7,
+			},
+			MailJobs: []MailJob{
+				{
+					Name:       "today",
+					Schedule:   "0 0 8 * * *",
+					Recipients: []string{"members@example.com"},
+					Window:     MAIL_WINDOW_TODAY,
+					Subject:    "Today's events",
+				},
+				{
+					Name:       "weekly",
+					Schedule:   "0 0 8 * * MON",
+					Recipients: []string{"members@example.com", "announce@example.com"},
+					Window:     MAIL_WINDOW_WEEK,
+					Subject:    "Events from {{day .Start}}",
+					SendEmpty:  true,
+				},
+			},
 		},
 	}
 }
 
+// HasDatabase reports whether a database is configured.
+func (ec EventbotConfig) HasDatabase() bool {
+	return ec.DBFile != "" || ec.Database.Dialect != "" || ec.Database.DSN != ""
+}
+
+// DatabaseSource returns the dialect and the data source name of the
+// database. Without dialect and DSN, DBFile is used with SQLite.
+func (ec EventbotConfig) DatabaseSource() (dialect, dsn string, err error) {
+	dialect, dsn = ec.Database.Dialect, ec.Database.DSN
+	switch dialect {
+	case "", DB_SQLITE:
+		if dsn == "" {
+			dsn = ec.DBFile
+		}
+		if dsn == "" {
+			return "", "", fmt.Errorf("no database file given")
+		}
+		return DB_SQLITE, dsn, nil
+	case DB_POSTGRES, DB_MYSQL:
+		if dsn == "" {
+			return "", "", fmt.Errorf("no data source name given for %s", dialect)
+		}
+		return dialect, dsn, nil
+	}
+	return "", "", fmt.Errorf("unknown database dialect %q, use %s, %s or %s",
+		dialect, DB_SQLITE, DB_POSTGRES, DB_MYSQL)
+}
+
+// EffectiveMailJobs returns the configured mail jobs, or a daily mail
+// of today's events to EmailSettings.RecipientAddress for
+// configurations without MailJobs.
+func (ec EventbotConfig) EffectiveMailJobs() []MailJob {
+	if len(ec.MailJobs) > 0 || ec.EmailSettings.RecipientAddress == "" {
+		return ec.MailJobs
+	}
+	return []MailJob{{
+		Name:       "today",
+		Schedule:   "0 0 1 * * *",
+		Recipients: []string{ec.EmailSettings.RecipientAddress},
+		Window:     MAIL_WINDOW_TODAY,
+		Subject:    "Reminder: Heute beim Chaos inKL.",
+	}}
+}
+
+// FindChannel returns the configuration of the given channel. Channel
+// names are compared case-insensitively.
+func (bc BotConnection) FindChannel(name string) (ChannelConfig, bool) {
+	for _, cc := range bc.Channels {
+		if strings.EqualFold(cc.Name, name) {
+			return cc, true
+		}
+	}
+	return ChannelConfig{}, false
+}
+
+// FunctionsFor returns the bots that should run on the given connection.
+func (cfg Config) FunctionsFor(bc BotConnection) BotEnable {
+	if bc.Functions != nil {
+		return *bc.Functions
+	}
+	return cfg.Functions
+}
+
+// EnabledBots returns all bot connections that are enabled.
+func (cfg Config) EnabledBots() []BotConnection {
+	var retval []BotConnection
+	for _, bc := range cfg.Bots {
+		if bc.Enabled {
+			retval = append(retval, bc)
+		}
+	}
+	return retval
+}
+
 func LoadConfig(filename string) (Config, error) {
 	var cfg Config
-	_, err := toml.DecodeFile(filename, &cfg)
-	return cfg, err
+	md, err := toml.DecodeFile(filename, &cfg)
+	if err != nil {
+		return cfg, err
+	}
+	for _, key := range md.Undecoded() {
+		log.Printf("Config: ignoring unknown key %s in %s", key, filename)
+	}
+	for i := range cfg.Bots {
+		bc := &cfg.Bots[i]
+		if bc.Channel == "" {
+			continue
+		}
+		log.Printf("Config: Channel of %s@%s is deprecated, use Channels.", bc.Nick,
+			bc.Server)
+		if _, ok := bc.FindChannel(bc.Channel); !ok {
+			bc.Channels = append(bc.Channels, ChannelConfig{Name: bc.Channel})
+		}
+		bc.Channel = ""
+	}
+	if nick := cfg.General.OwnerNick; nick != "" {
+		log.Printf("Config: Ignoring OwnerNick %s, nicks do not identify users. "+
+			"Add a role binding of the owner to your hostmask, e.g. %s!*@example.com, "+
+			"or to your services account to General.Roles.", nick, nick)
+		cfg.General.OwnerNick = ""
+	}
+	return cfg, nil
 }
 
 func SaveConfig(filename string, cfg Config) error {
@@ -1,9 +1,11 @@
 package her0ld
 
 import (
+	"io/ioutil"
 	"os"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestConfigRoundTrip(t *testing.T) {
@@ -24,3 +26,192 @@ func TestConfigRoundTrip(t *testing.T) {
 			cfg, cfg2)
 	}
 }
+
+// Configurations of older versions name the owner by the nick, which
+// grants no role.
+func TestDeprecatedOwnerNick(t *testing.T) {
+	filename := "/tmp/her0ld-testsuite-owner-cfg"
+	err := ioutil.WriteFile(filename, []byte("[General]\nOwnerNick = \"alice\"\n"), 0600)
+	if err != nil {
+		t.Fatalf("Failed to write config: %s", err.Error())
+	}
+	defer os.Remove(filename)
+	cfg, err := LoadConfig(filename)
+	if err != nil {
+		t.Fatalf("Failed to load config: %s", err.Error())
+	}
+	if len(cfg.General.Roles) != 0 || cfg.General.OwnerNick != "" {
+		t.Fatalf("OwnerNick should be ignored, got %v and %q", cfg.General.Roles,
+			cfg.General.OwnerNick)
+	}
+}
+
+// Configurations of older versions have a single channel.
+func TestDeprecatedChannel(t *testing.T) {
+	filename := "/tmp/her0ld-testsuite-channel-cfg"
+	err := ioutil.WriteFile(filename, []byte("[[Bots]]\nChannel = \"#foo\"\n\n"+
+		"[[Bots]]\nChannel = \"#bar\"\n[[Bots.Channels]]\nName = \"#Bar\"\n"), 0600)
+	if err != nil {
+		t.Fatalf("Failed to write config: %s", err.Error())
+	}
+	defer os.Remove(filename)
+	cfg, err := LoadConfig(filename)
+	if err != nil {
+		t.Fatalf("Failed to load config: %s", err.Error())
+	}
+	if len(cfg.Bots) != 2 || cfg.Bots[0].Channel != "" ||
+		!reflect.DeepEqual(cfg.Bots[0].Channels, []ChannelConfig{{Name: "#foo"}}) {
+		t.Fatalf("Channel not added to the channels: %#v", cfg.Bots)
+	}
+	if len(cfg.Bots[1].Channels) != 1 {
+		t.Fatalf("Configured channel added twice: %#v", cfg.Bots[1].Channels)
+	}
+}
+
+func TestFunctionsFor(t *testing.T) {
+	cfg := MkExampleConfig()
+	if !reflect.DeepEqual(cfg.FunctionsFor(cfg.Bots[0]), cfg.Functions) {
+		t.Fatalf("Connection without bot selection should use the global functions")
+	}
+	if !reflect.DeepEqual(cfg.FunctionsFor(cfg.Bots[1]), *cfg.Bots[1].Functions) {
+		t.Fatalf("Connection bot selection should override the global functions")
+	}
+}
+
+func TestEnabledBots(t *testing.T) {
+	cfg := MkExampleConfig()
+	enabled := cfg.EnabledBots()
+	if len(enabled) != 1 {
+		t.Fatalf("Expected 1 enabled connection, got %d", len(enabled))
+	}
+	if enabled[0].Nick != cfg.Bots[0].Nick {
+		t.Fatalf("Wrong connection enabled: %s", enabled[0].Nick)
+	}
+}
+
+func TestChannelBotSelection(t *testing.T) {
+	cfg := MkExampleConfig()
+	events, ok := cfg.Bots[0].FindChannel("#CHANNEL0")
+	if !ok {
+		t.Fatalf("Channel lookup should ignore case")
+	}
+	if !events.BotEnabled("eventbot") {
+		t.Fatalf("Eventbot should be active in %s", events.Name)
+	}
+	offtopic, _ := cfg.Bots[0].FindChannel("#channel0-offtopic")
+	if offtopic.BotEnabled("Eventbot") {
+		t.Fatalf("Eventbot should not be active in %s", offtopic.Name)
+	}
+	all, _ := cfg.Bots[1].FindChannel("#channel1")
+	if !all.BotEnabled("Eventbot") || !all.BotEnabled("Pingbot") {
+		t.Fatalf("All bots should be active in a channel without bot list")
+	}
+	if _, ok := cfg.Bots[0].FindChannel("#unknown"); ok {
+		t.Fatalf("Found unconfigured channel")
+	}
+}
+
+func TestReconnectDelay(t *testing.T) {
+	rs := ReconnectSettings{InitialDelay: 2, MaxDelay: 30}
+	expected := []int{2, 4, 8, 16, 30, 30}
+	for attempt, seconds := range expected {
+		delay := rs.Delay(attempt)
+		if delay != time.Duration(seconds)*time.Second {
+			t.Fatalf("Attempt %d: expected delay of %ds, got %s", attempt,
+				seconds, delay)
+		}
+	}
+	var defaults ReconnectSettings
+	if defaults.Delay(0) != DEFAULT_RECONNECT_INITIAL_DELAY*time.Second {
+		t.Fatalf("Unexpected default initial delay %s", defaults.Delay(0))
+	}
+	if defaults.Delay(100) != DEFAULT_RECONNECT_MAX_DELAY*time.Second {
+		t.Fatalf("Unexpected default maximum delay %s", defaults.Delay(100))
+	}
+}
+
+func TestAuthValidation(t *testing.T) {
+	bc := BotConnection{EnableTLS: true, TLSCertFile: "/tmp/cert.pem"}
+	valid := []AuthSettings{
+		{},
+		{Method: AUTH_SASL_PLAIN, Account: "a", Password: "p"},
+		{Method: AUTH_SASL_EXTERNAL},
+		{Method: AUTH_NICKSERV, Password: "p"},
+		{Method: AUTH_SASL_EXTERNAL, NickServFallback: true, Password: "p"},
+	}
+	for _, as := range valid {
+		if err := as.Validate(bc); err != nil {
+			t.Fatalf("Settings %#v should be valid: %s", as, err.Error())
+		}
+	}
+	invalid := []AuthSettings{
+		{Method: "sasl-foo"},
+		{Method: AUTH_SASL_PLAIN, Account: "a"},
+		{Method: AUTH_NICKSERV},
+		{Method: AUTH_SASL_EXTERNAL, NickServFallback: true},
+	}
+	for _, as := range invalid {
+		if err := as.Validate(bc); err == nil {
+			t.Fatalf("Settings %#v should be invalid", as)
+		}
+	}
+	external := AuthSettings{Method: AUTH_SASL_EXTERNAL}
+	if err := external.Validate(BotConnection{}); err == nil {
+		t.Fatalf("SASL EXTERNAL without client certificate should be invalid")
+	}
+}
+
+func TestEffectiveMailJobs(t *testing.T) {
+	cfg := MkExampleConfig()
+	if !reflect.DeepEqual(cfg.EventbotCfg.EffectiveMailJobs(), cfg.EventbotCfg.MailJobs) {
+		t.Fatalf("Configured mail jobs should be used")
+	}
+	ec := EventbotConfig{EmailSettings: EmailSettings{RecipientAddress: "a@example.com"}}
+	jobs := ec.EffectiveMailJobs()
+	if len(jobs) != 1 || jobs[0].Window != MAIL_WINDOW_TODAY ||
+		!reflect.DeepEqual(jobs[0].Recipients, []string{"a@example.com"}) {
+		t.Fatalf("Unexpected mail jobs for a single recipient: %#v", jobs)
+	}
+	if jobs := (EventbotConfig{}).EffectiveMailJobs(); len(jobs) != 0 {
+		t.Fatalf("Unexpected mail jobs without recipients: %#v", jobs)
+	}
+}
+
+func TestEmailSettingsValidation(t *testing.T) {
+	for _, mode := range []string{"", MAIL_TLS_NONE, MAIL_TLS_STARTTLS, MAIL_TLS_IMPLICIT} {
+		if err := (EmailSettings{TLSMode: mode}).Validate(); err != nil {
+			t.Fatalf("TLS mode %q should be valid: %s", mode, err.Error())
+		}
+	}
+	if err := (EmailSettings{TLSMode: "ssl"}).Validate(); err == nil {
+		t.Fatalf("Unknown TLS mode should be invalid")
+	}
+	if err := (EmailSettings{MaxAttempts: -1}).Validate(); err == nil {
+		t.Fatalf("Negative number of attempts should be invalid")
+	}
+}
+
+func TestDatabaseSource(t *testing.T) {
+	for _, test := range []struct {
+		cfg            EventbotConfig
+		dialect, dsn   string
+		valid, present bool
+	}{
+		{EventbotConfig{DBFile: "/tmp/a.db"}, DB_SQLITE, "/tmp/a.db", true, true},
+		{EventbotConfig{DBFile: "/tmp/a.db", Database: DatabaseSettings{DSN: "/tmp/b.db"}},
+			DB_SQLITE, "/tmp/b.db", true, true},
+		{EventbotConfig{Database: DatabaseSettings{Dialect: DB_POSTGRES, DSN: "dbname=her0ld"}},
+			DB_POSTGRES, "dbname=her0ld", true, true},
+		{EventbotConfig{Database: DatabaseSettings{Dialect: DB_MYSQL}}, "", "", false, true},
+		{EventbotConfig{Database: DatabaseSettings{Dialect: "oracle", DSN: "x"}}, "", "", false, true},
+		{EventbotConfig{}, "", "", false, false},
+	} {
+		dialect, dsn, err := test.cfg.DatabaseSource()
+		if (err == nil) != test.valid || dialect != test.dialect || dsn != test.dsn {
+			t.Fatalf("%#v: unexpected source %s %s %v", test.cfg, dialect, dsn, err)
+		}
+		if test.cfg.HasDatabase() != test.present {
+			t.Fatalf("%#v: HasDatabase should be %v", test.cfg, test.present)
+		}
+	}
+}